@@ -0,0 +1,418 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//***************************************************
+//* ACCOUNTS
+//***************************************************
+
+// ACCOUNTKEYPREFIX is the key namespace of account records, it cannot collide
+// with assetID_type asset keys or accountID_assetID holding keys
+const ACCOUNTKEYPREFIX string = "account~"
+
+// ACCOUNTTYPE is the JSON tag for the type of an account
+const ACCOUNTTYPE string = "accountType"
+
+// ACCOUNTSTATUS is the JSON tag for the lifecycle status of an account
+const ACCOUNTSTATUS string = "status"
+
+// ACCOUNTSTATUSREASON is the JSON tag for the reason given for the last status change
+const ACCOUNTSTATUSREASON string = "statusReason"
+
+// Account statuses, an account without a status is active
+const (
+	// ACCOUNTACTIVE accounts can issue, send and receive
+	ACCOUNTACTIVE string = "active"
+	// ACCOUNTFROZEN accounts are locked, for example while under investigation
+	ACCOUNTFROZEN string = "frozen"
+	// ACCOUNTCLOSED accounts are retired for good
+	ACCOUNTCLOSED string = "closed"
+)
+
+// Account is the ledger record of a registered account. Fields that createAccount
+// does not know about are kept in Metadata.
+type Account struct {
+	ID              string                 `json:"accountID"`
+	Name            string                 `json:"acname"`
+	Type            string                 `json:"accountType,omitempty"`
+	Status          string                 `json:"status"`
+	StatusReason    string                 `json:"statusReason,omitempty"`
+	StatusChangedAt string                 `json:"statusChangedAt,omitempty"`
+	StatusChangedBy string                 `json:"statusChangedBy,omitempty"`
+	CreatedAt       string                 `json:"createdAt,omitempty"`
+	Metadata        map[string]interface{} `json:"metadata,omitempty"`
+	LastEvent       map[string]interface{} `json:"lastEvent,omitempty"`
+}
+
+// AccountStatusChange is the argument to freezeAccount, unfreezeAccount and closeAccount
+type AccountStatusChange struct {
+	AccountID string `json:"accountID"`
+	Reason    string `json:"reason"`
+}
+
+// accountKey returns the ledger key of an account
+func accountKey(accountID string) string {
+	return ACCOUNTKEYPREFIX + accountID
+}
+
+// legacyAccountKey is where accounts were stored before they had a namespace,
+// the key was built from an account type that was never set
+func legacyAccountKey(accountID string) string {
+	return accountID + "_"
+}
+
+// accountIDFromKey reverses accountKey and legacyAccountKey
+func accountIDFromKey(sAccountKey string) string {
+	if strings.HasPrefix(sAccountKey, ACCOUNTKEYPREFIX) {
+		return strings.TrimPrefix(sAccountKey, ACCOUNTKEYPREFIX)
+	}
+	return strings.TrimSuffix(sAccountKey, "_")
+}
+
+// accountExists returns true when the account was created, under either key
+func accountExists(stub shim.ChaincodeStubInterface, accountID string) bool {
+	return accountIsActive(stub, accountKey(accountID)) || accountIsActive(stub, legacyAccountKey(accountID))
+}
+
+// getAccount returns an account and whether it exists. An account still stored
+// under its legacy key is converted, and when migrate is true it is moved to its
+// new key together with its history. Queries cannot write so they pass false.
+func getAccount(stub shim.ChaincodeStubInterface, accountID string, migrate bool) (Account, bool, error) {
+	var account Account
+	if accountIsActive(stub, accountKey(accountID)) {
+		accountBytes, err := stub.GetState(accountKey(accountID))
+		if err != nil {
+			return account, false, fmt.Errorf("account %s GETSTATE failed: %s", accountID, err)
+		}
+		err = json.Unmarshal(accountBytes, &account)
+		if err != nil {
+			return account, false, fmt.Errorf("account %s unmarshal failed: %s", accountID, err)
+		}
+		return account, true, nil
+	}
+	if !accountIsActive(stub, legacyAccountKey(accountID)) {
+		return account, false, nil
+	}
+
+	// a legacy record is a free form map
+	var legacy ArgsMap
+	legacyBytes, err := stub.GetState(legacyAccountKey(accountID))
+	if err != nil {
+		return account, false, fmt.Errorf("account %s GETSTATE failed: %s", accountID, err)
+	}
+	err = json.Unmarshal(legacyBytes, &legacy)
+	if err != nil || legacy == nil {
+		return account, false, fmt.Errorf("legacy account %s unmarshal failed: %v", accountID, err)
+	}
+	account = accountFromMap(legacy)
+	account.ID = accountID
+	if !migrate {
+		return account, true, nil
+	}
+	err = migrateAccount(stub, account)
+	if err != nil {
+		return account, false, err
+	}
+	return account, true, nil
+}
+
+// accountFromMap builds an Account from a free form account state or createAccount arg
+func accountFromMap(m ArgsMap) Account {
+	var account Account
+	account.Metadata = make(map[string]interface{})
+	for k, v := range m {
+		s, _ := v.(string)
+		switch k {
+		case ACCOUNTID:
+			account.ID = s
+		case ACCOUNTNAME:
+			account.Name = s
+		case ACCOUNTTYPE:
+			account.Type = s
+		case ACCOUNTSTATUS:
+			account.Status = s
+		case ACCOUNTSTATUSREASON:
+			account.StatusReason = s
+		case "statusChangedAt":
+			account.StatusChangedAt = s
+		case "statusChangedBy":
+			account.StatusChangedBy = s
+		case "createdAt":
+			account.CreatedAt = s
+		case "metadata":
+			if md, found := v.(map[string]interface{}); found {
+				for mk, mv := range md {
+					account.Metadata[mk] = mv
+				}
+			}
+		case "lastEvent":
+			account.LastEvent, _ = v.(map[string]interface{})
+		case "alerts", "incompliance", CALLER:
+			// contract bookkeeping, not account data
+		default:
+			account.Metadata[k] = v
+		}
+	}
+	if account.Status == "" {
+		account.Status = ACCOUNTACTIVE
+	}
+	if len(account.Metadata) == 0 {
+		account.Metadata = nil
+	}
+	return account
+}
+
+func init() {
+	registerMigration(Migration{Name: "accountKeys", From: "1.0", To: "1.1", After: "contractStateIndexes",
+		run: migrateAccountKeys})
+}
+
+// migrateAccountKeys moves every account still stored under its legacy key to
+// the account namespace, which getAccount otherwise does on first use
+func migrateAccountKeys(stub shim.ChaincodeStubInterface, state *ContractState, record *MigrationRecord) error {
+	keys, err := getActiveAccounts(stub)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if strings.HasPrefix(key, ACCOUNTKEYPREFIX) {
+			continue
+		}
+		_, _, err = getAccount(stub, accountIDFromKey(key), true)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateAccount moves a legacy account record and its history to the account
+// namespace and swaps the key in the account index
+func migrateAccount(stub shim.ChaincodeStubInterface, account Account) error {
+	oldKey := legacyAccountKey(account.ID)
+	newKey := accountKey(account.ID)
+	log.Noticef("migrating account %s from key %s to key %s", account.ID, oldKey, newKey)
+
+	err := putAccount(stub, account)
+	if err != nil {
+		return err
+	}
+	err = moveStateHistory(stub, oldKey, newKey)
+	if err != nil {
+		return fmt.Errorf("account %s history move failed: %s", account.ID, err)
+	}
+	err = stub.DelState(oldKey)
+	if err != nil {
+		return fmt.Errorf("account %s legacy DELSTATE failed: %s", account.ID, err)
+	}
+
+	err = removeFromIndex(stub, ACCOUNTINDEX, oldKey)
+	if err != nil {
+		return err
+	}
+	return addToIndex(stub, ACCOUNTINDEX, newKey)
+}
+
+// putAccount marshals an account and writes it under its key
+func putAccount(stub shim.ChaincodeStubInterface, account Account) error {
+	_, err := putAccountJSON(stub, account)
+	return err
+}
+
+// putAccountJSON is putAccount returning the JSON it wrote
+func putAccountJSON(stub shim.ChaincodeStubInterface, account Account) ([]byte, error) {
+	accountBytes, err := json.Marshal(account)
+	if err != nil {
+		return nil, fmt.Errorf("account %s marshal failed: %s", account.ID, err)
+	}
+	err = stub.PutState(accountKey(account.ID), accountBytes)
+	if err != nil {
+		return nil, fmt.Errorf("account %s PUTSTATE failed: %s", account.ID, err)
+	}
+	return accountBytes, nil
+}
+
+// ************************************
+// freezeAccount
+// ************************************
+func (t *SimpleChaincode) freezeAccount(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	return nil, changeAccountStatus(stub, "freezeAccount", args, []string{ACCOUNTACTIVE}, ACCOUNTFROZEN)
+}
+
+// ************************************
+// unfreezeAccount
+// ************************************
+func (t *SimpleChaincode) unfreezeAccount(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	return nil, changeAccountStatus(stub, "unfreezeAccount", args, []string{ACCOUNTFROZEN}, ACCOUNTACTIVE)
+}
+
+// ************************************
+// closeAccount
+// ************************************
+func (t *SimpleChaincode) closeAccount(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	return nil, changeAccountStatus(stub, "closeAccount", args, []string{ACCOUNTACTIVE, ACCOUNTFROZEN}, ACCOUNTCLOSED)
+}
+
+// changeAccountStatus moves an account from one of the allowed statuses to the
+// new status, recording the reason, time and caller in the account state. The
+// owner can freeze their account or close it while it is active, lifting a
+// freeze or closing a frozen account needs an admin.
+func changeAccountStatus(stub shim.ChaincodeStubInterface, function string, args []string, from []string, to string) error {
+	var change AccountStatusChange
+	var argsMap ArgsMap
+	var err error
+
+	log.Infof("Entering %s", function)
+
+	if len(args) != 1 {
+		err = fmt.Errorf("%s expects one JSON object with accountID and reason", function)
+		log.Error(err)
+		return err
+	}
+	err = json.Unmarshal([]byte(args[0]), &change)
+	if err == nil {
+		err = json.Unmarshal([]byte(args[0]), &argsMap)
+	}
+	if err != nil {
+		err = fmt.Errorf("%s failed to unmarshal arg: %s", function, err)
+		log.Error(err)
+		return err
+	}
+	if change.AccountID == "" || change.Reason == "" {
+		err = fmt.Errorf("%s arg must include accountID and reason", function)
+		log.Error(err)
+		return err
+	}
+
+	// an admin need not have an account of their own
+	caller, err := getCaller(stub, argsMap)
+	admin := callerHasRole(stub, ADMINROLE)
+	if err != nil && !admin {
+		err = fmt.Errorf("%s %s", function, err)
+		log.Error(err)
+		return err
+	}
+	if caller != change.AccountID && !admin {
+		err = fmt.Errorf("%s caller %s is neither the owner of account %s nor an %s", function, caller, change.AccountID, ADMINROLE)
+		log.Error(err)
+		return err
+	}
+
+	account, found, err := getAccount(stub, change.AccountID, true)
+	if err != nil {
+		err = fmt.Errorf("%s %s", function, err)
+		log.Error(err)
+		return err
+	}
+	if !found {
+		err = &UnknownAccountError{ACCOUNTID, change.AccountID}
+		log.Errorf("%s %s", function, err)
+		return err
+	}
+	allowed := false
+	for _, s := range from {
+		allowed = allowed || s == account.Status
+	}
+	if !allowed {
+		err = fmt.Errorf("%s account %s is %s", function, change.AccountID, account.Status)
+		log.Error(err)
+		return err
+	}
+	if !admin && (to == ACCOUNTACTIVE || account.Status == ACCOUNTFROZEN) {
+		err = fmt.Errorf("%s account %s is %s, only an %s can make it %s", function, change.AccountID, account.Status, ADMINROLE, to)
+		log.Error(err)
+		return err
+	}
+
+	// an account can only be closed once it holds nothing
+	if to == ACCOUNTCLOSED {
+		holdings, err := getPortfolioBalances(stub, change.AccountID)
+		if err != nil {
+			err = fmt.Errorf("%s %s", function, err)
+			log.Error(err)
+			return err
+		}
+		for _, h := range holdings {
+			if h.Amount.Sign() != 0 {
+				err = fmt.Errorf("%s account %s still holds %s of asset %s", function, change.AccountID, h.Amount, h.AssetID)
+				log.Error(err)
+				return err
+			}
+		}
+	}
+
+	account.Status = to
+	account.StatusReason = change.Reason
+	account.StatusChangedAt = txTimestamp(stub).Format(time.RFC3339Nano)
+	account.StatusChangedBy = caller
+	account.LastEvent = map[string]interface{}{"function": function, "args": args[0]}
+
+	stateJSON, err := putAccountJSON(stub, account)
+	if err != nil {
+		err = fmt.Errorf("%s %s", function, err)
+		log.Error(err)
+		return err
+	}
+	log.Noticef("%s account %s is now %s: %s", function, change.AccountID, to, change.Reason)
+
+	err = pushRecentState(stub, RECENTACCOUNTS, string(stateJSON))
+	if err != nil {
+		err = fmt.Errorf("%s account %s push to recentstates failed: %s", function, change.AccountID, err)
+		log.Error(err)
+		return err
+	}
+	err = updateStateHistory(stub, accountKey(account.ID), string(stateJSON))
+	if err != nil {
+		err = fmt.Errorf("%s account %s push to history failed: %s", function, change.AccountID, err)
+		log.Error(err)
+		return err
+	}
+	return nil
+}
+
+// UnknownAccountError is returned when an operation names an account that was
+// never created with createAccount
+type UnknownAccountError struct {
+	Party     string
+	AccountID string
+}
+
+func (e *UnknownAccountError) Error() string {
+	return fmt.Sprintf("%s %s is not a registered account", e.Party, e.AccountID)
+}
+
+// AccountStandingError is returned when an operation names an account that is
+// frozen or closed
+type AccountStandingError struct {
+	Party     string
+	AccountID string
+	Status    string
+	Reason    string
+}
+
+func (e *AccountStandingError) Error() string {
+	return fmt.Sprintf("%s %s is %s: %s", e.Party, e.AccountID, e.Status, e.Reason)
+}
+
+// checkParty requires that the account named by the party argument, such as
+// accountIDTo, was created and is in good standing
+func checkParty(stub shim.ChaincodeStubInterface, party string, accountID string) error {
+	account, found, err := getAccount(stub, accountID, true)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return &UnknownAccountError{party, accountID}
+	}
+	if account.Status != ACCOUNTACTIVE {
+		return &AccountStandingError{party, accountID, account.Status, account.StatusReason}
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"testing"
+)
+
+// accountStatus returns the committed status of an account
+func (s *memStub) accountStatus(accountID string) string {
+	s.t.Helper()
+	status, _ := s.stateMap(accountKey(accountID))[ACCOUNTSTATUS].(string)
+	return status
+}
+
+// TestAccountStatusChanges runs each status change by the owner, by another
+// account and by an admin, from each status
+func TestAccountStatusChanges(t *testing.T) {
+	tests := []struct {
+		from     string
+		function string
+		caller   string
+		want     string
+	}{
+		{ACCOUNTACTIVE, "freezeAccount", "carol", ACCOUNTFROZEN},
+		{ACCOUNTACTIVE, "freezeAccount", "admin", ACCOUNTFROZEN},
+		{ACCOUNTACTIVE, "freezeAccount", "dave", ""},
+		{ACCOUNTACTIVE, "closeAccount", "carol", ACCOUNTCLOSED},
+		{ACCOUNTACTIVE, "closeAccount", "admin", ACCOUNTCLOSED},
+		{ACCOUNTACTIVE, "closeAccount", "dave", ""},
+		{ACCOUNTACTIVE, "unfreezeAccount", "admin", ""},
+		// a freeze holds until an admin lifts it
+		{ACCOUNTFROZEN, "unfreezeAccount", "carol", ""},
+		{ACCOUNTFROZEN, "unfreezeAccount", "dave", ""},
+		{ACCOUNTFROZEN, "unfreezeAccount", "admin", ACCOUNTACTIVE},
+		{ACCOUNTFROZEN, "closeAccount", "carol", ""},
+		{ACCOUNTFROZEN, "closeAccount", "admin", ACCOUNTCLOSED},
+		{ACCOUNTFROZEN, "freezeAccount", "admin", ""},
+		{ACCOUNTCLOSED, "unfreezeAccount", "admin", ""},
+		{ACCOUNTCLOSED, "freezeAccount", "admin", ""},
+		{ACCOUNTCLOSED, "closeAccount", "admin", ""},
+	}
+	for _, tt := range tests {
+		s := newMemStub(t)
+		s.as("carol").mustInvoke("createAccount", `{"accountID":"carol","acname":"Carol"}`)
+		switch tt.from {
+		case ACCOUNTFROZEN:
+			s.asAdmin().mustInvoke("freezeAccount", `{"accountID":"carol","reason":"audit"}`)
+		case ACCOUNTCLOSED:
+			s.asAdmin().mustInvoke("closeAccount", `{"accountID":"carol","reason":"moved"}`)
+		}
+		if tt.caller == "admin" {
+			s.asAdmin()
+		} else {
+			s.as(tt.caller)
+		}
+		err := s.invoke(tt.function, `{"accountID":"carol","reason":"test"}`)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("%s of a %s account by %s succeeded", tt.function, tt.from, tt.caller)
+			}
+			if got := s.accountStatus("carol"); got != tt.from {
+				t.Errorf("%s of a %s account by %s left it %s", tt.function, tt.from, tt.caller, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s of a %s account by %s failed: %s", tt.function, tt.from, tt.caller, err)
+			continue
+		}
+		account := s.stateMap(accountKey("carol"))
+		if account[ACCOUNTSTATUS] != tt.want || account[ACCOUNTSTATUSREASON] != "test" {
+			t.Errorf("%s of a %s account by %s made it %v for %v, want %s", tt.function, tt.from, tt.caller,
+				account[ACCOUNTSTATUS], account[ACCOUNTSTATUSREASON], tt.want)
+		}
+	}
+}
+
+// TestCloseAccountHolding checks that only an empty account can be closed
+func TestCloseAccountHolding(t *testing.T) {
+	s := withHoldings(t)
+	s.as("alice").mustFailInvoke("closeAccount", `{"accountID":"alice","reason":"moving"}`)
+	s.mustInvoke("transferAsset", `{"accountID":"alice","accountIDTo":"bob","assetID":"USD","amount":"100"}`)
+	s.mustInvoke("closeAccount", `{"accountID":"alice","reason":"moving"}`)
+	if got := s.accountStatus("alice"); got != ACCOUNTCLOSED {
+		t.Errorf("alice's account is %s, want %s", got, ACCOUNTCLOSED)
+	}
+}
+
+// TestPartiesInGoodStanding issues and transfers with each party frozen, closed
+// or never registered
+func TestPartiesInGoodStanding(t *testing.T) {
+	tests := []struct {
+		name     string
+		function string
+		arg      string
+	}{
+		{"issue to frozen", "issueAsset", `{"accountID":"frozen","assetID":"USD","amount":"1"}`},
+		{"issue to closed", "issueAsset", `{"accountID":"closed","assetID":"USD","amount":"1"}`},
+		{"issue to unknown", "issueAsset", `{"accountID":"nobody","assetID":"USD","amount":"1"}`},
+		{"send from frozen", "transferAsset", `{"accountID":"frozen","accountIDTo":"alice","assetID":"USD","amount":"1"}`},
+		{"send to frozen", "transferAsset", `{"accountID":"alice","accountIDTo":"frozen","assetID":"USD","amount":"1"}`},
+		{"send to closed", "transferAsset", `{"accountID":"alice","accountIDTo":"closed","assetID":"USD","amount":"1"}`},
+		{"send to unknown", "transferAsset", `{"accountID":"alice","accountIDTo":"nobody","assetID":"USD","amount":"1"}`},
+	}
+	s := withHoldings(t)
+	for _, id := range []string{"frozen", "closed"} {
+		s.as(id).mustInvoke("createAccount", `{"accountID":"`+id+`","acname":"`+id+`"}`)
+	}
+	s.as("bank").mustInvoke("issueAsset", `{"accountID":"frozen","assetID":"USD","amount":"10"}`)
+	s.asAdmin().mustInvoke("freezeAccount", `{"accountID":"frozen","reason":"audit"}`)
+	s.asAdmin().mustInvoke("closeAccount", `{"accountID":"closed","reason":"moved"}`)
+	before := s.snapshot()
+	for _, tt := range tests {
+		s.as("bank")
+		err := s.invoke(tt.function, tt.arg)
+		switch err.(type) {
+		case *UnknownAccountError, *AccountStandingError:
+		default:
+			t.Errorf("%s = %v, want an account error", tt.name, err)
+		}
+	}
+	s.assertUnchanged(before, "a party not in good standing")
+}
+
+func TestAccountRecords(t *testing.T) {
+	s := newMemStub(t)
+	s.as("dave").mustInvoke("createAccount", `{"accountID":"dave","acname":"Dave","accountType":"broker","city":"Oslo"}`)
+	s.mustFailInvoke("createAccount", `{"accountID":"dave","acname":"Dave again"}`)
+	if s.stateMap(legacyAccountKey("dave")) != nil || !inIndex(s, ACCOUNTINDEX, accountKey("dave")) {
+		t.Error("dave's account is not under its account key")
+	}
+	account := s.mustRead("readAccount", `{"accountID":"dave"}`).(map[string]interface{})
+	metadata, _ := account["metadata"].(map[string]interface{})
+	if account[ACCOUNTNAME] != "Dave" || account[ACCOUNTTYPE] != "broker" || account[ACCOUNTSTATUS] != ACCOUNTACTIVE ||
+		metadata["city"] != "Oslo" || len(metadata) != 1 {
+		t.Errorf("dave's account reads %v, want an active broker named Dave in Oslo", account)
+	}
+	_, err := s.read("readAccount", `{"accountID":"erin"}`)
+	if _, isUnknown := err.(*UnknownAccountError); !isUnknown {
+		t.Errorf("readAccount of an unknown account = %v, want an UnknownAccountError", err)
+	}
+}
+
+// TestLegacyAccount reads an account stored under its key from before accounts
+// had a namespace, which the first invoke that changes it moves
+func TestLegacyAccount(t *testing.T) {
+	s := newMemStub(t)
+	legacy := `{"accountID":"carol","acname":"Carol","city":"Oslo"}`
+	s.state[legacyAccountKey("carol")] = []byte(legacy)
+	addToIndex(s, ACCOUNTINDEX, legacyAccountKey("carol"))
+	createStateHistory(s, legacyAccountKey("carol"), legacy)
+
+	before := s.snapshot()
+	account := s.mustRead("readAccount", `{"accountID":"carol"}`).(map[string]interface{})
+	if account[ACCOUNTID] != "carol" || account[ACCOUNTSTATUS] != ACCOUNTACTIVE {
+		t.Errorf("legacy account reads %v, want carol active", account)
+	}
+	s.assertUnchanged(before, "reading a legacy account")
+
+	s.asAdmin().mustInvoke("freezeAccount", `{"accountID":"carol","reason":"audit"}`)
+	if s.stateMap(legacyAccountKey("carol")) != nil || inIndex(s, ACCOUNTINDEX, legacyAccountKey("carol")) {
+		t.Error("the legacy account key is still in use")
+	}
+	if !inIndex(s, ACCOUNTINDEX, accountKey("carol")) || s.accountStatus("carol") != ACCOUNTFROZEN {
+		t.Errorf("carol's account is not frozen under its account key: %v", s.stateMap(accountKey("carol")))
+	}
+	metadata, _ := s.stateMap(accountKey("carol"))["metadata"].(map[string]interface{})
+	if metadata["city"] != "Oslo" {
+		t.Errorf("carol's metadata is %v after the move, want the legacy city", metadata)
+	}
+	if s.stateMap(legacyAccountKey("carol")+STATEHISTORYKEY) != nil || s.stateMap(accountKey("carol")+STATEHISTORYKEY) == nil {
+		t.Error("carol's history did not move with the account")
+	}
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//***************************************************
+//* ALERT RECORDS
+//***************************************************
+
+// AlertRecord is one incident of an alert on an asset, from the event that
+// raised it to the event that cleared it. TriggeringValues holds the values of
+// the properties and thresholds the rule read when the alert was raised.
+// Durations are in seconds.
+type AlertRecord struct {
+	Alert            string                 `json:"alert"`
+	Rule             string                 `json:"rule,omitempty"`
+	Severity         string                 `json:"severity,omitempty"`
+	RaisedAt         string                 `json:"raisedAt,omitempty"`
+	ClearedAt        string                 `json:"clearedAt,omitempty"`
+	Duration         *float64               `json:"duration,omitempty"`
+	AcknowledgedBy   string                 `json:"acknowledgedBy,omitempty"`
+	AcknowledgedAt   string                 `json:"acknowledgedAt,omitempty"`
+	ResponseTime     *float64               `json:"responseTime,omitempty"`
+	TriggeringValues map[string]interface{} `json:"triggeringValues,omitempty"`
+}
+
+// AlertRecords maps alert name to its latest incident
+type AlertRecords map[string]*AlertRecord
+
+// alertRecordsFromMap converts the records section of an unmarshaled state
+func alertRecordsFromMap(v interface{}) AlertRecords {
+	records := make(AlertRecords)
+	if v == nil {
+		return records
+	}
+	recordsBytes, err := json.Marshal(v)
+	if err == nil {
+		err = json.Unmarshal(recordsBytes, &records)
+	}
+	if err != nil {
+		log.Warningf("alert records could not be read, they are dropped: %s", err)
+		return make(AlertRecords)
+	}
+	return records
+}
+
+// open starts a new incident of the rule's alert, replacing the last one
+func (records AlertRecords) open(stub shim.ChaincodeStubInterface, rule *Rule, ctx *ruleContext) {
+	values := make(map[string]interface{})
+	for _, path := range rule.Condition.paths() {
+		if v, found := getObject(ctx.state, path); found {
+			values[path] = v
+		}
+	}
+	for _, name := range rule.thresholdNames() {
+		if v, found := ctx.thresholds[name]; found {
+			values[name] = v
+		}
+	}
+	records[rule.alertName()] = &AlertRecord{
+		Alert:            rule.alertName(),
+		Rule:             rule.Name,
+		Severity:         rule.Severity,
+		RaisedAt:         txTimestamp(stub).Format(time.RFC3339Nano),
+		TriggeringValues: values,
+	}
+}
+
+// close ends the current incident of an alert
+func (records AlertRecords) close(stub shim.ChaincodeStubInterface, alert string) {
+	record, found := records[alert]
+	if !found || record.ClearedAt != "" {
+		return
+	}
+	clearedAt := txTimestamp(stub)
+	record.ClearedAt = clearedAt.Format(time.RFC3339Nano)
+	record.Duration = secondsSince(record.RaisedAt, clearedAt)
+}
+
+// secondsSince returns the seconds from an RFC3339 timestamp to t, or nil
+// when the timestamp is unknown
+func secondsSince(from string, t time.Time) *float64 {
+	start, err := time.Parse(time.RFC3339Nano, from)
+	if err != nil {
+		return nil
+	}
+	seconds := t.Sub(start).Seconds()
+	return &seconds
+}
+
+// paths lists the properties that a condition reads
+func (c *Condition) paths() []string {
+	names := make(map[string]bool)
+	c.collectPaths(names)
+	list := make([]string, 0, len(names))
+	for name := range names {
+		list = append(list, name)
+	}
+	sort.Strings(list)
+	return list
+}
+
+func (c *Condition) collectPaths(names map[string]bool) {
+	for i := range c.Args {
+		c.Args[i].collectPaths(names)
+	}
+	c.Left.collectPaths(names)
+	c.Right.collectPaths(names)
+}
+
+func (o *Operand) collectPaths(names map[string]bool) {
+	if o == nil {
+		return
+	}
+	if o.Path != "" {
+		names[o.Path] = true
+	}
+	for i := range o.Args {
+		o.Args[i].collectPaths(names)
+	}
+}
+
+// AcknowledgeAlert is the acknowledgeAlert argument, assettype and name
+// resolve the asset as they do for updateAsset
+type AcknowledgeAlert struct {
+	AssetID string `json:"assetID"`
+	Alert   string `json:"alert"`
+}
+
+// ************************************
+// acknowledgeAlert
+// ************************************
+func (t *SimpleChaincode) acknowledgeAlert(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var ack AcknowledgeAlert
+	var argsMap ArgsMap
+	var ledgerMap ArgsMap
+	var err error
+
+	log.Info("Entering acknowledgeAlert")
+
+	if len(args) != 1 {
+		err = errors.New("acknowledgeAlert expects one JSON object with assetID and alert")
+		log.Error(err)
+		return nil, err
+	}
+	err = json.Unmarshal([]byte(args[0]), &ack)
+	if err == nil {
+		err = json.Unmarshal([]byte(args[0]), &argsMap)
+	}
+	if err != nil {
+		err = fmt.Errorf("acknowledgeAlert failed to unmarshal arg: %s", err)
+		log.Error(err)
+		return nil, err
+	}
+	if ack.AssetID == "" || ack.Alert == "" {
+		err = errors.New("acknowledgeAlert arg must include assetID and alert")
+		log.Error(err)
+		return nil, err
+	}
+	caller, err := getCaller(stub, argsMap)
+	if err != nil {
+		err = fmt.Errorf("acknowledgeAlert %s", err)
+		log.Error(err)
+		return nil, err
+	}
+
+	at, err := resolveAssetType(stub, argsMap, ack.AssetID)
+	if err != nil {
+		err = fmt.Errorf("acknowledgeAlert %s", err)
+		log.Error(err)
+		return nil, err
+	}
+	sAssetKey := ack.AssetID + "_" + at.Name
+	if !assetIsActive(stub, sAssetKey) {
+		err = fmt.Errorf("acknowledgeAlert asset %s of type %s does not exist", ack.AssetID, at.Name)
+		log.Error(err)
+		return nil, err
+	}
+	assetBytes, err := stub.GetState(sAssetKey)
+	if err == nil {
+		err = json.Unmarshal(assetBytes, &ledgerMap)
+	}
+	if err != nil || ledgerMap == nil {
+		err = fmt.Errorf("acknowledgeAlert asset %s of type %s could not be read: %v", ack.AssetID, at.Name, err)
+		log.Error(err)
+		return nil, err
+	}
+
+	alerts := newAlertStatus()
+	if a, found := ledgerMap["alerts"].(map[string]interface{}); found {
+		alerts.alertStatusFromMap(a)
+	}
+	if alerts.Records == nil {
+		alerts.Records = make(AlertRecords)
+	}
+	record, found := alerts.Records[ack.Alert]
+	if !found {
+		if !contains([]string(alerts.Active), ack.Alert) {
+			err = fmt.Errorf("acknowledgeAlert asset %s has no alert %s", ack.AssetID, ack.Alert)
+			log.Error(err)
+			return nil, err
+		}
+		// raised before alerts were recorded, so when is not known
+		record = &AlertRecord{Alert: ack.Alert}
+		alerts.Records[ack.Alert] = record
+	}
+	if record.AcknowledgedBy != "" {
+		err = fmt.Errorf("acknowledgeAlert alert %s on asset %s was already acknowledged by %s", ack.Alert, ack.AssetID, record.AcknowledgedBy)
+		log.Error(err)
+		return nil, err
+	}
+	acknowledgedAt := txTimestamp(stub)
+	record.AcknowledgedBy = caller
+	record.AcknowledgedAt = acknowledgedAt.Format(time.RFC3339Nano)
+	record.ResponseTime = secondsSince(record.RaisedAt, acknowledgedAt)
+
+	ledgerMap["alerts"] = alerts
+	ledgerMap["lastEvent"] = map[string]interface{}{"function": "acknowledgeAlert", "args": args[0]}
+	stateJSON, err := json.Marshal(ledgerMap)
+	if err != nil {
+		err = fmt.Errorf("acknowledgeAlert asset %s marshal failed: %s", ack.AssetID, err)
+		log.Error(err)
+		return nil, err
+	}
+	err = stub.PutState(sAssetKey, stateJSON)
+	if err != nil {
+		err = fmt.Errorf("acknowledgeAlert asset %s PUTSTATE failed: %s", ack.AssetID, err)
+		log.Error(err)
+		return nil, err
+	}
+	err = pushRecentState(stub, RECENTDEVICES, string(stateJSON))
+	if err != nil {
+		err = fmt.Errorf("acknowledgeAlert asset %s push to recentstates failed: %s", ack.AssetID, err)
+		log.Error(err)
+		return nil, err
+	}
+	err = updateStateHistory(stub, sAssetKey, string(stateJSON))
+	if err != nil {
+		err = fmt.Errorf("acknowledgeAlert asset %s push to history failed: %s", ack.AssetID, err)
+		log.Error(err)
+		return nil, err
+	}
+	log.Noticef("acknowledgeAlert alert %s on asset %s acknowledged by %s", ack.Alert, ack.AssetID, caller)
+	return nil, nil
+}
+
+// ActiveAlert is an active alert of one asset
+type ActiveAlert struct {
+	AssetID   string       `json:"assetID"`
+	AssetType string       `json:"assettype"`
+	Alert     string       `json:"alert"`
+	Record    *AlertRecord `json:"record,omitempty"`
+}
+
+// ************************************
+// readActiveAlerts
+// ************************************
+func (t *SimpleChaincode) readActiveAlerts(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var err error
+
+	aa, err := getActiveAssets(stub)
+	if err != nil {
+		err = fmt.Errorf("readActiveAlerts failed to get the active assets: %s", err)
+		log.Error(err)
+		return nil, err
+	}
+	results := make([]ActiveAlert, 0)
+	for _, sAssetKey := range aa {
+		var state ArgsMap
+		assetBytes, err := stub.GetState(sAssetKey)
+		if err == nil {
+			err = json.Unmarshal(assetBytes, &state)
+		}
+		if err != nil {
+			// best efforts, return what we can
+			log.Errorf("readActiveAlerts asset %s could not be read: %s", sAssetKey, err)
+			continue
+		}
+		a, found := state["alerts"].(map[string]interface{})
+		if !found {
+			continue
+		}
+		alerts := newAlertStatus()
+		alerts.alertStatusFromMap(a)
+		assetID, _ := state[ASSETID].(string)
+		assetType, _ := state[ASSETTYPE].(string)
+		for _, alert := range alerts.Active {
+			results = append(results, ActiveAlert{assetID, assetType, alert, alerts.Records[alert]})
+		}
+	}
+	return json.Marshal(results)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// alertRecord returns an asset's committed record of an alert
+func (s *memStub) alertRecord(sAssetKey string, alert string) map[string]interface{} {
+	s.t.Helper()
+	alerts, _ := s.stateMap(sAssetKey)["alerts"].(map[string]interface{})
+	records, _ := alerts["records"].(map[string]interface{})
+	record, _ := records[alert].(map[string]interface{})
+	if record == nil {
+		s.t.Fatalf("asset %s has no record of alert %s: %v", sAssetKey, alert, alerts)
+	}
+	return record
+}
+
+// TestAlertLifecycle raises an alert, acknowledges it and clears it, each
+// transaction of the stub is a minute after the one before
+func TestAlertLifecycle(t *testing.T) {
+	const alert = "RPM_LESS_THAN_20PERCENT"
+	s := newMemStub(t)
+	s.as("tech")
+	s.mustInvoke("createAsset", `{"assetID":"m1","assettype":"motor","rpm":100,"max_rpm":1000}`)
+	raised := s.now
+	raisedAt := raised.Format(time.RFC3339Nano)
+	record := s.alertRecord("m1_motor", alert)
+	if record["raisedAt"] != raisedAt || record["rule"] != "rpmCheck" || record["severity"] != SEVERITYCRITICAL {
+		t.Errorf("raised record is %v, want rpmCheck raised at %s", record, raisedAt)
+	}
+	values, _ := record["triggeringValues"].(map[string]interface{})
+	if values["rpm"] != 100.0 || values[MINRPMPERCENT] != 20.0 {
+		t.Errorf("triggering values are %v, want rpm 100 and %s 20", values, MINRPMPERCENT)
+	}
+
+	s.mustInvoke("acknowledgeAlert", `{"assetID":"m1","alert":"`+alert+`"}`)
+	record = s.alertRecord("m1_motor", alert)
+	if record["acknowledgedBy"] != "tech" || record["responseTime"] != 60.0 {
+		t.Errorf("acknowledged record is %v, want tech after 60 seconds", record)
+	}
+	s.mustFailInvoke("acknowledgeAlert", `{"assetID":"m1","alert":"`+alert+`"}`)
+
+	s.mustInvoke("updateAsset", `{"assetID":"m1","rpm":900}`)
+	record = s.alertRecord("m1_motor", alert)
+	duration := s.now.Sub(raised).Seconds()
+	if record["clearedAt"] != s.now.Format(time.RFC3339Nano) || record["duration"] != duration {
+		t.Errorf("cleared record is %v, want a duration of %v seconds", record, duration)
+	}
+	if record["raisedAt"] != raisedAt || record["acknowledgedBy"] != "tech" {
+		t.Errorf("clearing lost when the alert was raised or acknowledged: %v", record)
+	}
+	s.mustFailInvoke("acknowledgeAlert", `{"assetID":"m1","alert":"HVAC_OVERHEAT"}`)
+}
+
+// TestInvokeNeedsTimestamp checks that nothing is written with the local
+// clock, which every peer would read differently
+func TestInvokeNeedsTimestamp(t *testing.T) {
+	s := newMemStub(t)
+	s.as("tech").mustInvoke("createAsset", `{"assetID":"m1","rpm":100,"max_rpm":1000}`)
+	before := s.snapshot()
+	s.noTimestamp = true
+	s.mustFailInvoke("createAsset", `{"assetID":"m2","rpm":100,"max_rpm":1000}`)
+	s.mustFailInvoke("acknowledgeAlert", `{"assetID":"m1","alert":"RPM_LESS_THAN_20PERCENT"}`)
+	s.begin()
+	_, err := s.cc.Init(s, "init", []string{`{"version":"` + MYVERSION + `"}`})
+	s.end(err)
+	if err == nil {
+		t.Error("Init succeeded without a timestamp")
+	}
+	s.assertUnchanged(before, "an invoke without a timestamp")
+
+	// a query writes nothing, so it may use the local clock
+	_, err = s.read("readComplianceReport", "")
+	if err != nil {
+		t.Errorf("readComplianceReport without a timestamp failed: %s", err)
+	}
+}
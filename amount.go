@@ -0,0 +1,368 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//***************************************************
+//* AMOUNTS
+//***************************************************
+
+// DEFAULTASSETSCALE is the number of decimal places used for an assetID whose
+// scale has never been set
+const DEFAULTASSETSCALE int = 2
+
+// MAXASSETSCALE limits the number of decimal places an asset can be given
+const MAXASSETSCALE int = 18
+
+// ASSETSCALEKEYPREFIX prefixes the ledger key holding the scale of an assetID
+const ASSETSCALEKEYPREFIX string = "AssetScale_"
+
+// AMOUNT is the JSON tag for the quantity in holdings, issues and transfers
+const AMOUNT string = "amount"
+
+// Amount is an exact decimal quantity of an asset. It is held as an integer
+// count of the smallest unit at the asset's scale, so 12.5 at scale 2 is 1250.
+// On the ledger it is always written as a canonical string such as "12.50".
+type Amount struct {
+	units *big.Int
+	scale int
+}
+
+// ZeroAmount returns a zero quantity at the given scale
+func ZeroAmount(scale int) Amount {
+	return Amount{new(big.Int), scale}
+}
+
+// ParseAmount parses a plain decimal string such as "12.5" or "-3" at the given
+// scale. More decimal places than the scale allows is an error, never a rounding.
+func ParseAmount(s string, scale int) (Amount, error) {
+	if scale < 0 || scale > MAXASSETSCALE {
+		return Amount{}, fmt.Errorf("scale %d is outside 0..%d", scale, MAXASSETSCALE)
+	}
+	str := strings.TrimSpace(s)
+	neg := false
+	if strings.HasPrefix(str, "-") || strings.HasPrefix(str, "+") {
+		neg = str[0] == '-'
+		str = str[1:]
+	}
+	intPart, fracPart := str, ""
+	if i := strings.Index(str, "."); i >= 0 {
+		intPart, fracPart = str[:i], str[i+1:]
+		if fracPart == "" {
+			return Amount{}, fmt.Errorf("amount %q is not a decimal number", s)
+		}
+	}
+	if intPart == "" || !isDigits(intPart) || !isDigits(fracPart) {
+		return Amount{}, fmt.Errorf("amount %q is not a decimal number", s)
+	}
+	// trailing zeros do not count against the scale
+	fracPart = strings.TrimRight(fracPart, "0")
+	if len(fracPart) > scale {
+		return Amount{}, fmt.Errorf("amount %q has more than %d decimal places", s, scale)
+	}
+	fracPart += strings.Repeat("0", scale-len(fracPart))
+	units, ok := new(big.Int).SetString(intPart+fracPart, 10)
+	if !ok {
+		return Amount{}, fmt.Errorf("amount %q is not a decimal number", s)
+	}
+	if neg {
+		units.Neg(units)
+	}
+	return Amount{units, scale}, nil
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// parseAmountArg converts an amount sent by a client, which may arrive as a JSON
+// string or a JSON number, into an Amount at the given scale
+func parseAmountArg(v interface{}, scale int) (Amount, error) {
+	switch a := v.(type) {
+	case string:
+		return ParseAmount(a, scale)
+	case json.Number:
+		return ParseAmount(a.String(), scale)
+	case float64:
+		// shortest representation that round trips, i.e. what the client wrote
+		return ParseAmount(strconv.FormatFloat(a, 'f', -1, 64), scale)
+	case nil:
+		return Amount{}, errors.New("amount is missing")
+	}
+	return Amount{}, fmt.Errorf("amount %v is neither a string nor a number", v)
+}
+
+// holdingAmount reads the amount of a holding from its ledger state. Holdings
+// written before amounts were exact carry a float64 until migrateHoldingAmounts
+// rewrites them, it must be exact at the scale rather than silently rounded.
+func holdingAmount(m ArgsMap, scale int) (Amount, error) {
+	v, found := m[AMOUNT]
+	if !found {
+		return ZeroAmount(scale), nil
+	}
+	if f, isFloat := v.(float64); isFloat {
+		a, err := ParseAmount(strconv.FormatFloat(f, 'f', -1, 64), scale)
+		if err != nil {
+			return Amount{}, fmt.Errorf("legacy balance %v is not exact at scale %d: %s", f, scale, err)
+		}
+		return a, nil
+	}
+	return parseAmountArg(v, scale)
+}
+
+// Scale returns the number of decimal places of the amount
+func (a Amount) Scale() int {
+	return a.scale
+}
+
+func (a Amount) bigInt() *big.Int {
+	if a.units == nil {
+		return new(big.Int)
+	}
+	return a.units
+}
+
+// Sign returns -1, 0 or 1
+func (a Amount) Sign() int {
+	return a.bigInt().Sign()
+}
+
+// Cmp compares two amounts of the same scale
+func (a Amount) Cmp(b Amount) int {
+	return a.bigInt().Cmp(b.bigInt())
+}
+
+// Add returns a + b, both must be of the same scale
+func (a Amount) Add(b Amount) Amount {
+	return Amount{new(big.Int).Add(a.bigInt(), b.bigInt()), a.scale}
+}
+
+// Sub returns a - b, both must be of the same scale
+func (a Amount) Sub(b Amount) Amount {
+	return Amount{new(big.Int).Sub(a.bigInt(), b.bigInt()), a.scale}
+}
+
+// String returns the canonical form with exactly scale decimal places
+func (a Amount) String() string {
+	u := a.bigInt()
+	digits := new(big.Int).Abs(u).String()
+	if a.scale > 0 {
+		if len(digits) <= a.scale {
+			digits = strings.Repeat("0", a.scale-len(digits)+1) + digits
+		}
+		digits = digits[:len(digits)-a.scale] + "." + digits[len(digits)-a.scale:]
+	}
+	if u.Sign() < 0 {
+		return "-" + digits
+	}
+	return digits
+}
+
+// MarshalJSON writes the canonical string so that no client ever sees a float
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return json.Marshal(a.String())
+}
+
+// ************************************
+// setAssetScale
+// ************************************
+
+// AssetScale is the parameter structure for setAssetScale
+type AssetScale struct {
+	AssetID string `json:"assetID"`
+	Scale   int    `json:"scale"`
+}
+
+func (t *SimpleChaincode) setAssetScale(stub shim.ChaincodeStubInterface, args []string) error {
+	var assetScale AssetScale
+	var err error
+	if len(args) != 1 {
+		err = errors.New("setAssetScale expects a single JSON object with assetID and scale")
+		log.Error(err)
+		return err
+	}
+	err = json.Unmarshal([]byte(args[0]), &assetScale)
+	if err != nil {
+		err = fmt.Errorf("setAssetScale failed to unmarshal arg: %s", err)
+		log.Error(err)
+		return err
+	}
+	if assetScale.AssetID == "" {
+		err = errors.New("setAssetScale arg does not include assetID")
+		log.Error(err)
+		return err
+	}
+	if assetScale.Scale < 0 || assetScale.Scale > MAXASSETSCALE {
+		err = fmt.Errorf("setAssetScale scale %d is outside 0..%d", assetScale.Scale, MAXASSETSCALE)
+		log.Error(err)
+		return err
+	}
+	_, defined, err := GETAssetDefinitionFromLedger(stub, assetScale.AssetID)
+	if err != nil {
+		err = fmt.Errorf("setAssetScale %s", err)
+		log.Error(err)
+		return err
+	}
+	if defined {
+		err = fmt.Errorf("setAssetScale asset %s is defined, its scale is fixed by its decimals", assetScale.AssetID)
+		log.Error(err)
+		return err
+	}
+	held, err := assetHasHoldings(stub, assetScale.AssetID)
+	if err != nil {
+		err = fmt.Errorf("setAssetScale failed to read holdings: %s", err)
+		log.Error(err)
+		return err
+	}
+	if held {
+		err = fmt.Errorf("setAssetScale asset %s already has holdings, its scale cannot change", assetScale.AssetID)
+		log.Error(err)
+		return err
+	}
+	err = PUTassetScale(stub, assetScale)
+	if err != nil {
+		err = fmt.Errorf("setAssetScale failed to PUT setting: %s", err)
+		log.Error(err)
+		return err
+	}
+	return nil
+}
+
+// PUTassetScale marshals the scale of an asset and writes it to the ledger
+func PUTassetScale(stub shim.ChaincodeStubInterface, assetScale AssetScale) error {
+	assetScaleBytes, err := json.Marshal(assetScale)
+	if err != nil {
+		err = errors.New("PUTassetScale failed to marshal")
+		log.Error(err)
+		return err
+	}
+	err = stub.PutState(ASSETSCALEKEYPREFIX+assetScale.AssetID, assetScaleBytes)
+	if err != nil {
+		err = fmt.Errorf("PUTSTATE assetScale failed: %s", err)
+		log.Error(err)
+		return err
+	}
+	return nil
+}
+
+// getAssetScale returns the number of decimal places allowed for an assetID
+func getAssetScale(stub shim.ChaincodeStubInterface, assetID string) (int, error) {
+	var assetScale AssetScale
+	def, found, err := GETAssetDefinitionFromLedger(stub, assetID)
+	if err != nil {
+		return 0, err
+	}
+	if found {
+		return def.Decimals, nil
+	}
+	assetScaleBytes, err := stub.GetState(ASSETSCALEKEYPREFIX + assetID)
+	if err != nil {
+		return 0, fmt.Errorf("GETSTATE for asset %s scale failed: %s", assetID, err)
+	}
+	if len(assetScaleBytes) == 0 {
+		return DEFAULTASSETSCALE, nil
+	}
+	err = json.Unmarshal(assetScaleBytes, &assetScale)
+	if err != nil {
+		return 0, fmt.Errorf("asset %s scale failed to unmarshal: %s", assetID, err)
+	}
+	return assetScale.Scale, nil
+}
+
+// assetHasHoldings returns true when any account holds the assetID
+func assetHasHoldings(stub shim.ChaincodeStubInterface, assetID string) (bool, error) {
+	keys, err := holderKeys(stub, assetID)
+	if err != nil {
+		return false, err
+	}
+	return len(keys) > 0, nil
+}
+
+// roundFloatAmount converts a legacy float64 balance to the nearest amount at
+// the scale, a half unit goes to the even unit so that rounding has no bias
+func roundFloatAmount(f float64, scale int) (Amount, error) {
+	r, ok := new(big.Rat).SetString(strconv.FormatFloat(f, 'f', -1, 64))
+	if !ok {
+		return Amount{}, fmt.Errorf("legacy balance %v is not a number", f)
+	}
+	r.Mul(r, new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(scale)), nil)))
+	units, rem := new(big.Int).QuoRem(r.Num(), r.Denom(), new(big.Int))
+	twiceRem := new(big.Int).Lsh(new(big.Int).Abs(rem), 1)
+	if c := twiceRem.Cmp(r.Denom()); c > 0 || (c == 0 && units.Bit(0) == 1) {
+		units.Add(units, big.NewInt(int64(r.Sign())))
+	}
+	return Amount{units, scale}, nil
+}
+
+func init() {
+	registerMigration(Migration{Name: "holdingAmounts", From: "1.0", To: "1.1", After: "contractStateIndexes",
+		run: migrateHoldingAmounts})
+}
+
+// migrateHoldingAmounts rewrites every holding whose amount is still a float64
+// as an exact amount at its asset's scale. A balance that is not exact at the
+// scale, such as 0.30000000000000004 at scale 2, is rounded half to even and
+// listed in the record of the migration.
+func migrateHoldingAmounts(stub shim.ChaincodeStubInterface, state *ContractState, record *MigrationRecord) error {
+	aa, err := getissueActiveAccounts(stub)
+	if err != nil {
+		return err
+	}
+	for _, sAccountKey := range aa {
+		holding, _, err := getHoldingFromLedger(stub, sAccountKey)
+		if err != nil {
+			return err
+		}
+		f, isFloat := holding[AMOUNT].(float64)
+		if !isFloat {
+			continue
+		}
+		assetID, _ := holding[ASSETID].(string)
+		scale, err := getAssetScale(stub, assetID)
+		if err != nil {
+			return err
+		}
+		amount, err := holdingAmount(holding, scale)
+		if err != nil {
+			amount, err = roundFloatAmount(f, scale)
+			if err != nil {
+				return fmt.Errorf("holding %s: %s", sAccountKey, err)
+			}
+			before := strconv.FormatFloat(f, 'f', -1, 64)
+			log.Warningf("migrateHoldingAmounts holding %s balance %s is rounded to %s", sAccountKey, before, amount)
+			record.Adjustments = append(record.Adjustments, MigrationAdjustment{sAccountKey, before, amount.String()})
+		}
+		holding[AMOUNT] = amount.String()
+		holdingJSON, err := json.Marshal(holding)
+		if err != nil {
+			return fmt.Errorf("holding %s marshal failed: %s", sAccountKey, err)
+		}
+		err = stub.PutState(sAccountKey, holdingJSON)
+		if err != nil {
+			return fmt.Errorf("holding %s PUTSTATE failed: %s", sAccountKey, err)
+		}
+	}
+	return nil
+}
+
+// percentOf returns a as a percentage of total, to four decimal places
+func (a Amount) percentOf(total Amount) string {
+	if total.Sign() == 0 {
+		return "0.0000"
+	}
+	pct := new(big.Rat).SetFrac(new(big.Int).Mul(a.bigInt(), big.NewInt(100)), total.bigInt())
+	return pct.FloatString(4)
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseAmount(t *testing.T) {
+	tests := []struct {
+		in    string
+		scale int
+		want  string
+		fails bool
+	}{
+		{"12.5", 2, "12.50", false},
+		{"12.50", 2, "12.50", false},
+		{"12.500000", 2, "12.50", false},
+		{"-3", 2, "-3.00", false},
+		{"+3", 0, "3", false},
+		{"-0", 2, "0.00", false},
+		{" 7 ", 1, "7.0", false},
+		{"0.000000000000000001", MAXASSETSCALE, "0.000000000000000001", false},
+		{"123456789012345678901234567890", 0, "123456789012345678901234567890", false},
+		{"12.345", 2, "", true},
+		{"0.001", 0, "", true},
+		{"", 2, "", true},
+		{"-", 2, "", true},
+		{".5", 2, "", true},
+		{"5.", 2, "", true},
+		{"1e5", 2, "", true},
+		{"1,000", 2, "", true},
+		{"0x10", 2, "", true},
+		{"--1", 2, "", true},
+		{"1", -1, "", true},
+		{"1", MAXASSETSCALE + 1, "", true},
+	}
+	for _, tt := range tests {
+		a, err := ParseAmount(tt.in, tt.scale)
+		if tt.fails {
+			if err == nil {
+				t.Errorf("ParseAmount(%q, %d) = %s, want an error", tt.in, tt.scale, a)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseAmount(%q, %d) failed: %s", tt.in, tt.scale, err)
+			continue
+		}
+		if a.String() != tt.want {
+			t.Errorf("ParseAmount(%q, %d) = %s, want %s", tt.in, tt.scale, a, tt.want)
+		}
+	}
+}
+
+func TestAmountArithmetic(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		scale    int
+		sum, dif string
+		cmp      int
+	}{
+		{"0.1", "0.2", 2, "0.30", "-0.10", -1},
+		{"1.00", "1", 2, "2.00", "0.00", 0},
+		{"-5", "2.5", 1, "-2.5", "-7.5", -1},
+		{"0.000000000000000001", "0.000000000000000002", MAXASSETSCALE, "0.000000000000000003", "-0.000000000000000001", -1},
+		// past what an int64 or a float64 holds exactly
+		{"92233720368547758070.01", "0.99", 2, "92233720368547758071.00", "92233720368547758069.02", 1},
+		{"9007199254740993", "1", 0, "9007199254740994", "9007199254740992", 1},
+	}
+	for _, tt := range tests {
+		a, err := ParseAmount(tt.a, tt.scale)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ParseAmount(tt.b, tt.scale)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := a.Add(b).String(); got != tt.sum {
+			t.Errorf("%s + %s = %s, want %s", tt.a, tt.b, got, tt.sum)
+		}
+		if got := a.Sub(b).String(); got != tt.dif {
+			t.Errorf("%s - %s = %s, want %s", tt.a, tt.b, got, tt.dif)
+		}
+		if got := a.Cmp(b); got != tt.cmp {
+			t.Errorf("%s cmp %s = %d, want %d", tt.a, tt.b, got, tt.cmp)
+		}
+	}
+}
+
+func TestAmountZeroValue(t *testing.T) {
+	var a Amount
+	if a.Sign() != 0 || a.String() != "0" {
+		t.Errorf("zero Amount is %s with sign %d", a, a.Sign())
+	}
+	if got := ZeroAmount(3).String(); got != "0.000" {
+		t.Errorf("ZeroAmount(3) = %s", got)
+	}
+	b, err := json.Marshal(ZeroAmount(2))
+	if err != nil || string(b) != `"0.00"` {
+		t.Errorf("ZeroAmount(2) marshals to %s, %v", b, err)
+	}
+}
+
+// tenth and fifth are variables so that their sum is float64 arithmetic, not an
+// exact constant
+var tenth, fifth = 0.1, 0.2
+
+func TestParseAmountArg(t *testing.T) {
+	tests := []struct {
+		in    interface{}
+		scale int
+		want  string
+		fails bool
+	}{
+		{"1.25", 2, "1.25", false},
+		{json.Number("1.25"), 2, "1.25", false},
+		// the shortest form of the float, never its binary expansion
+		{0.1, 2, "0.10", false},
+		{1e21, 0, "1000000000000000000000", false},
+		{tenth + fifth, 2, "", true},
+		{1.005, 2, "", true},
+		{nil, 2, "", true},
+		{true, 2, "", true},
+	}
+	for _, tt := range tests {
+		a, err := parseAmountArg(tt.in, tt.scale)
+		if tt.fails {
+			if err == nil {
+				t.Errorf("parseAmountArg(%v, %d) = %s, want an error", tt.in, tt.scale, a)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseAmountArg(%v, %d) failed: %s", tt.in, tt.scale, err)
+			continue
+		}
+		if a.String() != tt.want {
+			t.Errorf("parseAmountArg(%v, %d) = %s, want %s", tt.in, tt.scale, a, tt.want)
+		}
+	}
+}
+
+func TestHoldingAmount(t *testing.T) {
+	tests := []struct {
+		holding ArgsMap
+		scale   int
+		want    string
+		fails   bool
+	}{
+		{ArgsMap{AMOUNT: "10.50"}, 2, "10.50", false},
+		{ArgsMap{}, 2, "0.00", false},
+		// legacy float balances must be exact at the scale
+		{ArgsMap{AMOUNT: 10.5}, 2, "10.50", false},
+		{ArgsMap{AMOUNT: 10.0}, 0, "10", false},
+		{ArgsMap{AMOUNT: 10.125}, 2, "", true},
+		{ArgsMap{AMOUNT: tenth + fifth}, 2, "", true},
+		{ArgsMap{AMOUNT: tenth + fifth}, MAXASSETSCALE, "0.300000000000000040", false},
+		{ArgsMap{AMOUNT: "10.125"}, 2, "", true},
+	}
+	for _, tt := range tests {
+		a, err := holdingAmount(tt.holding, tt.scale)
+		if tt.fails {
+			if err == nil {
+				t.Errorf("holdingAmount(%v, %d) = %s, want an error", tt.holding, tt.scale, a)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("holdingAmount(%v, %d) failed: %s", tt.holding, tt.scale, err)
+			continue
+		}
+		if a.String() != tt.want {
+			t.Errorf("holdingAmount(%v, %d) = %s, want %s", tt.holding, tt.scale, a, tt.want)
+		}
+	}
+}
+
+func TestPercentOf(t *testing.T) {
+	tests := []struct {
+		a, total string
+		want     string
+	}{
+		{"1", "3", "33.3333"},
+		{"2", "3", "66.6667"},
+		{"5", "5", "100.0000"},
+		{"5", "0", "0.0000"},
+	}
+	for _, tt := range tests {
+		a, _ := ParseAmount(tt.a, 2)
+		total, _ := ParseAmount(tt.total, 2)
+		if got := a.percentOf(total); got != tt.want {
+			t.Errorf("%s percentOf %s = %s, want %s", tt.a, tt.total, got, tt.want)
+		}
+	}
+}
+
+// TestRoundFloatAmount rounds what the float prints as, so 1.005 is a half
+// even though its binary value is a little under
+func TestRoundFloatAmount(t *testing.T) {
+	tests := []struct {
+		f     float64
+		scale int
+		want  string
+	}{
+		{tenth + fifth, 2, "0.30"},
+		{12.5, 2, "12.50"},
+		{0.126, 2, "0.13"},
+		{0.124, 2, "0.12"},
+		// halves go to the even unit
+		{0.125, 2, "0.12"},
+		{0.135, 2, "0.14"},
+		{1.005, 2, "1.00"},
+		{2.5, 0, "2"},
+		{3.5, 0, "4"},
+		{-0.125, 2, "-0.12"},
+		{-0.135, 2, "-0.14"},
+		{-0.126, 2, "-0.13"},
+		{0.004, 2, "0.00"},
+		{1e-20, MAXASSETSCALE, "0.000000000000000000"},
+	}
+	for _, tt := range tests {
+		a, err := roundFloatAmount(tt.f, tt.scale)
+		if err != nil {
+			t.Errorf("roundFloatAmount(%v, %d) failed: %s", tt.f, tt.scale, err)
+			continue
+		}
+		if a.String() != tt.want {
+			t.Errorf("roundFloatAmount(%v, %d) = %s, want %s", tt.f, tt.scale, a, tt.want)
+		}
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//***************************************************
+//* ASSET DEFINITIONS
+//***************************************************
+
+// ASSETDEFINITIONKEYPREFIX prefixes the ledger key of each asset definition
+const ASSETDEFINITIONKEYPREFIX string = "AssetDefinition_"
+
+// AssetDefinition registers a class of asset that can be issued into holdings.
+// Supplies are canonical amounts at the asset's decimals, an empty MaxSupply
+// means that the supply is not capped.
+type AssetDefinition struct {
+	AssetID           string `json:"assetID"`
+	Symbol            string `json:"symbol"`
+	Decimals          int    `json:"decimals"`
+	Issuer            string `json:"issuer"`
+	MaxSupply         string `json:"maxSupply,omitempty"`
+	CirculatingSupply string `json:"circulatingSupply"`
+}
+
+// assetDefinitionArg is the shape of the defineAsset argument
+type assetDefinitionArg struct {
+	AssetID   string      `json:"assetID"`
+	Symbol    string      `json:"symbol"`
+	Decimals  *int        `json:"decimals"`
+	Issuer    string      `json:"issuer"`
+	MaxSupply json.Number `json:"maxSupply"`
+}
+
+func (d *AssetDefinition) circulatingSupply() (Amount, error) {
+	if d.CirculatingSupply == "" {
+		return ZeroAmount(d.Decimals), nil
+	}
+	supply, err := ParseAmount(d.CirculatingSupply, d.Decimals)
+	if err != nil {
+		return Amount{}, fmt.Errorf("asset %s circulating supply: %s", d.AssetID, err)
+	}
+	return supply, nil
+}
+
+// maxSupply returns the cap and true, or false when the asset is uncapped
+func (d *AssetDefinition) maxSupply() (Amount, bool, error) {
+	if d.MaxSupply == "" {
+		return Amount{}, false, nil
+	}
+	maxSupply, err := ParseAmount(d.MaxSupply, d.Decimals)
+	if err != nil {
+		return Amount{}, false, fmt.Errorf("asset %s max supply: %s", d.AssetID, err)
+	}
+	return maxSupply, true, nil
+}
+
+// ************************************
+// defineAsset
+// ************************************
+func (t *SimpleChaincode) defineAsset(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var arg assetDefinitionArg
+	var argsMap ArgsMap
+	var err error
+
+	log.Info("Entering defineAsset")
+
+	if len(args) != 1 {
+		err = errors.New("defineAsset expects one JSON object with assetID, symbol, decimals, issuer and optional maxSupply")
+		log.Error(err)
+		return nil, err
+	}
+	log.Debugf("defineAsset arg: %s", args[0])
+
+	decoder := json.NewDecoder(bytes.NewReader([]byte(args[0])))
+	decoder.UseNumber()
+	err = decoder.Decode(&argsMap)
+	if err == nil {
+		err = json.Unmarshal([]byte(args[0]), &arg)
+	}
+	if err != nil {
+		err = fmt.Errorf("defineAsset failed to unmarshal arg: %s", err)
+		log.Error(err)
+		return nil, err
+	}
+	if arg.AssetID == "" || arg.Symbol == "" || arg.Issuer == "" {
+		err = errors.New("defineAsset arg must include assetID, symbol and issuer")
+		log.Error(err)
+		return nil, err
+	}
+
+	err = checkID(ASSETID, arg.AssetID)
+	if err != nil {
+		err = fmt.Errorf("defineAsset %s", err)
+		log.Error(err)
+		return nil, err
+	}
+
+	caller, err := getCaller(stub, argsMap)
+	if err != nil {
+		err = fmt.Errorf("defineAsset %s", err)
+		log.Error(err)
+		return nil, err
+	}
+	if caller != arg.Issuer {
+		err = fmt.Errorf("defineAsset caller %s cannot define an asset for issuer %s", caller, arg.Issuer)
+		log.Error(err)
+		return nil, err
+	}
+	// the issuer answers for the asset, so it must be an account in good standing
+	err = checkParty(stub, "issuer", arg.Issuer)
+	if err != nil {
+		log.Errorf("defineAsset %s", err)
+		return nil, err
+	}
+
+	_, found, err := GETAssetDefinitionFromLedger(stub, arg.AssetID)
+	if err != nil {
+		err = fmt.Errorf("defineAsset %s", err)
+		log.Error(err)
+		return nil, err
+	}
+	if found {
+		err = fmt.Errorf("defineAsset asset %s is already defined", arg.AssetID)
+		log.Error(err)
+		return nil, err
+	}
+	// see checkAssetTypeName
+	types, err := GETAssetTypesFromLedger(stub)
+	if err != nil {
+		err = fmt.Errorf("defineAsset %s", err)
+		log.Error(err)
+		return nil, err
+	}
+	if _, found := types.find(arg.AssetID); found {
+		err = fmt.Errorf("defineAsset asset %s cannot be named after an asset type", arg.AssetID)
+		log.Error(err)
+		return nil, err
+	}
+
+	def := AssetDefinition{
+		AssetID: arg.AssetID,
+		Symbol:  arg.Symbol,
+		Issuer:  arg.Issuer,
+	}
+	if arg.Decimals != nil {
+		def.Decimals = *arg.Decimals
+	} else {
+		// an asset that was given a scale before it was defined keeps it
+		def.Decimals, err = getAssetScale(stub, arg.AssetID)
+		if err != nil {
+			err = fmt.Errorf("defineAsset %s", err)
+			log.Error(err)
+			return nil, err
+		}
+	}
+	if def.Decimals < 0 || def.Decimals > MAXASSETSCALE {
+		err = fmt.Errorf("defineAsset decimals %d is outside 0..%d", def.Decimals, MAXASSETSCALE)
+		log.Error(err)
+		return nil, err
+	}
+
+	// holdings issued before the asset was defined count towards its supply
+	supply, err := sumHoldings(stub, arg.AssetID, def.Decimals)
+	if err != nil {
+		err = fmt.Errorf("defineAsset %s", err)
+		log.Error(err)
+		return nil, err
+	}
+	def.CirculatingSupply = supply.String()
+
+	if arg.MaxSupply != "" {
+		maxSupply, err := parseAmountArg(arg.MaxSupply, def.Decimals)
+		if err != nil {
+			err = fmt.Errorf("defineAsset maxSupply: %s", err)
+			log.Error(err)
+			return nil, err
+		}
+		if maxSupply.Sign() <= 0 {
+			err = fmt.Errorf("defineAsset maxSupply must be positive, got %s", maxSupply)
+			log.Error(err)
+			return nil, err
+		}
+		if supply.Cmp(maxSupply) > 0 {
+			err = fmt.Errorf("defineAsset asset %s already has a supply of %s, over the cap of %s", arg.AssetID, supply, maxSupply)
+			log.Error(err)
+			return nil, err
+		}
+		def.MaxSupply = maxSupply.String()
+	}
+
+	err = PUTAssetDefinitionToLedger(stub, def)
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("defineAsset asset %s (%s) defined for issuer %s", def.AssetID, def.Symbol, def.Issuer)
+	return nil, nil
+}
+
+// ************************************
+// readAssetDefinition
+// ************************************
+func (t *SimpleChaincode) readAssetDefinition(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var arg AssetIDT
+	var err error
+
+	if len(args) != 1 {
+		err = errors.New("readAssetDefinition expects one JSON object with an assetID")
+		log.Error(err)
+		return nil, err
+	}
+	err = json.Unmarshal([]byte(args[0]), &arg)
+	if err != nil || arg.ID == "" {
+		err = fmt.Errorf("readAssetDefinition arg must be a JSON object with an assetID: %s", args[0])
+		log.Error(err)
+		return nil, err
+	}
+	def, found, err := GETAssetDefinitionFromLedger(stub, arg.ID)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	if !found {
+		err = fmt.Errorf("readAssetDefinition asset %s is not defined", arg.ID)
+		log.Error(err)
+		return nil, err
+	}
+	return json.Marshal(def)
+}
+
+// GETAssetDefinitionFromLedger returns the definition of an asset and whether it exists
+func GETAssetDefinitionFromLedger(stub shim.ChaincodeStubInterface, assetID string) (AssetDefinition, bool, error) {
+	var def AssetDefinition
+	defBytes, err := stub.GetState(ASSETDEFINITIONKEYPREFIX + assetID)
+	if err != nil {
+		return def, false, fmt.Errorf("GETSTATE for asset definition %s failed: %s", assetID, err)
+	}
+	if len(defBytes) == 0 {
+		return def, false, nil
+	}
+	err = json.Unmarshal(defBytes, &def)
+	if err != nil {
+		return def, false, fmt.Errorf("asset definition %s failed to unmarshal: %s", assetID, err)
+	}
+	return def, true, nil
+}
+
+// PUTAssetDefinitionToLedger marshals an asset definition and writes it to the ledger
+func PUTAssetDefinitionToLedger(stub shim.ChaincodeStubInterface, def AssetDefinition) error {
+	defBytes, err := json.Marshal(def)
+	if err != nil {
+		err = fmt.Errorf("Failed to marshal asset definition %s: %s", def.AssetID, err)
+		log.Critical(err)
+		return err
+	}
+	err = stub.PutState(ASSETDEFINITIONKEYPREFIX+def.AssetID, defBytes)
+	if err != nil {
+		err = fmt.Errorf("Failed to PUTSTATE asset definition %s: %s", def.AssetID, err)
+		log.Critical(err)
+		return err
+	}
+	return nil
+}
+
+// sumHoldings adds up the balances of every holding of an assetID
+func sumHoldings(stub shim.ChaincodeStubInterface, assetID string, scale int) (Amount, error) {
+	total := ZeroAmount(scale)
+	keys, err := holderKeys(stub, assetID)
+	if err != nil {
+		return total, err
+	}
+	for _, sAccountKey := range keys {
+		holding, _, err := getHoldingFromLedger(stub, sAccountKey)
+		if err != nil {
+			return total, err
+		}
+		if holding[ASSETID] != assetID {
+			continue
+		}
+		balance, err := holdingAmount(holding, scale)
+		if err != nil {
+			return total, fmt.Errorf("holding %s: %s", sAccountKey, err)
+		}
+		total = total.Add(balance)
+	}
+	return total, nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestDefineAssetIssuer(t *testing.T) {
+	s := withHoldings(t)
+	s.as("dave").mustInvoke("createAccount", `{"accountID":"dave","acname":"Dave"}`)
+	s.asAdmin().mustInvoke("freezeAccount", `{"accountID":"dave","reason":"audit"}`)
+	before := s.snapshot()
+	// only for yourself, and only with a registered account in good standing
+	s.as("alice").mustFailInvoke("defineAsset", `{"assetID":"EUR","symbol":"E","issuer":"bank"}`)
+	s.as("carol").mustFailInvoke("defineAsset", `{"assetID":"EUR","symbol":"E","issuer":"carol"}`)
+	err := s.as("dave").mustFailInvoke("defineAsset", `{"assetID":"EUR","symbol":"E","issuer":"dave"}`)
+	if _, isStanding := err.(*AccountStandingError); !isStanding {
+		t.Errorf("a frozen issuer got %v, want an AccountStandingError", err)
+	}
+	s.as("bank").mustFailInvoke("defineAsset", `{"assetID":"USD","symbol":"$","issuer":"bank"}`)
+	s.assertUnchanged(before, "a refused definition")
+}
+
+// TestSupplyCap issues USD, capped at 1000.00, up to and over its cap
+func TestSupplyCap(t *testing.T) {
+	s := withHoldings(t)
+	s.as("bank")
+	s.mustInvoke("issueAsset", `{"accountID":"bob","assetID":"USD","amount":"899.99"}`)
+	before := s.snapshot()
+	s.mustFailInvoke("issueAsset", `{"accountID":"bob","assetID":"USD","amount":"0.02"}`)
+	s.assertUnchanged(before, "an issue over the cap")
+	s.mustInvoke("issueAsset", `{"accountID":"alice","assetID":"USD","amount":"0.01"}`)
+	def := s.stateMap(ASSETDEFINITIONKEYPREFIX + "USD")
+	if def["circulatingSupply"] != "1000.00" || def["maxSupply"] != "1000.00" {
+		t.Errorf("USD supply is %v of %v, want 1000.00 of 1000.00", def["circulatingSupply"], def["maxSupply"])
+	}
+	s.mustFailInvoke("issueAsset", `{"accountID":"alice","assetID":"USD","amount":"0.01"}`)
+
+	// redeeming makes room under the cap again
+	s.as("alice").mustInvoke("redeemAsset", `{"accountID":"alice","assetID":"USD","amount":"50"}`)
+	s.as("bank").mustInvoke("issueAsset", `{"accountID":"bob","assetID":"USD","amount":"50"}`)
+	if got := s.holding("bob", "USD"); got != "949.99" {
+		t.Errorf("bob holds %s, want 949.99", got)
+	}
+	// only the issuer mints
+	s.as("alice").mustFailInvoke("issueAsset", `{"accountID":"alice","assetID":"EUR","amount":"1"}`)
+	s.asAdmin().mustFailInvoke("issueAsset", `{"accountID":"alice","assetID":"USD","amount":"1"}`)
+}
+
+// TestDefineAssetCountsHoldings defines an asset that was issued before
+// definitions existed, its holdings count towards the cap
+func TestDefineAssetCountsHoldings(t *testing.T) {
+	s := newMemStub(t)
+	for _, id := range []string{"bank", "alice"} {
+		s.as(id).mustInvoke("createAccount", `{"accountID":"`+id+`","acname":"`+id+`"}`)
+	}
+	s.state["alice_GBP"] = []byte(`{"accountID":"alice","assetID":"GBP","amount":"70"}`)
+	s.state[indexKey(HOLDINGINDEX, "alice_GBP")] = indexMember
+	s.state[indexKey(HOLDERINDEX, holderKey("GBP", "alice"))] = indexMember
+	s.as("bank").mustFailInvoke("defineAsset", `{"assetID":"GBP","symbol":"L","decimals":0,"issuer":"bank","maxSupply":"69"}`)
+	s.mustInvoke("defineAsset", `{"assetID":"GBP","symbol":"L","decimals":0,"issuer":"bank","maxSupply":"100"}`)
+	if supply := s.stateMap(ASSETDEFINITIONKEYPREFIX + "GBP")["circulatingSupply"]; supply != "70" {
+		t.Errorf("GBP supply is %v, want the 70 alice holds", supply)
+	}
+	s.mustFailInvoke("issueAsset", `{"accountID":"alice","assetID":"GBP","amount":"31"}`)
+	s.mustInvoke("issueAsset", `{"accountID":"alice","assetID":"GBP","amount":"30"}`)
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//***************************************************
+//* ASSET TYPES
+//***************************************************
+
+// ASSETTYPESKEY is used to store the asset type registry
+const ASSETTYPESKEY string = "AssetTypesKey"
+
+// AssetType classifies device assets. An asset is of this type when its
+// assettype names it, or else when its name contains one of NameContains.
+// Assets that match no type are of the default type. Rules lists the rules
+// run against assets of this type, and writes of a state that violates Schema
+// are rejected.
+type AssetType struct {
+	Name         string   `json:"name"`
+	NameContains []string `json:"nameContains,omitempty"`
+	Rules        []string `json:"rules"`
+	Default      bool     `json:"default,omitempty"`
+	Schema       *Schema  `json:"schema,omitempty"`
+}
+
+// AssetTypes is the ordered registry of asset types, the first match wins
+type AssetTypes struct {
+	Types []AssetType `json:"assetTypes"`
+}
+
+// defaultAssetTypes is the registry of a contract that never defined a type,
+// it classifies assets exactly as the contract always has
+func defaultAssetTypes() AssetTypes {
+	return AssetTypes{[]AssetType{
+		{Name: "smartplug", NameContains: []string{"Plug"}, Rules: deviceRules(), Schema: deviceSchema()},
+		{Name: "motor", Rules: deviceRules(), Default: true, Schema: deviceSchema()},
+	}}
+}
+
+// deviceRules are the built in rules, see rules.go
+func deviceRules() []string {
+	return []string{"timeCheck", "rpmCheck", "hvacOverheatCheck", "hvacOvercoolCheck"}
+}
+
+// deviceSchema describes the properties that the built in rules read, so that
+// a device cannot send a state that the rules cannot evaluate
+func deviceSchema() *Schema {
+	zero := 0.0
+	return &Schema{
+		Type:     "object",
+		Required: []string{ASSETID},
+		Properties: map[string]*Schema{
+			ASSETID:                 {Type: "string"},
+			ASSETTYPE:               {Type: "string"},
+			ASSETNAME:               {Type: "string"},
+			"rpm":                   {Type: "number", Minimum: &zero},
+			"max_rpm":               {Type: "number", ExclusiveMinimum: &zero},
+			"create_date":           {Type: "number"},
+			"last_mod_date":         {Type: "number"},
+			"hvac_mode":             {Type: "string"},
+			"target_temperature_c":  {Type: "number"},
+			"ambient_temperature_c": {Type: "number"},
+		},
+	}
+}
+
+// find returns the registered type of the given name
+func (types *AssetTypes) find(name string) (AssetType, bool) {
+	for _, at := range types.Types {
+		if at.Name == name {
+			return at, true
+		}
+	}
+	return AssetType{}, false
+}
+
+// match returns the first type whose name rules match an asset name
+func (types *AssetTypes) match(assetName string) (AssetType, bool) {
+	if assetName == "" {
+		return AssetType{}, false
+	}
+	for _, at := range types.Types {
+		for _, s := range at.NameContains {
+			if s != "" && strings.Contains(assetName, s) {
+				return at, true
+			}
+		}
+	}
+	return AssetType{}, false
+}
+
+// defaultType returns the type of assets that match no other type
+func (types *AssetTypes) defaultType() (AssetType, bool) {
+	for _, at := range types.Types {
+		if at.Default {
+			return at, true
+		}
+	}
+	return AssetType{}, false
+}
+
+// resolveAssetType returns the type of the asset described by an asset
+// function's argument. An explicit assettype wins. Without one, an asset that
+// already exists, or existed, under one type keeps it whatever its name, a new
+// asset is typed by the name rules, and anything else is of the default type.
+func resolveAssetType(stub shim.ChaincodeStubInterface, argsMap ArgsMap, assetID string) (AssetType, error) {
+	types, err := GETAssetTypesFromLedger(stub)
+	if err != nil {
+		return AssetType{}, err
+	}
+	if typeBytes, found := getObject(argsMap, ASSETTYPE); found {
+		typeName, isString := typeBytes.(string)
+		if !isString || typeName == "" {
+			return AssetType{}, errors.New("assettype must be a non empty string")
+		}
+		at, found := types.find(typeName)
+		if !found {
+			return AssetType{}, fmt.Errorf("asset type %s is not registered", typeName)
+		}
+		return at, nil
+	}
+	for _, at := range types.Types {
+		if assetIsActive(stub, assetID+"_"+at.Name) {
+			return at, nil
+		}
+	}
+	// a deleted asset keeps its type for its history and for a create
+	for _, at := range types.Types {
+		if assetIsDeleted(stub, assetID+"_"+at.Name) {
+			return at, nil
+		}
+	}
+	if nameBytes, found := getObject(argsMap, ASSETNAME); found {
+		assetName, _ := nameBytes.(string)
+		if at, found := types.match(assetName); found {
+			return at, nil
+		}
+	}
+	at, found := types.defaultType()
+	if !found {
+		return AssetType{}, errors.New("no asset type matches and there is no default asset type")
+	}
+	return at, nil
+}
+
+// checkAssetTypeName refuses the name of an asset that is defined or held. The
+// assetID_type key of a device of that type would be the accountID_assetID key
+// of the holding of an account named like the device.
+func checkAssetTypeName(stub shim.ChaincodeStubInterface, name string) error {
+	_, defined, err := GETAssetDefinitionFromLedger(stub, name)
+	if err != nil {
+		return err
+	}
+	holders, err := indexMembersWithPrefix(stub, HOLDERINDEX, holderKey(name, ""))
+	if err != nil {
+		return err
+	}
+	if defined || len(holders) > 0 {
+		return fmt.Errorf("asset type %s cannot be named after an asset that can be held", name)
+	}
+	return nil
+}
+
+// ************************************
+// defineAssetType
+// ************************************
+func (t *SimpleChaincode) defineAssetType(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var at AssetType
+	var err error
+
+	log.Info("Entering defineAssetType")
+
+	if len(args) != 1 {
+		err = errors.New("defineAssetType expects one JSON object with name, nameContains, rules, default and schema")
+		log.Error(err)
+		return nil, err
+	}
+	err = json.Unmarshal([]byte(args[0]), &at)
+	if err != nil {
+		err = fmt.Errorf("defineAssetType failed to unmarshal arg: %s", err)
+		log.Error(err)
+		return nil, err
+	}
+	// the type is the suffix of the assetID_type key
+	if at.Name == "" || strings.Contains(at.Name, "_") {
+		err = fmt.Errorf("defineAssetType name %q must be non empty and cannot contain _", at.Name)
+		log.Error(err)
+		return nil, err
+	}
+	err = checkID("asset type", at.Name)
+	if err == nil {
+		err = checkAssetTypeName(stub, at.Name)
+	}
+	if err != nil {
+		err = fmt.Errorf("defineAssetType %s", err)
+		log.Error(err)
+		return nil, err
+	}
+	if at.Rules == nil {
+		at.Rules = []string{}
+	}
+	rules, err := GETRulesFromLedger(stub)
+	if err != nil {
+		err = fmt.Errorf("defineAssetType %s", err)
+		log.Error(err)
+		return nil, err
+	}
+	for _, rule := range at.Rules {
+		if _, found := rules.find(rule); !found {
+			err = fmt.Errorf("defineAssetType asset type %s names unknown rule %s", at.Name, rule)
+			log.Error(err)
+			return nil, err
+		}
+	}
+
+	if at.Schema != nil {
+		err = at.Schema.check(at.Name)
+		if err != nil {
+			err = fmt.Errorf("defineAssetType %s", err)
+			log.Error(err)
+			return nil, err
+		}
+	}
+
+	types, err := GETAssetTypesFromLedger(stub)
+	if err != nil {
+		err = fmt.Errorf("defineAssetType %s", err)
+		log.Error(err)
+		return nil, err
+	}
+	current, _ := types.defaultType()
+	if current.Name == at.Name && !at.Default {
+		err = fmt.Errorf("defineAssetType asset type %s is the default, make another type the default first", at.Name)
+		log.Error(err)
+		return nil, err
+	}
+
+	replaced := false
+	for i := range types.Types {
+		if at.Default {
+			types.Types[i].Default = false
+		}
+		if types.Types[i].Name == at.Name {
+			types.Types[i] = at
+			replaced = true
+		}
+	}
+	if !replaced {
+		types.Types = append(types.Types, at)
+	}
+
+	err = PUTAssetTypesToLedger(stub, types)
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("defineAssetType asset type %s defined", at.Name)
+	return nil, nil
+}
+
+// ************************************
+// readAssetTypes
+// ************************************
+func (t *SimpleChaincode) readAssetTypes(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	types, err := GETAssetTypesFromLedger(stub)
+	if err != nil {
+		err = fmt.Errorf("readAssetTypes %s", err)
+		log.Error(err)
+		return nil, err
+	}
+	return json.Marshal(types)
+}
+
+// GETAssetTypesFromLedger returns the asset type registry, or the default
+// registry when no type was ever defined
+func GETAssetTypesFromLedger(stub shim.ChaincodeStubInterface) (AssetTypes, error) {
+	var types AssetTypes
+	typesBytes, err := stub.GetState(ASSETTYPESKEY)
+	if err != nil {
+		return types, fmt.Errorf("GETSTATE for asset types failed: %s", err)
+	}
+	if len(typesBytes) == 0 {
+		return defaultAssetTypes(), nil
+	}
+	err = json.Unmarshal(typesBytes, &types)
+	if err != nil {
+		return types, fmt.Errorf("asset types failed to unmarshal: %s", err)
+	}
+	return types, nil
+}
+
+// PUTAssetTypesToLedger marshals the asset type registry and writes it to the ledger
+func PUTAssetTypesToLedger(stub shim.ChaincodeStubInterface, types AssetTypes) error {
+	typesBytes, err := json.Marshal(types)
+	if err != nil {
+		err = fmt.Errorf("Failed to marshal asset types: %s", err)
+		log.Critical(err)
+		return err
+	}
+	err = stub.PutState(ASSETTYPESKEY, typesBytes)
+	if err != nil {
+		err = fmt.Errorf("Failed to PUTSTATE asset types: %s", err)
+		log.Critical(err)
+		return err
+	}
+	return nil
+}
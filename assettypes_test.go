@@ -0,0 +1,70 @@
+package main
+
+import (
+	"testing"
+)
+
+// TestAssetTypeKeyCollisions tries the ways a device key and a holding key
+// could come out the same, a device assetID_type against a holding
+// accountID_assetID
+func TestAssetTypeKeyCollisions(t *testing.T) {
+	s := withHoldings(t)
+	s.as("bank").mustInvoke("issueAsset", `{"accountID":"bob","assetID":"USD","amount":"5"}`)
+	before := s.snapshot()
+
+	// bob's device bob of type USD would be his USD holding
+	s.as("bob").mustFailInvoke("defineAssetType", `{"name":"USD"}`)
+	s.asAdmin().mustFailInvoke("defineAssetType", `{"name":"USD"}`)
+	s.as("bob").mustFailInvoke("createAsset", `{"assetID":"bob","assettype":"USD","rpm":0}`)
+	s.as("bank").mustFailInvoke("defineAsset", `{"assetID":"motor","symbol":"M","decimals":0,"issuer":"bank"}`)
+	s.assertUnchanged(before, "a type named after an asset")
+
+	// an underscore in an ID lines the keys up without a shared name
+	s.asAdmin().mustInvoke("defineAssetType", `{"name":"D"}`)
+	s.as("bank").mustInvoke("defineAsset", `{"assetID":"US_D","symbol":"U","decimals":0,"issuer":"bank"}`)
+	s.as("bank").mustInvoke("issueAsset", `{"accountID":"bob","assetID":"US_D","amount":"1"}`)
+	holding := string(s.state["bob_US_D"])
+	s.as("bob").mustFailInvoke("createAsset", `{"assetID":"bob_US","assettype":"D"}`)
+	if string(s.state["bob_US_D"]) != holding {
+		t.Error("createAsset overwrote bob's US_D holding")
+	}
+	s.as("alice").mustInvoke("createAsset", `{"assetID":"alice_US","assettype":"D"}`)
+	device := string(s.state["alice_US_D"])
+	s.as("bank").mustFailInvoke("issueAsset", `{"accountID":"alice","assetID":"US_D","amount":"1"}`)
+	s.mustFailInvoke("transferAsset", `{"accountID":"bob","accountIDTo":"alice","assetID":"US_D","amount":"1"}`)
+	if string(s.state["alice_US_D"]) != device {
+		t.Error("a holding overwrote the device alice_US")
+	}
+}
+
+// TestResolveAssetType checks that an asset keeps its type when its name
+// stops matching the type's name rules
+func TestResolveAssetType(t *testing.T) {
+	s := newMemStub(t)
+	s.asAdmin().mustInvoke("setCreateOnUpdate", `{"createOnUpdate":true}`)
+	s.as("tech")
+	s.mustInvoke("createAsset", `{"assetID":"c1","name":"Plug 1"}`)
+	s.mustInvoke("createAsset", `{"assetID":"m1","name":"Pump"}`)
+	s.mustInvoke("updateAsset", `{"assetID":"c1","name":"Boiler room"}`)
+	s.mustInvoke("updateAsset", `{"assetID":"m1","name":"Plug 2"}`)
+	for key, name := range map[string]string{"c1_smartplug": "Boiler room", "m1_motor": "Plug 2"} {
+		if got := s.stateMap(key)[ASSETNAME]; got != name {
+			t.Errorf("%s is named %v, want %s", key, got, name)
+		}
+	}
+	for _, key := range []string{"c1_motor", "m1_smartplug"} {
+		if s.state[key] != nil || inIndex(s, ASSETINDEX, key) {
+			t.Errorf("updating by name created a second asset %s", key)
+		}
+	}
+	// the same holds for a deleted asset, which is created again under its type
+	s.mustInvoke("deleteAsset", `{"assetID":"c1"}`)
+	s.mustInvoke("createAsset", `{"assetID":"c1","name":"Boiler room"}`)
+	if !inIndex(s, ASSETINDEX, "c1_smartplug") || inIndex(s, ASSETINDEX, "c1_motor") {
+		t.Error("c1 was created again under another type")
+	}
+	s.mustInvoke("createAsset", `{"assetID":"c2","name":"Plug 3"}`)
+	if !inIndex(s, ASSETINDEX, "c2_smartplug") {
+		t.Error("a new asset was not typed by its name")
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//***************************************************
+//* BALANCE QUERIES
+//***************************************************
+
+// Balance is one account's holding of one asset
+type Balance struct {
+	AccountID  string `json:"accountID"`
+	AssetID    string `json:"assetID"`
+	Amount     Amount `json:"amount"`
+	Percentage string `json:"percentage,omitempty"`
+}
+
+// Portfolio is every asset held by one account
+type Portfolio struct {
+	AccountID string    `json:"accountID"`
+	Holdings  []Balance `json:"holdings"`
+}
+
+// Holders is every account holding one asset, with each share of the supply
+type Holders struct {
+	AssetID           string    `json:"assetID"`
+	CirculatingSupply Amount    `json:"circulatingSupply"`
+	Holders           []Balance `json:"holders"`
+}
+
+// balanceQueryArg is the argument shape shared by the balance queries
+type balanceQueryArg struct {
+	AccountID string `json:"accountID"`
+	AssetID   string `json:"assetID"`
+}
+
+func parseBalanceQueryArg(function string, args []string, needAccount bool, needAsset bool) (balanceQueryArg, error) {
+	var arg balanceQueryArg
+	var err error
+	if len(args) != 1 {
+		err = fmt.Errorf("%s expects one JSON object", function)
+		log.Error(err)
+		return arg, err
+	}
+	err = json.Unmarshal([]byte(args[0]), &arg)
+	if err != nil {
+		err = fmt.Errorf("%s failed to unmarshal arg: %s", function, err)
+		log.Error(err)
+		return arg, err
+	}
+	if (needAccount && arg.AccountID == "") || (needAsset && arg.AssetID == "") {
+		err = fmt.Errorf("%s arg is missing accountID or assetID", function)
+		log.Error(err)
+		return arg, err
+	}
+	return arg, nil
+}
+
+// ************************************
+// readBalance
+// ************************************
+func (t *SimpleChaincode) readBalance(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	arg, err := parseBalanceQueryArg("readBalance", args, true, true)
+	if err != nil {
+		return nil, err
+	}
+	scale, err := getAssetScale(stub, arg.AssetID)
+	if err != nil {
+		err = fmt.Errorf("readBalance %s", err)
+		log.Error(err)
+		return nil, err
+	}
+	balance := Balance{arg.AccountID, arg.AssetID, ZeroAmount(scale), ""}
+
+	// an account that never held the asset has a zero balance
+	sAccountKey := arg.AccountID + "_" + arg.AssetID
+	if issueAccountIsActive(stub, sAccountKey) {
+		holding, _, err := getHoldingFromLedger(stub, sAccountKey)
+		if err != nil {
+			err = fmt.Errorf("readBalance %s", err)
+			log.Error(err)
+			return nil, err
+		}
+		balance.Amount, err = holdingAmount(holding, scale)
+		if err != nil {
+			err = fmt.Errorf("readBalance holding %s: %s", sAccountKey, err)
+			log.Error(err)
+			return nil, err
+		}
+	}
+	return json.Marshal(balance)
+}
+
+// ************************************
+// readPortfolio
+// ************************************
+func (t *SimpleChaincode) readPortfolio(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	arg, err := parseBalanceQueryArg("readPortfolio", args, true, false)
+	if err != nil {
+		return nil, err
+	}
+	holdings, err := getPortfolioBalances(stub, arg.AccountID)
+	if err != nil {
+		err = fmt.Errorf("readPortfolio %s", err)
+		log.Error(err)
+		return nil, err
+	}
+	return json.Marshal(Portfolio{arg.AccountID, holdings})
+}
+
+// ************************************
+// readHolders
+// ************************************
+func (t *SimpleChaincode) readHolders(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	arg, err := parseBalanceQueryArg("readHolders", args, false, true)
+	if err != nil {
+		return nil, err
+	}
+	holders, err := getHolderBalances(stub, arg.AssetID)
+	if err != nil {
+		err = fmt.Errorf("readHolders %s", err)
+		log.Error(err)
+		return nil, err
+	}
+	scale, err := getAssetScale(stub, arg.AssetID)
+	if err != nil {
+		err = fmt.Errorf("readHolders %s", err)
+		log.Error(err)
+		return nil, err
+	}
+
+	// the recorded supply of a defined asset, otherwise whatever is held
+	supply := ZeroAmount(scale)
+	def, defined, err := GETAssetDefinitionFromLedger(stub, arg.AssetID)
+	if err == nil && defined {
+		supply, err = def.circulatingSupply()
+	}
+	if err != nil {
+		err = fmt.Errorf("readHolders %s", err)
+		log.Error(err)
+		return nil, err
+	}
+	if !defined {
+		for _, h := range holders {
+			supply = supply.Add(h.Amount)
+		}
+	}
+	for i := range holders {
+		holders[i].Percentage = holders[i].Amount.percentOf(supply)
+	}
+	return json.Marshal(Holders{arg.AssetID, supply, holders})
+}
+
+// portfolioKeys returns the keys of every holding of an account, by a range scan
+// of the holding index. An account whose ID extends this one with _ shares the
+// prefix, its holdings are included and must be filtered out by the caller.
+func portfolioKeys(stub shim.ChaincodeStubInterface, accountID string) ([]string, error) {
+	assetIDs, err := indexMembersWithPrefix(stub, HOLDINGINDEX, accountID+"_")
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, len(assetIDs))
+	for i, assetID := range assetIDs {
+		keys[i] = accountID + "_" + assetID
+	}
+	return keys, nil
+}
+
+// holderKeys returns the keys of every holding of an asset, by a range scan of
+// the holder index. An asset whose ID extends this one with ~ shares the prefix,
+// what it adds is only kept when it names a holding of this asset.
+func holderKeys(stub shim.ChaincodeStubInterface, assetID string) ([]string, error) {
+	accountIDs, err := indexMembersWithPrefix(stub, HOLDERINDEX, assetID+"~")
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(accountIDs))
+	for _, accountID := range accountIDs {
+		if issueAccountIsActive(stub, accountID+"_"+assetID) {
+			keys = append(keys, accountID+"_"+assetID)
+		}
+	}
+	return keys, nil
+}
+
+// getPortfolioBalances returns the balances of every holding of an account
+func getPortfolioBalances(stub shim.ChaincodeStubInterface, accountID string) ([]Balance, error) {
+	keys, err := portfolioKeys(stub, accountID)
+	if err != nil {
+		return nil, err
+	}
+	return getHoldingBalances(stub, keys, func(b Balance) bool { return b.AccountID == accountID })
+}
+
+// getHolderBalances returns the balances of every holding of an asset
+func getHolderBalances(stub shim.ChaincodeStubInterface, assetID string) ([]Balance, error) {
+	keys, err := holderKeys(stub, assetID)
+	if err != nil {
+		return nil, err
+	}
+	return getHoldingBalances(stub, keys, func(b Balance) bool { return b.AssetID == assetID })
+}
+
+// getHoldingBalances reads the holdings and returns the balances of those that
+// the filter accepts, in the order of the keys
+func getHoldingBalances(stub shim.ChaincodeStubInterface, keys []string, filter func(Balance) bool) ([]Balance, error) {
+	balances := make([]Balance, 0)
+	for _, sAccountKey := range keys {
+		holding, _, err := getHoldingFromLedger(stub, sAccountKey)
+		if err != nil {
+			return nil, err
+		}
+		accountID, _ := holding[ACCOUNTID].(string)
+		assetID, _ := holding[ASSETID].(string)
+		if accountID == "" || assetID == "" {
+			return nil, fmt.Errorf("holding %s has no accountID or assetID", sAccountKey)
+		}
+		b := Balance{AccountID: accountID, AssetID: assetID}
+		if !filter(b) {
+			continue
+		}
+		scale, err := getAssetScale(stub, assetID)
+		if err != nil {
+			return nil, err
+		}
+		b.Amount, err = holdingAmount(holding, scale)
+		if err != nil {
+			return nil, fmt.Errorf("holding %s: %s", sAccountKey, err)
+		}
+		balances = append(balances, b)
+	}
+	return balances, nil
+}
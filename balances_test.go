@@ -0,0 +1,78 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// balances returns "accountID assetID amount percentage" for each balance of
+// a portfolio or holders query
+func balances(v interface{}, field string) []string {
+	list, _ := v.(map[string]interface{})[field].([]interface{})
+	got := []string{}
+	for _, b := range list {
+		m := b.(map[string]interface{})
+		s := m[ACCOUNTID].(string) + " " + m[ASSETID].(string) + " " + m[AMOUNT].(string)
+		if p, found := m["percentage"]; found {
+			s += " " + p.(string)
+		}
+		got = append(got, s)
+	}
+	return got
+}
+
+func TestBalanceQueries(t *testing.T) {
+	s := withHoldings(t)
+	s.mustInvoke("transferAsset", `{"accountID":"alice","accountIDTo":"bob","assetID":"USD","amount":"25"}`)
+	s.as("bank").mustInvoke("defineAsset", `{"assetID":"EUR","symbol":"E","decimals":0,"issuer":"bank"}`)
+	s.mustInvoke("issueAsset", `{"accountID":"alice","assetID":"EUR","amount":"7"}`)
+
+	balance := s.mustRead("readBalance", `{"accountID":"alice","assetID":"USD"}`).(map[string]interface{})
+	if balance[AMOUNT] != "75.00" {
+		t.Errorf("alice's USD balance is %v, want 75.00", balance[AMOUNT])
+	}
+	// an account that never held an asset has nothing of it, at its scale
+	balance = s.mustRead("readBalance", `{"accountID":"bob","assetID":"EUR"}`).(map[string]interface{})
+	if balance[AMOUNT] != "0" {
+		t.Errorf("bob's EUR balance is %v, want 0", balance[AMOUNT])
+	}
+
+	got := balances(s.mustRead("readPortfolio", `{"accountID":"alice"}`), "holdings")
+	want := []string{"alice EUR 7", "alice USD 75.00"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("alice's portfolio is %q, want %q", got, want)
+	}
+	holders := s.mustRead("readHolders", `{"assetID":"USD"}`)
+	got = balances(holders, "holders")
+	want = []string{"alice USD 75.00 75.0000", "bob USD 25.00 25.0000"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("USD holders are %q, want %q", got, want)
+	}
+	if supply := holders.(map[string]interface{})["circulatingSupply"]; supply != "100.00" {
+		t.Errorf("USD supply is %v, want 100.00", supply)
+	}
+
+	for _, arg := range []string{`{"accountID":"alice"}`, `{"assetID":"USD"}`, `[]`} {
+		_, err := s.read("readBalance", arg)
+		if err == nil {
+			t.Errorf("readBalance %s succeeded", arg)
+		}
+	}
+}
+
+// TestPortfolioPrefix checks that the holdings of al_x, whose keys start with
+// al_, are not in al's portfolio
+func TestPortfolioPrefix(t *testing.T) {
+	s := withHoldings(t)
+	for _, id := range []string{"al", "al_x"} {
+		s.as(id).mustInvoke("createAccount", `{"accountID":"`+id+`","acname":"`+id+`"}`)
+	}
+	s.as("bank").mustInvoke("issueAsset", `{"accountID":"al_x","assetID":"USD","amount":"10"}`)
+	if got := balances(s.mustRead("readPortfolio", `{"accountID":"al"}`), "holdings"); len(got) != 0 {
+		t.Errorf("al's portfolio is %q, want nothing", got)
+	}
+	got := balances(s.mustRead("readPortfolio", `{"accountID":"al_x"}`), "holdings")
+	if want := []string{"al_x USD 10.00"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("al_x's portfolio is %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//***************************************************
+//* COMPLIANCE
+//***************************************************
+
+// Compliance statuses of an asset
+const (
+	// COMPLIANCECOMPLIANT assets have no active alerts that carry weight
+	COMPLIANCECOMPLIANT string = "compliant"
+	// COMPLIANCEWARNING assets have active alerts that need attention
+	COMPLIANCEWARNING string = "warning"
+	// COMPLIANCENONCOMPLIANT assets have active alerts that add up to NONCOMPLIANTSCORE
+	COMPLIANCENONCOMPLIANT string = "noncompliant"
+)
+
+// NONCOMPLIANTSCORE is the weight of active alerts at which an asset is out of
+// compliance, one critical alert or four warnings
+const NONCOMPLIANTSCORE int = 4
+
+// severityWeights is what an active alert of each severity adds to an asset's
+// compliance score
+var severityWeights = map[string]int{
+	SEVERITYINFO:     0,
+	SEVERITYWARNING:  1,
+	SEVERITYCRITICAL: 4,
+}
+
+// Compliance is the compliance section of an asset state. LastChange is when
+// Status last changed.
+type Compliance struct {
+	Status     string `json:"complianceStatus"`
+	Score      int    `json:"complianceScore"`
+	LastChange string `json:"lastComplianceChange,omitempty"`
+}
+
+// complianceFor weighs the active alerts, severities maps alert name to the
+// severity of the rule that raises it
+func complianceFor(active AlertSet, records AlertRecords, severities map[string]string) Compliance {
+	score := 0
+	for alert, on := range active {
+		if !on {
+			continue
+		}
+		severity, found := severities[alert]
+		if !found {
+			// the rule is gone, its last incident knows how bad it was
+			if record, found := records[alert]; found && record.Severity != "" {
+				severity = record.Severity
+			} else {
+				severity = SEVERITYWARNING
+			}
+		}
+		score += severityWeights[severity]
+	}
+	c := Compliance{Status: COMPLIANCECOMPLIANT, Score: score}
+	if score >= NONCOMPLIANTSCORE {
+		c.Status = COMPLIANCENONCOMPLIANT
+	} else if score > 0 {
+		c.Status = COMPLIANCEWARNING
+	}
+	return c
+}
+
+// complianceFromMap reads the compliance section of a ledger state. A state
+// written before compliance statuses existed is noncompliant when it has an
+// active alert, and when it became so is not known.
+func complianceFromMap(state map[string]interface{}) Compliance {
+	var c Compliance
+	c.Status, _ = state["complianceStatus"].(string)
+	c.LastChange, _ = state["lastComplianceChange"].(string)
+	if score, isNumber := toFloat(state["complianceScore"]); isNumber {
+		c.Score = int(score)
+	}
+	if c.Status == "" {
+		c.Status = COMPLIANCECOMPLIANT
+		if a, found := state["alerts"].(map[string]interface{}); found {
+			alerts := newAlertStatus()
+			alerts.alertStatusFromMap(a)
+			if !alerts.NoAlertsActive() {
+				c.Status = COMPLIANCENONCOMPLIANT
+			}
+		}
+	}
+	return c
+}
+
+// setCompliance writes the alerts and compliance of an asset to its state,
+// prior is the compliance the ledger held before this event
+func setCompliance(stub shim.ChaincodeStubInterface, state map[string]interface{}, prior Compliance, c Compliance, alerts AlertStatus) {
+	if alerts.AllClear() {
+		// all false, no need to appear
+		delete(state, "alerts")
+	} else {
+		state["alerts"] = alerts
+	}
+	c.LastChange = prior.LastChange
+	if c.Status != prior.Status || c.LastChange == "" {
+		c.LastChange = txTimestamp(stub).Format(time.RFC3339Nano)
+	}
+	// incompliance is kept for clients that read it, warnings are advisory
+	state["incompliance"] = c.Status != COMPLIANCENONCOMPLIANT
+	state["complianceStatus"] = c.Status
+	state["complianceScore"] = c.Score
+	state["lastComplianceChange"] = c.LastChange
+}
+
+// NoncompliantAsset is an asset out of compliance, Duration is the seconds
+// since it went out
+type NoncompliantAsset struct {
+	AssetID  string         `json:"assetID"`
+	Score    int            `json:"complianceScore"`
+	Since    string         `json:"since,omitempty"`
+	Duration *float64       `json:"duration,omitempty"`
+	Alerts   AlertNameArray `json:"alerts"`
+}
+
+// TypeCompliance summarises the compliance of the assets of one type
+type TypeCompliance struct {
+	AssetType          string              `json:"assettype"`
+	Assets             int                 `json:"assets"`
+	Compliant          int                 `json:"compliant"`
+	Warning            int                 `json:"warning"`
+	Noncompliant       int                 `json:"noncompliant"`
+	LongestDuration    *float64            `json:"longestDuration,omitempty"`
+	NoncompliantAssets []NoncompliantAsset `json:"noncompliantAssets"`
+}
+
+// ComplianceReport is the readComplianceReport result
+type ComplianceReport struct {
+	GeneratedAt string           `json:"generatedAt"`
+	Types       []TypeCompliance `json:"types"`
+}
+
+// ************************************
+// readComplianceReport
+// ************************************
+func (t *SimpleChaincode) readComplianceReport(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var filter struct {
+		AssetType string `json:"assettype"`
+	}
+	var err error
+
+	if len(args) == 1 {
+		err = json.Unmarshal([]byte(args[0]), &filter)
+		if err != nil {
+			err = fmt.Errorf("readComplianceReport arg must be a JSON object with an optional assettype: %s", err)
+			log.Error(err)
+			return nil, err
+		}
+	}
+	aa, err := getActiveAssets(stub)
+	if err != nil {
+		err = fmt.Errorf("readComplianceReport failed to get the active assets: %s", err)
+		log.Error(err)
+		return nil, err
+	}
+
+	now := txTimestamp(stub)
+	byType := make(map[string]*TypeCompliance)
+	for _, sAssetKey := range aa {
+		var state map[string]interface{}
+		assetBytes, err := stub.GetState(sAssetKey)
+		if err == nil {
+			err = json.Unmarshal(assetBytes, &state)
+		}
+		if err == nil && state == nil {
+			err = errors.New("state is not a map shape")
+		}
+		if err != nil {
+			// best efforts, return what we can
+			log.Errorf("readComplianceReport asset %s could not be read: %s", sAssetKey, err)
+			continue
+		}
+		assetID, _ := state[ASSETID].(string)
+		assetType, _ := state[ASSETTYPE].(string)
+		if filter.AssetType != "" && assetType != filter.AssetType {
+			continue
+		}
+		tc, found := byType[assetType]
+		if !found {
+			tc = &TypeCompliance{AssetType: assetType, NoncompliantAssets: []NoncompliantAsset{}}
+			byType[assetType] = tc
+		}
+		tc.Assets++
+		c := complianceFromMap(state)
+		switch c.Status {
+		case COMPLIANCENONCOMPLIANT:
+			tc.Noncompliant++
+		case COMPLIANCEWARNING:
+			tc.Warning++
+			continue
+		default:
+			tc.Compliant++
+			continue
+		}
+		nc := NoncompliantAsset{AssetID: assetID, Score: c.Score, Since: c.LastChange, Alerts: AlertNameArray{}}
+		if c.LastChange != "" {
+			nc.Duration = secondsSince(c.LastChange, now)
+		}
+		if nc.Duration != nil && (tc.LongestDuration == nil || *nc.Duration > *tc.LongestDuration) {
+			tc.LongestDuration = nc.Duration
+		}
+		if a, found := state["alerts"].(map[string]interface{}); found {
+			alerts := newAlertStatus()
+			alerts.alertStatusFromMap(a)
+			nc.Alerts = alerts.Active
+		}
+		tc.NoncompliantAssets = append(tc.NoncompliantAssets, nc)
+	}
+
+	names := make([]string, 0, len(byType))
+	for name := range byType {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	report := ComplianceReport{GeneratedAt: now.Format(time.RFC3339Nano), Types: make([]TypeCompliance, 0, len(names))}
+	for _, name := range names {
+		report.Types = append(report.Types, *byType[name])
+	}
+	return json.Marshal(report)
+}
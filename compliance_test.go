@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestComplianceFor(t *testing.T) {
+	severities := map[string]string{"crit": SEVERITYCRITICAL, "warn": SEVERITYWARNING, "info": SEVERITYINFO}
+	tests := []struct {
+		active  []string
+		records AlertRecords
+		status  string
+		score   int
+	}{
+		{nil, nil, COMPLIANCECOMPLIANT, 0},
+		{[]string{"info"}, nil, COMPLIANCECOMPLIANT, 0},
+		{[]string{"warn"}, nil, COMPLIANCEWARNING, 1},
+		{[]string{"warn", "info"}, nil, COMPLIANCEWARNING, 1},
+		{[]string{"crit"}, nil, COMPLIANCENONCOMPLIANT, 4},
+		{[]string{"crit", "warn"}, nil, COMPLIANCENONCOMPLIANT, 5},
+		// a rule that is gone weighs as its last incident, or as a warning
+		{[]string{"gone"}, nil, COMPLIANCEWARNING, 1},
+		{[]string{"gone"}, AlertRecords{"gone": &AlertRecord{Severity: SEVERITYCRITICAL}}, COMPLIANCENONCOMPLIANT, 4},
+	}
+	for _, tt := range tests {
+		active := make(AlertSet)
+		for _, alert := range tt.active {
+			active[alert] = true
+		}
+		c := complianceFor(active, tt.records, severities)
+		if c.Status != tt.status || c.Score != tt.score {
+			t.Errorf("complianceFor(%q) = %s %d, want %s %d", tt.active, c.Status, c.Score, tt.status, tt.score)
+		}
+	}
+}
+
+func TestComplianceReport(t *testing.T) {
+	s := newMemStub(t)
+	s.as("tech")
+	s.mustInvoke("createAsset", `{"assetID":"m1","assettype":"motor","rpm":100,"max_rpm":1000}`)
+	since := s.now.Format(time.RFC3339Nano)
+	s.mustInvoke("createAsset", `{"assetID":"m2","assettype":"motor","rpm":900,"max_rpm":1000}`)
+	s.mustInvoke("createAsset", `{"assetID":"p1","assettype":"smartplug","hvac_mode":"heat","ambient_temperature_c":25,"target_temperature_c":20}`)
+	s.mustInvoke("createAsset", `{"assetID":"p2","assettype":"smartplug","hvac_mode":"heat","ambient_temperature_c":25,"target_temperature_c":20,"rpm":100,"max_rpm":1000}`)
+	// still out of compliance, so it has been since its first alert
+	s.mustInvoke("updateAsset", `{"assetID":"m1","rpm":150}`)
+	m1 := s.stateMap("m1_motor")
+	if m1["complianceStatus"] != COMPLIANCENONCOMPLIANT || m1["lastComplianceChange"] != since || m1["incompliance"] != false {
+		t.Errorf("m1 is %v since %v, want %s since %s", m1["complianceStatus"], m1["lastComplianceChange"], COMPLIANCENONCOMPLIANT, since)
+	}
+
+	result, err := s.read("readComplianceReport", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report ComplianceReport
+	err = json.Unmarshal(result, &report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.GeneratedAt != s.now.Format(time.RFC3339Nano) || len(report.Types) != 2 {
+		t.Fatalf("report is %s, want motors and smartplugs at %s", result, s.now)
+	}
+	// four transactions of a minute each since m1 went out
+	duration := 4 * time.Minute.Seconds()
+	motors := report.Types[0]
+	if motors.AssetType != "motor" || motors.Assets != 2 || motors.Compliant != 1 || motors.Noncompliant != 1 ||
+		motors.LongestDuration == nil || *motors.LongestDuration != duration {
+		t.Errorf("motors are %+v, want 1 of 2 out of compliance for %v seconds", motors, duration)
+	}
+	want := []NoncompliantAsset{{"m1", 4, since, &duration, AlertNameArray{"RPM_LESS_THAN_20PERCENT"}}}
+	if !reflect.DeepEqual(motors.NoncompliantAssets, want) {
+		t.Errorf("noncompliant motors are %+v, want %+v", motors.NoncompliantAssets, want)
+	}
+	plugs := report.Types[1]
+	if plugs.AssetType != "smartplug" || plugs.Assets != 2 || plugs.Warning != 1 || plugs.Noncompliant != 1 ||
+		len(plugs.NoncompliantAssets) != 1 || plugs.NoncompliantAssets[0].Score != 5 {
+		t.Errorf("smartplugs are %+v, want p1 with a warning and p2 out of compliance with a score of 5", plugs)
+	}
+
+	result, err = s.read("readComplianceReport", `{"assettype":"smartplug"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report = ComplianceReport{}
+	json.Unmarshal(result, &report)
+	if len(report.Types) != 1 || report.Types[0].AssetType != "smartplug" {
+		t.Errorf("the smartplug report is %s", result)
+	}
+
+	s.mustInvoke("updateAsset", `{"assetID":"m1","rpm":900}`)
+	m1 = s.stateMap("m1_motor")
+	if m1["complianceStatus"] != COMPLIANCECOMPLIANT || m1["lastComplianceChange"] != s.now.Format(time.RFC3339Nano) {
+		t.Errorf("m1 is %v since %v after it cleared, want %s now", m1["complianceStatus"], m1["lastComplianceChange"], COMPLIANCECOMPLIANT)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//***************************************************
+//* CHAINCODE EVENTS
+//***************************************************
+
+// Event names, subscribers register for these with the chaincode ID. They are
+// listed by precedence, a transaction that changes several kinds of things is
+// sent under the first name it carries, with every event in its payload.
+const (
+	EVENTASSETTRANSFERRED string = "ASSET_TRANSFERRED"
+	EVENTASSETISSUED      string = "ASSET_ISSUED"
+	EVENTACCOUNTCREATED   string = "ACCOUNT_CREATED"
+	EVENTASSETDELETED     string = "ASSET_DELETED"
+	EVENTALERTRAISED      string = "ALERT_RAISED"
+	EVENTALERTCLEARED     string = "ALERT_CLEARED"
+)
+
+var eventPrecedence = []string{
+	EVENTASSETTRANSFERRED,
+	EVENTASSETISSUED,
+	EVENTACCOUNTCREATED,
+	EVENTASSETDELETED,
+	EVENTALERTRAISED,
+	EVENTALERTCLEARED,
+}
+
+// ContractEvent is one change made by a transaction. Key is the ledger key that
+// changed, Before and After its values on either side of the change.
+type ContractEvent struct {
+	Name      string      `json:"event"`
+	Function  string      `json:"function"`
+	Key       string      `json:"key"`
+	Before    interface{} `json:"before"`
+	After     interface{} `json:"after"`
+	TxID      string      `json:"txID"`
+	Timestamp string      `json:"timestamp"`
+}
+
+// ContractEvents is the payload of the single event a transaction may set. It is
+// named by eventName, the Name of each event says what kind it is.
+type ContractEvents struct {
+	Events []ContractEvent `json:"events"`
+}
+
+// txEvents collects the events of an invoke until it succeeds
+type txEvents struct {
+	function string
+	events   []ContractEvent
+}
+
+var eventBuffers = make(map[string]*txEvents)
+var eventBuffersLock sync.Mutex
+
+// beginEvents starts collecting the events of an invoke
+func beginEvents(stub shim.ChaincodeStubInterface, function string) {
+	eventBuffersLock.Lock()
+	defer eventBuffersLock.Unlock()
+	eventBuffers[stub.GetTxID()] = &txEvents{function: function}
+}
+
+// discardEvents drops the events of an invoke that failed, or that panicked, it
+// does nothing once the events were flushed
+func discardEvents(stub shim.ChaincodeStubInterface) {
+	eventBuffersLock.Lock()
+	defer eventBuffersLock.Unlock()
+	delete(eventBuffers, stub.GetTxID())
+}
+
+// emitEvent adds an event to the transaction's payload, it is sent when the
+// invoke succeeds
+func emitEvent(stub shim.ChaincodeStubInterface, name string, key string, before interface{}, after interface{}) {
+	eventBuffersLock.Lock()
+	defer eventBuffersLock.Unlock()
+	buffer, found := eventBuffers[stub.GetTxID()]
+	if !found {
+		// a query, or a function called from outside of dispatch
+		log.Debugf("emitEvent %s for %s dropped outside of an invoke", name, key)
+		return
+	}
+	buffer.events = append(buffer.events, ContractEvent{
+		Name:      name,
+		Function:  buffer.function,
+		Key:       key,
+		Before:    before,
+		After:     after,
+		TxID:      stub.GetTxID(),
+		Timestamp: txTimestamp(stub).Format(time.RFC3339Nano),
+	})
+}
+
+// flushEvents sets the transaction's event, Fabric keeps one per transaction
+// so every event goes in one payload
+func flushEvents(stub shim.ChaincodeStubInterface) error {
+	eventBuffersLock.Lock()
+	buffer, found := eventBuffers[stub.GetTxID()]
+	delete(eventBuffers, stub.GetTxID())
+	eventBuffersLock.Unlock()
+	if !found || len(buffer.events) == 0 {
+		return nil
+	}
+
+	name := eventName(buffer.events)
+	payload, err := json.Marshal(ContractEvents{buffer.events})
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s event: %s", name, err)
+	}
+	err = stub.SetEvent(name, payload)
+	if err != nil {
+		return fmt.Errorf("failed to set %s event: %s", name, err)
+	}
+	log.Debugf("flushEvents %s event set with %d events", name, len(buffer.events))
+	return nil
+}
+
+// eventName is the name of the kind of event with the highest precedence among
+// the events, so the name never depends on the order they were emitted in
+func eventName(events []ContractEvent) string {
+	for _, name := range eventPrecedence {
+		for _, e := range events {
+			if e.Name == name {
+				return name
+			}
+		}
+	}
+	return events[0].Name
+}
+
+// ledgerValue reads a state for an event's before or after value, nil when it
+// cannot be read
+func ledgerValue(stub shim.ChaincodeStubInterface, key string) interface{} {
+	var value interface{}
+	stateBytes, err := stub.GetState(key)
+	if err != nil || len(stateBytes) == 0 {
+		return nil
+	}
+	err = json.Unmarshal(stateBytes, &value)
+	if err != nil {
+		log.Warningf("ledgerValue %s failed to unmarshal: %s", key, err)
+		return nil
+	}
+	return value
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// lastEvent returns the event of the last transaction, and the names and keys
+// of the events in its payload, sorted
+func (s *memStub) lastEvent() (string, []string) {
+	s.t.Helper()
+	if len(s.events) == 0 {
+		s.t.Fatal("no event was set")
+	}
+	payload := s.payloads[len(s.payloads)-1]
+	got := []string{}
+	for _, e := range payload.Events {
+		got = append(got, e.Name+" "+e.Key)
+		if e.TxID != s.GetTxID() || e.Timestamp != s.now.Format(time.RFC3339Nano) {
+			s.t.Errorf("event %s %s is from %s at %s, want %s at %s", e.Name, e.Key, e.TxID, e.Timestamp, s.GetTxID(), s.now)
+		}
+	}
+	sort.Strings(got)
+	return s.events[len(s.events)-1], got
+}
+
+func TestEvents(t *testing.T) {
+	s := withHoldings(t)
+	want := []string{EVENTACCOUNTCREATED, EVENTACCOUNTCREATED, EVENTACCOUNTCREATED, EVENTASSETISSUED}
+	if !reflect.DeepEqual(s.events, want) {
+		t.Errorf("setting up holdings set events %q, want %q", s.events, want)
+	}
+	issued := s.payloads[3].Events[0]
+	after, _ := issued.After.(map[string]interface{})
+	if issued.Function != "issueAsset" || issued.Before != nil || after[AMOUNT] != "100.00" {
+		t.Errorf("issue event is %+v, want a new holding of 100.00 from issueAsset", issued)
+	}
+
+	tests := []struct {
+		function string
+		arg      string
+		name     string
+		events   []string
+	}{
+		{"transferAsset", `{"accountID":"alice","accountIDTo":"bob","assetID":"USD","amount":"1"}`,
+			EVENTASSETTRANSFERRED, []string{EVENTASSETTRANSFERRED + " alice_USD", EVENTASSETTRANSFERRED + " bob_USD"}},
+		{"createAsset", `{"assetID":"m1","rpm":100,"max_rpm":1000}`,
+			EVENTALERTRAISED, []string{EVENTALERTRAISED + " m1_motor"}},
+		{"updateAsset", `{"assetID":"m1","rpm":900}`,
+			EVENTALERTCLEARED, []string{EVENTALERTCLEARED + " m1_motor"}},
+		{"deleteAsset", `{"assetID":"m1","reason":"scrapped"}`,
+			EVENTASSETDELETED, []string{EVENTASSETDELETED + " m1_motor"}},
+	}
+	for _, tt := range tests {
+		s.mustInvoke(tt.function, tt.arg)
+		name, events := s.lastEvent()
+		if name != tt.name || !reflect.DeepEqual(events, tt.events) {
+			t.Errorf("%s set %s with %q, want %s with %q", tt.function, name, events, tt.name, tt.events)
+		}
+	}
+
+	// nothing is sent for a failed invoke, a query or an invoke that changed
+	// nothing anyone subscribes to
+	n := len(s.events)
+	s.failPut = "bob_USD"
+	s.mustFailInvoke("transferAsset", `{"accountID":"alice","accountIDTo":"bob","assetID":"USD","amount":"1"}`)
+	s.failPut = ""
+	s.mustRead("readBalance", `{"accountID":"alice","assetID":"USD"}`)
+	s.mustInvoke("createAsset", `{"assetID":"m2","rpm":900,"max_rpm":1000}`)
+	if len(s.events) != n {
+		t.Errorf("events %q were set, want none", s.events[n:])
+	}
+	if len(eventBuffers) != 0 {
+		t.Errorf("%d event buffers are left over", len(eventBuffers))
+	}
+}
+
+func TestEventName(t *testing.T) {
+	tests := []struct {
+		events []string
+		want   string
+	}{
+		{[]string{EVENTALERTRAISED}, EVENTALERTRAISED},
+		{[]string{EVENTALERTRAISED, EVENTASSETISSUED}, EVENTASSETISSUED},
+		{[]string{EVENTASSETISSUED, EVENTALERTRAISED}, EVENTASSETISSUED},
+		{[]string{EVENTALERTCLEARED, EVENTALERTRAISED, EVENTASSETTRANSFERRED}, EVENTASSETTRANSFERRED},
+		{[]string{"CUSTOM"}, "CUSTOM"},
+	}
+	for _, tt := range tests {
+		events := make([]ContractEvent, len(tt.events))
+		for i, name := range tt.events {
+			events[i].Name = name
+		}
+		if got := eventName(events); got != tt.want {
+			t.Errorf("eventName(%q) = %s, want %s", tt.events, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//***************************************************
+//* STATE HISTORY
+//***************************************************
+
+// STATEHISTORYKEY suffixes the key of a state's history head, which holds the
+// latest sequence number. Each version is a ledger entry of its own under the
+// head key, ~ and the zero padded sequence number.
+const STATEHISTORYKEY string = ".StateHistory"
+
+// History page sizes
+const (
+	// HISTORYPAGESIZE is the page size when the caller asks for none
+	HISTORYPAGESIZE int = 100
+	// MAXHISTORYPAGESIZE caps what one query can read
+	MAXHISTORYPAGESIZE int = 1000
+)
+
+// stateHistoryHead is stored at <key>.StateHistory. AssetHistory is the whole
+// history, most recent first, as it was stored before each version had an
+// entry of its own.
+type stateHistoryHead struct {
+	Latest       int      `json:"latest"`
+	AssetHistory []string `json:"assetHistory,omitempty"`
+}
+
+// HistoryEntry is one version of a state. Versions migrated from the single
+// array history have no txID or timestamp.
+type HistoryEntry struct {
+	Sequence  int             `json:"sequence"`
+	TxID      string          `json:"txID,omitempty"`
+	Timestamp string          `json:"timestamp,omitempty"`
+	State     json.RawMessage `json:"state"`
+}
+
+// HistoryPage is a page of readAssetHistory, NextCursor continues it
+type HistoryPage struct {
+	Entries    []HistoryEntry `json:"entries"`
+	NextCursor string         `json:"nextCursor,omitempty"`
+}
+
+func historyEntryKey(key string, sequence int) string {
+	return fmt.Sprintf("%s%s~%010d", key, STATEHISTORYKEY, sequence)
+}
+
+// stateHistory reads the versions of one state, a history still in the
+// single array form is read from the array
+type stateHistory struct {
+	stub   shim.ChaincodeStubInterface
+	key    string
+	latest int
+	legacy []HistoryEntry
+}
+
+func getStateHistory(stub shim.ChaincodeStubInterface, key string) (*stateHistory, error) {
+	var head stateHistoryHead
+	h := &stateHistory{stub: stub, key: key}
+	headBytes, err := stub.GetState(key + STATEHISTORYKEY)
+	if err != nil {
+		return nil, fmt.Errorf("history %s GETSTATE failed: %s", key, err)
+	}
+	if len(headBytes) == 0 {
+		return h, nil
+	}
+	err = json.Unmarshal(headBytes, &head)
+	if err != nil {
+		return nil, fmt.Errorf("history %s unmarshal failed: %s", key, err)
+	}
+	h.latest = head.Latest
+	if head.AssetHistory != nil {
+		// oldest first so that sequence n is at n-1
+		n := len(head.AssetHistory)
+		h.legacy = make([]HistoryEntry, n)
+		for i, stateJSON := range head.AssetHistory {
+			h.legacy[n-1-i] = HistoryEntry{Sequence: n - i, State: json.RawMessage(stateJSON)}
+		}
+		h.latest = n
+	}
+	return h, nil
+}
+
+// entry returns version sequence, which must be between 1 and latest
+func (h *stateHistory) entry(sequence int) (HistoryEntry, error) {
+	var e HistoryEntry
+	if h.legacy != nil {
+		return h.legacy[sequence-1], nil
+	}
+	key := historyEntryKey(h.key, sequence)
+	entryBytes, err := h.stub.GetState(key)
+	if err != nil {
+		return e, fmt.Errorf("%s GETSTATE failed: %s", key, err)
+	}
+	if len(entryBytes) == 0 {
+		return e, fmt.Errorf("%s not found in ledger", key)
+	}
+	err = json.Unmarshal(entryBytes, &e)
+	if err != nil {
+		return e, fmt.Errorf("%s unmarshal failed: %s", key, err)
+	}
+	return e, nil
+}
+
+// firstAfter returns the first version whose timestamp is after t, or at t
+// when inclusive, or latest+1 when there is none. Versions are in time order
+// and those without a timestamp are older than any.
+func (h *stateHistory) firstAfter(t time.Time, inclusive bool) (int, error) {
+	lo, hi := 1, h.latest+1
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		e, err := h.entry(mid)
+		if err != nil {
+			return 0, err
+		}
+		after := false
+		if e.Timestamp != "" {
+			ts, err := time.Parse(time.RFC3339Nano, e.Timestamp)
+			if err != nil {
+				return 0, fmt.Errorf("history %s version %d has a bad timestamp: %s", h.key, mid, err)
+			}
+			after = ts.After(t) || (inclusive && ts.Equal(t))
+		}
+		if after {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+	return lo, nil
+}
+
+// putEntry writes one version and moves the head to it
+func (h *stateHistory) putEntry(stub shim.ChaincodeStubInterface, e HistoryEntry) error {
+	entryBytes, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	err = stub.PutState(historyEntryKey(h.key, e.Sequence), entryBytes)
+	if err != nil {
+		return err
+	}
+	h.latest = e.Sequence
+	headBytes, err := json.Marshal(stateHistoryHead{Latest: h.latest})
+	if err != nil {
+		return err
+	}
+	return stub.PutState(h.key+STATEHISTORYKEY, headBytes)
+}
+
+// migrate writes a single array history as one entry per version, and drops
+// the array from the head
+func (h *stateHistory) migrate() error {
+	if h.legacy == nil {
+		return nil
+	}
+	log.Noticef("migrating history %s of %d versions to one entry per version", h.key, len(h.legacy))
+	for _, e := range h.legacy {
+		entryBytes, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		err = h.stub.PutState(historyEntryKey(h.key, e.Sequence), entryBytes)
+		if err != nil {
+			return err
+		}
+	}
+	h.legacy = nil
+	headBytes, err := json.Marshal(stateHistoryHead{Latest: h.latest})
+	if err != nil {
+		return err
+	}
+	return h.stub.PutState(h.key+STATEHISTORYKEY, headBytes)
+}
+
+func init() {
+	registerMigration(Migration{Name: "stateHistories", From: "1.0", To: "1.1", After: "contractStateIndexes",
+		run: migrateStateHistories})
+}
+
+// migrateStateHistories splits every history still kept as a single array,
+// which getStateHistory otherwise reads and updateStateHistory splits on write
+func migrateStateHistories(stub shim.ChaincodeStubInterface, state *ContractState, record *MigrationRecord) error {
+	for _, index := range []string{ASSETINDEX, DELETEDASSETINDEX, ACCOUNTINDEX, HOLDINGINDEX} {
+		keys, err := indexMembers(stub, index)
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			h, err := getStateHistory(stub, key)
+			if err != nil {
+				return err
+			}
+			err = h.migrate()
+			if err != nil {
+				return fmt.Errorf("history %s migration failed: %s", key, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Create a new history entry in the ledger for an asset. An asset created
+// again after it was deleted continues the history it had.
+func createStateHistory(stub shim.ChaincodeStubInterface, assetID string, stateJSON string) error {
+	return updateStateHistory(stub, assetID, stateJSON)
+}
+
+// Update the ledger with new state history for an asset, as the next version.
+func updateStateHistory(stub shim.ChaincodeStubInterface, assetID string, stateJSON string) error {
+	h, err := getStateHistory(stub, assetID)
+	if err != nil {
+		return err
+	}
+	err = h.migrate()
+	if err != nil {
+		return err
+	}
+	return h.putEntry(stub, HistoryEntry{
+		Sequence:  h.latest + 1,
+		TxID:      stub.GetTxID(),
+		Timestamp: txTimestamp(stub).Format(time.RFC3339Nano),
+		State:     json.RawMessage(stateJSON),
+	})
+}
+
+// Delete an state history from the ledger.
+func deleteStateHistory(stub shim.ChaincodeStubInterface, assetID string) error {
+	h, err := getStateHistory(stub, assetID)
+	if err != nil {
+		return err
+	}
+	if h.legacy == nil {
+		for sequence := 1; sequence <= h.latest; sequence++ {
+			err = stub.DelState(historyEntryKey(assetID, sequence))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return stub.DelState(assetID + STATEHISTORYKEY)
+}
+
+// moveStateHistory gives the history of one key to another
+func moveStateHistory(stub shim.ChaincodeStubInterface, fromKey string, toKey string) error {
+	from, err := getStateHistory(stub, fromKey)
+	if err != nil {
+		return err
+	}
+	if from.latest == 0 {
+		return nil
+	}
+	to := &stateHistory{stub: stub, key: toKey}
+	for sequence := 1; sequence <= from.latest; sequence++ {
+		e, err := from.entry(sequence)
+		if err != nil {
+			return err
+		}
+		err = to.putEntry(stub, e)
+		if err != nil {
+			return err
+		}
+	}
+	return deleteStateHistory(stub, fromKey)
+}
+
+// historyQuery is the paging part of the readAssetHistory argument. From and
+// To are RFC3339 times and are inclusive, Cursor is the NextCursor of the
+// previous page. Count is a number as it always was, a fraction is truncated.
+type historyQuery struct {
+	Count    float64 `json:"count"`
+	From     string  `json:"from"`
+	To       string  `json:"to"`
+	Cursor   string  `json:"cursor"`
+	Order    string  `json:"order"`
+	PageSize int     `json:"pageSize"`
+}
+
+// paged is true when the caller asked for more than the most recent count
+// versions, which were always returned as a plain array of states
+func (q *historyQuery) paged() bool {
+	return q.From != "" || q.To != "" || q.Cursor != "" || q.Order != "" || q.PageSize != 0
+}
+
+// readPage reads the versions of a history that a query selects
+func (h *stateHistory) readPage(q historyQuery) (HistoryPage, error) {
+	page := HistoryPage{Entries: []HistoryEntry{}}
+	ascending := false
+	switch q.Order {
+	case "", "desc":
+	case "asc":
+		ascending = true
+	default:
+		return page, fmt.Errorf("order must be asc or desc, got %s", q.Order)
+	}
+	size := q.PageSize
+	if !q.paged() {
+		size = int(q.Count)
+		if size <= 0 {
+			size = h.latest
+		}
+	} else if size <= 0 {
+		size = HISTORYPAGESIZE
+	} else if size > MAXHISTORYPAGESIZE {
+		return page, fmt.Errorf("pageSize cannot be over %d", MAXHISTORYPAGESIZE)
+	}
+
+	lo, hi := 1, h.latest
+	if q.From != "" {
+		from, err := time.Parse(time.RFC3339Nano, q.From)
+		if err != nil {
+			return page, fmt.Errorf("from is not an RFC3339 time: %s", err)
+		}
+		lo, err = h.firstAfter(from, true)
+		if err != nil {
+			return page, err
+		}
+	}
+	if q.To != "" {
+		to, err := time.Parse(time.RFC3339Nano, q.To)
+		if err != nil {
+			return page, fmt.Errorf("to is not an RFC3339 time: %s", err)
+		}
+		after, err := h.firstAfter(to, false)
+		if err != nil {
+			return page, err
+		}
+		hi = after - 1
+	}
+	if q.Cursor != "" {
+		cursor, err := strconv.Atoi(q.Cursor)
+		if err != nil || cursor < 1 {
+			return page, errors.New("cursor is not one returned by readAssetHistory")
+		}
+		if ascending && cursor > lo {
+			lo = cursor
+		} else if !ascending && cursor < hi {
+			hi = cursor
+		}
+	}
+
+	step, sequence := -1, hi
+	if ascending {
+		step, sequence = 1, lo
+	}
+	for ; sequence >= lo && sequence <= hi && len(page.Entries) < size; sequence += step {
+		e, err := h.entry(sequence)
+		if err != nil {
+			return page, err
+		}
+		page.Entries = append(page.Entries, e)
+	}
+	if sequence >= lo && sequence <= hi {
+		page.NextCursor = strconv.Itoa(sequence)
+	}
+	return page, nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// historyRPMs reads a history query and returns the rpm of each version, and
+// the cursor of the next page
+func (s *memStub) historyRPMs(arg string) ([]float64, string) {
+	s.t.Helper()
+	result, err := s.read("readAssetHistory", arg)
+	if err != nil {
+		s.t.Fatalf("readAssetHistory %s failed: %s", arg, err)
+	}
+	var page HistoryPage
+	var states []json.RawMessage
+	if json.Unmarshal(result, &states) != nil {
+		err = json.Unmarshal(result, &page)
+		if err != nil {
+			s.t.Fatalf("readAssetHistory %s returned %s", arg, result)
+		}
+		for _, e := range page.Entries {
+			states = append(states, e.State)
+		}
+	}
+	rpms := []float64{}
+	for _, state := range states {
+		var m map[string]interface{}
+		json.Unmarshal(state, &m)
+		rpm, _ := m["rpm"].(float64)
+		rpms = append(rpms, rpm)
+	}
+	return rpms, page.NextCursor
+}
+
+// TestAssetHistory creates m1 at rpm 901 and updates it to 902 through 905,
+// a minute apart
+func TestAssetHistory(t *testing.T) {
+	s := newMemStub(t)
+	s.as("tech").mustInvoke("createAsset", `{"assetID":"m1","rpm":901,"max_rpm":1000}`)
+	txID, at := s.GetTxID(), []string{s.now.Format(time.RFC3339)}
+	for _, rpm := range []string{"902", "903", "904", "905"} {
+		s.mustInvoke("updateAsset", `{"assetID":"m1","rpm":`+rpm+`}`)
+		at = append(at, s.now.Format(time.RFC3339))
+	}
+	h, err := getStateHistory(s, "m1_motor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := h.entry(1)
+	if err != nil || first.TxID != txID || first.Timestamp != at[0] {
+		t.Errorf("version 1 is %+v (%v), want %s at %s", first, err, txID, at[0])
+	}
+
+	tests := []struct {
+		arg    string
+		rpms   []float64
+		cursor string
+	}{
+		// count alone returns the states, most recent first, as it always did
+		{`{"assetID":"m1"}`, []float64{905, 904, 903, 902, 901}, ""},
+		{`{"assetID":"m1","count":2}`, []float64{905, 904}, ""},
+		{`{"assetID":"m1","count":2.7}`, []float64{905, 904}, ""},
+		{`{"assetID":"m1","pageSize":2}`, []float64{905, 904}, "3"},
+		{`{"assetID":"m1","pageSize":2,"cursor":"3"}`, []float64{903, 902}, "1"},
+		{`{"assetID":"m1","pageSize":2,"cursor":"1"}`, []float64{901}, ""},
+		{`{"assetID":"m1","pageSize":2,"order":"asc"}`, []float64{901, 902}, "3"},
+		{`{"assetID":"m1","pageSize":2,"order":"asc","cursor":"5"}`, []float64{905}, ""},
+		// from and to are inclusive
+		{`{"assetID":"m1","from":"` + at[1] + `","to":"` + at[3] + `"}`, []float64{904, 903, 902}, ""},
+		{`{"assetID":"m1","from":"` + at[1] + `","to":"` + at[3] + `","order":"asc","pageSize":2}`, []float64{902, 903}, "4"},
+		{`{"assetID":"m1","from":"` + at[1] + `","to":"` + at[3] + `","order":"asc","cursor":"4"}`, []float64{904}, ""},
+		{`{"assetID":"m1","to":"2016-01-01T00:00:00Z"}`, []float64{}, ""},
+	}
+	for _, tt := range tests {
+		rpms, cursor := s.historyRPMs(tt.arg)
+		if !reflect.DeepEqual(rpms, tt.rpms) || cursor != tt.cursor {
+			t.Errorf("readAssetHistory %s = %v cursor %q, want %v cursor %q", tt.arg, rpms, cursor, tt.rpms, tt.cursor)
+		}
+	}
+
+	for _, arg := range []string{
+		`{"assetID":"m1","from":"yesterday"}`,
+		`{"assetID":"m1","cursor":"x"}`,
+		`{"assetID":"m1","order":"up"}`,
+		`{"assetID":"m1","pageSize":1001}`,
+		`{"assetID":"m9"}`,
+	} {
+		_, err := s.read("readAssetHistory", arg)
+		if err == nil {
+			t.Errorf("readAssetHistory %s succeeded", arg)
+		}
+	}
+}
+
+// TestLegacyHistory reads a history still kept as one array, most recent
+// first, and checks that the next write splits it
+func TestLegacyHistory(t *testing.T) {
+	s := newMemStub(t)
+	s.as("tech").mustInvoke("createAsset", `{"assetID":"m1","rpm":903,"max_rpm":1000}`)
+	for key := range s.state {
+		if strings.HasPrefix(key, "m1_motor"+STATEHISTORYKEY) {
+			delete(s.state, key)
+		}
+	}
+	s.state["m1_motor"+STATEHISTORYKEY] = []byte(`{"assetHistory":[
+		"{\"assetID\":\"m1\",\"rpm\":903}","{\"assetID\":\"m1\",\"rpm\":902}","{\"assetID\":\"m1\",\"rpm\":901}"]}`)
+
+	before := s.snapshot()
+	if rpms, _ := s.historyRPMs(`{"assetID":"m1"}`); !reflect.DeepEqual(rpms, []float64{903, 902, 901}) {
+		t.Errorf("legacy history reads %v, want 903 902 901", rpms)
+	}
+	if rpms, cursor := s.historyRPMs(`{"assetID":"m1","order":"asc","pageSize":2}`); !reflect.DeepEqual(rpms, []float64{901, 902}) || cursor != "3" {
+		t.Errorf("legacy history pages as %v cursor %q, want 901 902 cursor 3", rpms, cursor)
+	}
+	s.assertUnchanged(before, "reading a legacy history")
+
+	s.mustInvoke("updateAsset", `{"assetID":"m1","rpm":904}`)
+	head := s.stateMap("m1_motor" + STATEHISTORYKEY)
+	if head["latest"] != 4.0 || head["assetHistory"] != nil {
+		t.Errorf("history head is %v after a write, want version 4 without the array", head)
+	}
+	if s.stateMap(historyEntryKey("m1_motor", 1)) == nil {
+		t.Error("version 1 was not written as an entry of its own")
+	}
+	if rpms, _ := s.historyRPMs(`{"assetID":"m1"}`); !reflect.DeepEqual(rpms, []float64{904, 903, 902, 901}) {
+		t.Errorf("split history reads %v, want 904 903 902 901", rpms)
+	}
+}
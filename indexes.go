@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//***************************************************
+//* INDEXES
+//***************************************************
+
+// INDEXPREFIX starts the key of every index entry. An entry is a key of its
+// own, idx~<index>~<key>, so that adding a member never rewrites the others.
+const INDEXPREFIX string = "idx~"
+
+// Indexes of the contract
+const (
+	// ASSETINDEX holds the active assets
+	ASSETINDEX string = "asset"
+	// DELETEDASSETINDEX holds the assets kept as tombstones
+	DELETEDASSETINDEX string = "deletedasset"
+	// ACCOUNTINDEX holds the active accounts
+	ACCOUNTINDEX string = "account"
+	// HOLDINGINDEX holds the accountID_assetID holdings, which sort by account
+	HOLDINGINDEX string = "holding"
+	// HOLDERINDEX holds the same holdings as assetID~accountID, which sort by asset
+	HOLDERINDEX string = "holder"
+)
+
+// indexMember is the value of an index entry, only its presence matters
+var indexMember = []byte("true")
+
+// reservedKeyPrefixes start the keys of states that are not assets, accounts
+// or holdings, whose keys are an ID, _ and another ID
+var reservedKeyPrefixes = []string{ASSETDEFINITIONKEYPREFIX, ASSETSCALEKEYPREFIX, RULETHRESHOLDSKEYPREFIX}
+
+// checkID refuses an assetID, accountID or asset type name that would let an
+// asset or holding key land on another kind of state. ~ separates the parts of
+// index, recent feed and history entry keys, so an ID cannot contain one, which
+// also rules out the idx~ and RecentStates~ prefixes.
+func checkID(kind string, id string) error {
+	if strings.Contains(id, "~") {
+		return fmt.Errorf("%s %s cannot contain ~", kind, id)
+	}
+	if strings.Contains(id, STATEHISTORYKEY) {
+		return fmt.Errorf("%s %s cannot contain %s", kind, id, STATEHISTORYKEY)
+	}
+	for _, prefix := range reservedKeyPrefixes {
+		if strings.HasPrefix(id+"_", prefix) {
+			return fmt.Errorf("%s %s cannot start with %s, it is reserved", kind, id, strings.TrimSuffix(prefix, "_"))
+		}
+	}
+	return nil
+}
+
+func indexKey(index string, key string) string {
+	return INDEXPREFIX + index + "~" + key
+}
+
+func addToIndex(stub shim.ChaincodeStubInterface, index string, key string) error {
+	err := stub.PutState(indexKey(index, key), indexMember)
+	if err != nil {
+		return fmt.Errorf("index %s failed to add %s: %s", index, key, err)
+	}
+	return nil
+}
+
+func removeFromIndex(stub shim.ChaincodeStubInterface, index string, key string) error {
+	err := stub.DelState(indexKey(index, key))
+	if err != nil {
+		return fmt.Errorf("index %s failed to remove %s: %s", index, key, err)
+	}
+	return nil
+}
+
+func inIndex(stub shim.ChaincodeStubInterface, index string, key string) bool {
+	member, err := stub.GetState(indexKey(index, key))
+	return err == nil && len(member) > 0
+}
+
+// indexMembers returns the keys in an index, sorted
+func indexMembers(stub shim.ChaincodeStubInterface, index string) ([]string, error) {
+	return indexMembersWithPrefix(stub, index, "")
+}
+
+// indexMembersWithPrefix returns the keys in an index that start with a prefix,
+// sorted and with the prefix trimmed. The range query is inclusive and
+// unordered, so anything outside the prefix is dropped.
+func indexMembersWithPrefix(stub shim.ChaincodeStubInterface, index string, keyPrefix string) ([]string, error) {
+	prefix := indexKey(index, keyPrefix)
+	iter, err := stub.RangeQueryState(prefix, prefix+"\U0010FFFF")
+	if err != nil {
+		return []string{}, fmt.Errorf("index %s range query failed: %s", index, err)
+	}
+	defer iter.Close()
+	keys := []string{}
+	for iter.HasNext() {
+		k, _, err := iter.Next()
+		if err != nil {
+			return []string{}, fmt.Errorf("index %s iteration failed: %s", index, err)
+		}
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, strings.TrimPrefix(k, prefix))
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// holderKey is the key of a holding in the holder index
+func holderKey(assetID string, accountID string) string {
+	return assetID + "~" + accountID
+}
+
+// checkNewHolding refuses a holding whose accountID_assetID key is the
+// assetID_type key of a device, as when alice holds US_D and there is a
+// device alice_US of type D
+func checkNewHolding(stub shim.ChaincodeStubInterface, sAccountKey string) error {
+	if assetIsActive(stub, sAccountKey) || assetIsDeleted(stub, sAccountKey) {
+		return fmt.Errorf("holding %s would overwrite the asset with the same key", sAccountKey)
+	}
+	return nil
+}
+
+// addHolding indexes a new holding by account and by asset
+func addHolding(stub shim.ChaincodeStubInterface, accountID string, assetID string) error {
+	err := addToIndex(stub, HOLDINGINDEX, accountID+"_"+assetID)
+	if err != nil {
+		return err
+	}
+	return addToIndex(stub, HOLDERINDEX, holderKey(assetID, accountID))
+}
+
+// migrateHolderIndex indexes every holding by asset
+func migrateHolderIndex(stub shim.ChaincodeStubInterface, state *ContractState, record *MigrationRecord) error {
+	aa, err := indexMembers(stub, HOLDINGINDEX)
+	if err != nil {
+		return err
+	}
+	for _, sAccountKey := range aa {
+		holding, _, err := getHoldingFromLedger(stub, sAccountKey)
+		if err != nil {
+			return err
+		}
+		accountID, _ := holding[ACCOUNTID].(string)
+		assetID, _ := holding[ASSETID].(string)
+		if accountID == "" || assetID == "" {
+			return fmt.Errorf("holding %s has no accountID or assetID", sAccountKey)
+		}
+		err = addToIndex(stub, HOLDERINDEX, holderKey(assetID, accountID))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func init() {
+	registerMigration(Migration{Name: "contractStateIndexes", From: "1.0", To: "1.1",
+		run: migrateContractStateIndexes})
+	registerMigration(Migration{Name: "holderIndex", From: "1.2", To: "1.3",
+		run: migrateHolderIndex})
+}
+
+// migrateContractStateIndexes moves the maps that contract states used to keep
+// their members in to the indexes, and drops them from the contract state
+func migrateContractStateIndexes(stub shim.ChaincodeStubInterface, state *ContractState, record *MigrationRecord) error {
+	legacy := []struct {
+		index   string
+		members map[string]bool
+	}{
+		{ASSETINDEX, state.ActiveAssets},
+		{DELETEDASSETINDEX, state.DeletedAssets},
+		{ACCOUNTINDEX, state.ActiveAccounts},
+		{HOLDINGINDEX, state.IssueAccounts},
+	}
+	for _, l := range legacy {
+		if len(l.members) > 0 {
+			log.Noticef("migrating %d members of the contract state to index %s", len(l.members), l.index)
+		}
+		for key, member := range l.members {
+			if !member {
+				continue
+			}
+			err := addToIndex(stub, l.index, key)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	state.ActiveAssets = nil
+	state.DeletedAssets = nil
+	state.ActiveAccounts = nil
+	state.IssueAccounts = nil
+	return nil
+}
+
+// withIndexes fills in the maps of a contract state from the indexes, which is
+// how readContractState has always shown them
+func withIndexes(stub shim.ChaincodeStubInterface, state ContractState) (ContractState, error) {
+	maps := []struct {
+		index   string
+		members *map[string]bool
+	}{
+		{ASSETINDEX, &state.ActiveAssets},
+		{DELETEDASSETINDEX, &state.DeletedAssets},
+		{ACCOUNTINDEX, &state.ActiveAccounts},
+		{HOLDINGINDEX, &state.IssueAccounts},
+	}
+	for _, m := range maps {
+		keys, err := indexMembers(stub, m.index)
+		if err != nil {
+			return state, err
+		}
+		*m.members = make(map[string]bool, len(keys))
+		for _, key := range keys {
+			(*m.members)[key] = true
+		}
+	}
+	return state, nil
+}
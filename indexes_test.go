@@ -0,0 +1,86 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCheckID(t *testing.T) {
+	tests := []struct {
+		id    string
+		fails bool
+	}{
+		{"m1", false},
+		{"motor_7", false},
+		{"Asset Definition", false},
+		{"idx~holding~alice", true},
+		{"RecentStates~devices", true},
+		{"a~b", true},
+		{"~", true},
+		// an ID, _ and another ID would be one of these keys
+		{"AssetDefinition", true},
+		{"AssetScale_EUR", true},
+		{"RuleThresholds", true},
+		{"m1.StateHistory", true},
+	}
+	for _, tt := range tests {
+		err := checkID(ASSETID, tt.id)
+		if tt.fails != (err != nil) {
+			t.Errorf("checkID(%q) = %v, want failure %v", tt.id, err, tt.fails)
+		}
+	}
+}
+
+// TestForgedIndexEntries creates an asset and an account whose keys would be
+// index entries, which would put alice's holding in a portfolio she does not
+// hold and stop her account from closing
+func TestForgedIndexEntries(t *testing.T) {
+	s := withHoldings(t)
+	before := s.snapshot()
+	s.as("mallory")
+	s.mustFailInvoke("createAsset", `{"assetID":"idx~holding~alice","assettype":"motor"}`)
+	s.mustFailInvoke("createAccount", `{"accountID":"idx~account~x","acname":"x"}`)
+	s.mustFailInvoke("createAccount", `{"accountID":"AssetDefinition","acname":"x"}`)
+	s.asAdmin().mustFailInvoke("defineAssetType", `{"name":"holding~alice"}`)
+	s.assertUnchanged(before, "an ID with a reserved form")
+}
+
+// TestIndexMaintenance follows an asset, an account and a holding through the
+// indexes that list them
+func TestIndexMaintenance(t *testing.T) {
+	s := withHoldings(t)
+	s.as("tech").mustInvoke("createAsset", `{"assetID":"m1","rpm":900,"max_rpm":1000}`)
+	s.mustInvoke("createAsset", `{"assetID":"m2","rpm":900,"max_rpm":1000}`)
+	s.mustInvoke("deleteAsset", `{"assetID":"m1","reason":"scrapped"}`)
+	s.mustInvoke("transferAsset", `{"accountID":"alice","accountIDTo":"bob","assetID":"USD","amount":"100"}`)
+
+	want := map[string][]string{
+		ASSETINDEX:        {"m2_motor"},
+		DELETEDASSETINDEX: {"m1_motor"},
+		ACCOUNTINDEX:      {accountKey("alice"), accountKey("bank"), accountKey("bob")},
+		HOLDINGINDEX:      {"alice_USD", "bob_USD"},
+		HOLDERINDEX:       {holderKey("USD", "alice"), holderKey("USD", "bob")},
+	}
+	for index, keys := range want {
+		got, err := indexMembers(s, index)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, keys) {
+			t.Errorf("index %s holds %q, want %q", index, got, keys)
+		}
+	}
+	holders, err := indexMembersWithPrefix(s, HOLDERINDEX, holderKey("USD", ""))
+	if err != nil || !reflect.DeepEqual(holders, []string{"alice", "bob"}) {
+		t.Errorf("holders of USD are %q, %v, want alice and bob", holders, err)
+	}
+
+	// the contract state is read with the members of the indexes
+	state := s.mustRead("readContractState", "").(map[string]interface{})
+	if assets, _ := state["activeAssets"].(map[string]interface{}); len(assets) != 1 || assets["m2_motor"] != true {
+		t.Errorf("readContractState active assets are %v, want m2_motor", state["activeAssets"])
+	}
+	if s.stateMap(CONTRACTSTATEKEY)["activeAssets"] != nil {
+		t.Error("the members of the indexes were written back to the contract state")
+	}
+}
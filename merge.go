@@ -0,0 +1,428 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+//***************************************************
+//* UPDATE MERGE MODES
+//***************************************************
+
+// Properties of the updateAsset argument that select how it changes the state,
+// they never become part of the state
+const (
+	MERGEMODE string = "mergeMode"
+	ARRAYMODE string = "arrayMode"
+	PATCH     string = "patch"
+)
+
+// Merge modes of updateAsset
+const (
+	// MERGEDEEP merges maps key by key and treats null as a value, the default
+	MERGEDEEP string = "merge"
+	// MERGEPATCH is an RFC 7396 JSON Merge Patch, null removes a property
+	MERGEPATCH string = "mergePatch"
+	// MERGEREPLACE makes the argument the whole new state
+	MERGEREPLACE string = "replace"
+	// MERGEJSONPATCH applies the RFC 6902 JSON Patch operations in patch
+	MERGEJSONPATCH string = "jsonPatch"
+)
+
+// Array modes of the merge and mergePatch modes, what an array in the
+// argument does to the array it meets in the state
+const (
+	ARRAYREPLACE string = "replace"
+	ARRAYAPPEND  string = "append"
+	ARRAYUNION   string = "union"
+)
+
+// contractProperties are kept from the ledger state whatever the update does,
+// they identify the asset or are maintained by the rules
+var contractProperties = []string{ASSETID, ASSETTYPE, "alerts"}
+
+// updateMode is how one updateAsset changes the state
+type updateMode struct {
+	merge string
+	array string
+	patch []PatchOperation
+}
+
+// updateModeFromArgs takes the mode properties out of an updateAsset argument
+func updateModeFromArgs(argsMap ArgsMap) (updateMode, error) {
+	m := updateMode{merge: MERGEDEEP}
+	if v, found := argsMap[MERGEMODE]; found {
+		m.merge, _ = v.(string)
+		delete(argsMap, MERGEMODE)
+	}
+	switch m.merge {
+	case MERGEDEEP:
+		m.array = ARRAYUNION
+	case MERGEPATCH, MERGEREPLACE, MERGEJSONPATCH:
+		m.array = ARRAYREPLACE
+	default:
+		return m, fmt.Errorf("mergeMode must be one of %s, %s, %s or %s", MERGEDEEP, MERGEPATCH, MERGEREPLACE, MERGEJSONPATCH)
+	}
+	if v, found := argsMap[ARRAYMODE]; found {
+		m.array, _ = v.(string)
+		delete(argsMap, ARRAYMODE)
+		if m.merge != MERGEDEEP && m.merge != MERGEPATCH {
+			return m, fmt.Errorf("arrayMode does not apply to mergeMode %s", m.merge)
+		}
+	}
+	switch m.array {
+	case ARRAYREPLACE, ARRAYAPPEND, ARRAYUNION:
+	default:
+		return m, fmt.Errorf("arrayMode must be one of %s, %s or %s", ARRAYREPLACE, ARRAYAPPEND, ARRAYUNION)
+	}
+	v, found := argsMap[PATCH]
+	delete(argsMap, PATCH)
+	if m.merge != MERGEJSONPATCH {
+		if found {
+			return m, fmt.Errorf("patch requires mergeMode %s", MERGEJSONPATCH)
+		}
+		return m, nil
+	}
+	ops, isArray := v.([]interface{})
+	if !isArray {
+		return m, errors.New("mergeMode jsonPatch requires patch, an array of operations")
+	}
+	for i, op := range ops {
+		o, err := patchOperationFromMap(op)
+		if err != nil {
+			return m, fmt.Errorf("patch operation %d %s", i, err)
+		}
+		m.patch = append(m.patch, o)
+	}
+	for k := range argsMap {
+		switch k {
+		case ASSETID, ASSETTYPE, ASSETNAME, CALLER:
+		default:
+			return m, fmt.Errorf("mergeMode jsonPatch takes its changes from patch only, found %s", k)
+		}
+	}
+	return m, nil
+}
+
+func patchOperationFromMap(op interface{}) (PatchOperation, error) {
+	var o PatchOperation
+	m, found := op.(map[string]interface{})
+	if !found {
+		return o, errors.New("is not an object")
+	}
+	o.Op, _ = m["op"].(string)
+	o.Path, found = m["path"].(string)
+	if !found {
+		return o, errors.New("does not include path")
+	}
+	var hasValue bool
+	o.Value, hasValue = m["value"]
+	switch o.Op {
+	case "add", "replace", "test":
+		if !hasValue {
+			return o, fmt.Errorf("%s does not include value", o.Op)
+		}
+	case "move", "copy":
+		o.From, found = m["from"].(string)
+		if !found {
+			return o, fmt.Errorf("%s does not include from", o.Op)
+		}
+	case "remove":
+	default:
+		return o, fmt.Errorf("op %q is not an RFC 6902 operation", o.Op)
+	}
+	return o, nil
+}
+
+// mergeState returns the state an update makes of the ledger state
+func mergeState(mode updateMode, update map[string]interface{}, ledger map[string]interface{}) (map[string]interface{}, error) {
+	var merged interface{}
+	var err error
+	switch mode.merge {
+	case MERGEDEEP:
+		merged = deepMerge(update, copyValue(ledger), mode.array)
+	case MERGEPATCH:
+		merged = mergePatch(copyValue(ledger), update, mode.array)
+	case MERGEREPLACE:
+		merged = copyValue(update)
+	case MERGEJSONPATCH:
+		merged, err = applyPatch(copyValue(ledger), mode.patch)
+		if err != nil {
+			return nil, err
+		}
+	}
+	state, found := merged.(map[string]interface{})
+	if !found {
+		return nil, errors.New("update does not leave the state an object")
+	}
+	for _, p := range contractProperties {
+		if v, found := ledger[p]; found {
+			state[p] = v
+		} else {
+			delete(state, p)
+		}
+	}
+	return state, nil
+}
+
+// mergePatch applies an RFC 7396 merge patch to target. Arrays are combined as
+// arrayMode says, the RFC itself always replaces them.
+func mergePatch(target interface{}, patch interface{}, arrayMode string) interface{} {
+	p, found := patch.(map[string]interface{})
+	if !found {
+		return mergeArrays(target, patch, arrayMode)
+	}
+	t, found := target.(map[string]interface{})
+	if !found {
+		t = make(map[string]interface{})
+	}
+	for k, v := range p {
+		tk, found := findMatchingKey(t, k)
+		if !found {
+			tk = k
+		}
+		if v == nil {
+			delete(t, tk)
+			continue
+		}
+		t[tk] = mergePatch(t[tk], v, arrayMode)
+	}
+	return t
+}
+
+// mergeArrays returns what src makes of dst, a src that is not an array, or
+// an array meeting anything but an array, replaces it
+func mergeArrays(dst interface{}, src interface{}, arrayMode string) interface{} {
+	s, found := src.([]interface{})
+	if !found {
+		return src
+	}
+	d, found := dst.([]interface{})
+	if !found || arrayMode == ARRAYREPLACE {
+		return s
+	}
+	out := make([]interface{}, len(d), len(d)+len(s))
+	copy(out, d)
+	for _, elem := range s {
+		if arrayMode == ARRAYUNION && containsValue(out, elem) {
+			continue
+		}
+		out = append(out, elem)
+	}
+	return out
+}
+
+func containsValue(arr []interface{}, val interface{}) bool {
+	for _, v := range arr {
+		if reflect.DeepEqual(v, val) {
+			return true
+		}
+	}
+	return false
+}
+
+// copyValue copies the maps and arrays of a decoded JSON value
+func copyValue(v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(val))
+		for k, child := range val {
+			m[k] = copyValue(child)
+		}
+		return m
+	case ArgsMap:
+		return copyValue(map[string]interface{}(val))
+	case []interface{}:
+		a := make([]interface{}, len(val))
+		for i, child := range val {
+			a[i] = copyValue(child)
+		}
+		return a
+	}
+	return v
+}
+
+// arrayIndex parses an array reference token, end allows the index just past
+// the last element that add inserts at
+func arrayIndex(token string, length int, end bool) (int, error) {
+	if end && token == "-" {
+		return length, nil
+	}
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || (token != "0" && strings.HasPrefix(token, "0")) {
+		return 0, fmt.Errorf("%q is not an array index", token)
+	}
+	if i > length || (!end && i == length) {
+		return 0, fmt.Errorf("index %d is out of range", i)
+	}
+	return i, nil
+}
+
+// valueAt returns the value a pointer's tokens refer to
+func valueAt(doc interface{}, tokens []string) (interface{}, error) {
+	for _, t := range tokens {
+		switch c := doc.(type) {
+		case map[string]interface{}:
+			child, found := c[t]
+			if !found {
+				return nil, fmt.Errorf("property %q not found", t)
+			}
+			doc = child
+		case []interface{}:
+			i, err := arrayIndex(t, len(c), false)
+			if err != nil {
+				return nil, err
+			}
+			doc = c[i]
+		default:
+			return nil, fmt.Errorf("%q is under a value that is neither an object nor an array", t)
+		}
+	}
+	return doc, nil
+}
+
+// updateAt replaces the container of the last token with what fn makes of it,
+// and returns the document
+func updateAt(doc interface{}, tokens []string, fn func(container interface{}, last string) (interface{}, error)) (interface{}, error) {
+	if len(tokens) == 1 {
+		return fn(doc, tokens[0])
+	}
+	switch c := doc.(type) {
+	case map[string]interface{}:
+		child, found := c[tokens[0]]
+		if !found {
+			return nil, fmt.Errorf("property %q not found", tokens[0])
+		}
+		child, err := updateAt(child, tokens[1:], fn)
+		if err != nil {
+			return nil, err
+		}
+		c[tokens[0]] = child
+		return c, nil
+	case []interface{}:
+		i, err := arrayIndex(tokens[0], len(c), false)
+		if err != nil {
+			return nil, err
+		}
+		c[i], err = updateAt(c[i], tokens[1:], fn)
+		if err != nil {
+			return nil, err
+		}
+		return c, nil
+	}
+	return nil, fmt.Errorf("%q is under a value that is neither an object nor an array", tokens[0])
+}
+
+func addAt(doc interface{}, tokens []string, value interface{}) (interface{}, error) {
+	if len(tokens) == 0 {
+		return value, nil
+	}
+	return updateAt(doc, tokens, func(container interface{}, last string) (interface{}, error) {
+		switch c := container.(type) {
+		case map[string]interface{}:
+			c[last] = value
+			return c, nil
+		case []interface{}:
+			i, err := arrayIndex(last, len(c), true)
+			if err != nil {
+				return nil, err
+			}
+			c = append(c, nil)
+			copy(c[i+1:], c[i:])
+			c[i] = value
+			return c, nil
+		}
+		return nil, fmt.Errorf("cannot add %q to a value that is neither an object nor an array", last)
+	})
+}
+
+func removeAt(doc interface{}, tokens []string) (interface{}, error) {
+	if len(tokens) == 0 {
+		return nil, errors.New("cannot remove the whole state")
+	}
+	return updateAt(doc, tokens, func(container interface{}, last string) (interface{}, error) {
+		switch c := container.(type) {
+		case map[string]interface{}:
+			if _, found := c[last]; !found {
+				return nil, fmt.Errorf("property %q not found", last)
+			}
+			delete(c, last)
+			return c, nil
+		case []interface{}:
+			i, err := arrayIndex(last, len(c), false)
+			if err != nil {
+				return nil, err
+			}
+			return append(c[:i], c[i+1:]...), nil
+		}
+		return nil, fmt.Errorf("cannot remove %q from a value that is neither an object nor an array", last)
+	})
+}
+
+// applyPatch applies RFC 6902 operations to a document in order, the first
+// that fails fails them all
+func applyPatch(doc interface{}, ops []PatchOperation) (interface{}, error) {
+	for i, op := range ops {
+		tokens, err := parsePointer(op.Path)
+		if err != nil {
+			return nil, fmt.Errorf("patch operation %d %s", i, err)
+		}
+		var from []string
+		if op.Op == "move" || op.Op == "copy" {
+			from, err = parsePointer(op.From)
+			if err != nil {
+				return nil, fmt.Errorf("patch operation %d %s", i, err)
+			}
+		}
+		switch op.Op {
+		case "add":
+			doc, err = addAt(doc, tokens, copyValue(op.Value))
+		case "remove":
+			doc, err = removeAt(doc, tokens)
+		case "replace":
+			if len(tokens) == 0 {
+				doc = copyValue(op.Value)
+				break
+			}
+			_, err = valueAt(doc, tokens)
+			if err == nil {
+				doc, err = removeAt(doc, tokens)
+			}
+			if err == nil {
+				doc, err = addAt(doc, tokens, copyValue(op.Value))
+			}
+		case "move":
+			if strings.HasPrefix(op.Path+"/", op.From+"/") && op.Path != op.From {
+				err = errors.New("cannot move a value into itself")
+				break
+			}
+			var v interface{}
+			v, err = valueAt(doc, from)
+			if err == nil {
+				doc, err = removeAt(doc, from)
+			}
+			if err == nil {
+				doc, err = addAt(doc, tokens, v)
+			}
+		case "copy":
+			var v interface{}
+			v, err = valueAt(doc, from)
+			if err == nil {
+				doc, err = addAt(doc, tokens, copyValue(v))
+			}
+		case "test":
+			var v interface{}
+			v, err = valueAt(doc, tokens)
+			if err == nil && !reflect.DeepEqual(v, op.Value) {
+				err = fmt.Errorf("test of %s failed", op.Path)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("patch operation %d %s %s: %s", i, op.Op, op.Path, err)
+		}
+	}
+	return doc, nil
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// decodeJSON decodes a test document as the contract decodes states
+func decodeJSON(t *testing.T, s string) interface{} {
+	t.Helper()
+	var v interface{}
+	err := json.Unmarshal([]byte(s), &v)
+	if err != nil {
+		t.Fatalf("bad test JSON %s: %s", s, err)
+	}
+	return v
+}
+
+// decodePatch decodes test operations as updateAsset does
+func decodePatch(t *testing.T, s string) []PatchOperation {
+	t.Helper()
+	raw, found := decodeJSON(t, s).([]interface{})
+	if !found {
+		t.Fatalf("test patch %s is not an array", s)
+	}
+	ops := make([]PatchOperation, len(raw))
+	for i, r := range raw {
+		op, err := patchOperationFromMap(r)
+		if err != nil {
+			t.Fatalf("test patch %s operation %d %s", s, i, err)
+		}
+		ops[i] = op
+	}
+	return ops
+}
+
+// TestMergePatchRFC7396 runs the examples of RFC 7396 Appendix A
+func TestMergePatchRFC7396(t *testing.T) {
+	tests := []struct {
+		target, patch, want string
+	}{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"a":"foo"}`, `null`, `null`},
+		{`{"a":"foo"}`, `"bar"`, `"bar"`},
+		{`{"e":null}`, `{"a":1}`, `{"e":null,"a":1}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	}
+	for _, tt := range tests {
+		got := mergePatch(decodeJSON(t, tt.target), decodeJSON(t, tt.patch), ARRAYREPLACE)
+		if want := decodeJSON(t, tt.want); !reflect.DeepEqual(got, want) {
+			t.Errorf("mergePatch(%s, %s) = %v, want %s", tt.target, tt.patch, got, tt.want)
+		}
+	}
+}
+
+func TestMergePatchArrayModes(t *testing.T) {
+	tests := []struct {
+		arrayMode           string
+		target, patch, want string
+	}{
+		{ARRAYREPLACE, `{"a":[1,2]}`, `{"a":[2,3]}`, `{"a":[2,3]}`},
+		{ARRAYAPPEND, `{"a":[1,2]}`, `{"a":[2,3]}`, `{"a":[1,2,2,3]}`},
+		{ARRAYUNION, `{"a":[1,2]}`, `{"a":[2,3]}`, `{"a":[1,2,3]}`},
+		{ARRAYUNION, `{"a":[{"x":1}]}`, `{"a":[{"x":1},{"x":2}]}`, `{"a":[{"x":1},{"x":2}]}`},
+		// an array only combines with an array
+		{ARRAYAPPEND, `{"a":"b"}`, `{"a":[1]}`, `{"a":[1]}`},
+		{ARRAYAPPEND, `{"a":[1]}`, `{"a":"b"}`, `{"a":"b"}`},
+	}
+	for _, tt := range tests {
+		got := mergePatch(decodeJSON(t, tt.target), decodeJSON(t, tt.patch), tt.arrayMode)
+		if want := decodeJSON(t, tt.want); !reflect.DeepEqual(got, want) {
+			t.Errorf("mergePatch(%s, %s, %s) = %v, want %s", tt.target, tt.patch, tt.arrayMode, got, tt.want)
+		}
+	}
+}
+
+// TestApplyPatchRFC6902 runs the examples of RFC 6902 Appendix A and the edge
+// cases of array indexes and pointers
+func TestApplyPatchRFC6902(t *testing.T) {
+	tests := []struct {
+		name       string
+		doc, patch string
+		want       string
+		fails      bool
+	}{
+		{"A.1 add an object member", `{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux"}]`,
+			`{"baz":"qux","foo":"bar"}`, false},
+		{"A.2 add an array element", `{"foo":["bar","baz"]}`, `[{"op":"add","path":"/foo/1","value":"qux"}]`,
+			`{"foo":["bar","qux","baz"]}`, false},
+		{"A.3 remove an object member", `{"baz":"qux","foo":"bar"}`, `[{"op":"remove","path":"/baz"}]`,
+			`{"foo":"bar"}`, false},
+		{"A.4 remove an array element", `{"foo":["bar","qux","baz"]}`, `[{"op":"remove","path":"/foo/1"}]`,
+			`{"foo":["bar","baz"]}`, false},
+		{"A.5 replace a value", `{"baz":"qux","foo":"bar"}`, `[{"op":"replace","path":"/baz","value":"boo"}]`,
+			`{"baz":"boo","foo":"bar"}`, false},
+		{"A.6 move a value", `{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`,
+			`[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`,
+			`{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`, false},
+		{"A.7 move an array element", `{"foo":["all","grass","cows","eat"]}`,
+			`[{"op":"move","from":"/foo/1","path":"/foo/3"}]`, `{"foo":["all","cows","eat","grass"]}`, false},
+		{"A.8 test a value", `{"baz":"qux","foo":["a",2,"c"]}`,
+			`[{"op":"test","path":"/baz","value":"qux"},{"op":"test","path":"/foo/1","value":2}]`,
+			`{"baz":"qux","foo":["a",2,"c"]}`, false},
+		{"A.9 test a value that differs", `{"baz":"qux"}`, `[{"op":"test","path":"/baz","value":"bar"}]`, ``, true},
+		{"A.10 add a nested member object", `{"foo":"bar"}`, `[{"op":"add","path":"/child","value":{"grandchild":{}}}]`,
+			`{"foo":"bar","child":{"grandchild":{}}}`, false},
+		{"A.12 add to a nonexistent target", `{"foo":"bar"}`, `[{"op":"add","path":"/baz/bat","value":"qux"}]`, ``, true},
+		{"A.14 ~ escape ordering", `{"/":9,"~1":10}`, `[{"op":"test","path":"/~01","value":10}]`,
+			`{"/":9,"~1":10}`, false},
+		{"A.15 a string is not a number", `{"/":9,"~1":10}`, `[{"op":"test","path":"/~01","value":"10"}]`, ``, true},
+		{"A.16 add an array value", `{"foo":["bar"]}`, `[{"op":"add","path":"/foo/-","value":["abc","def"]}]`,
+			`{"foo":["bar",["abc","def"]]}`, false},
+		{"~1 is a slash", `{"a/b":1}`, `[{"op":"replace","path":"/a~1b","value":2}]`, `{"a/b":2}`, false},
+		{"an empty key", `{"":1}`, `[{"op":"remove","path":"/"}]`, `{}`, false},
+		{"add replaces the whole document", `{"a":1}`, `[{"op":"add","path":"","value":{"b":2}}]`, `{"b":2}`, false},
+		{"replace the whole document", `{"a":1}`, `[{"op":"replace","path":"","value":[1]}]`, `[1]`, false},
+		{"remove the whole document", `{"a":1}`, `[{"op":"remove","path":""}]`, ``, true},
+		{"add at the end index", `{"a":[1,2]}`, `[{"op":"add","path":"/a/2","value":3}]`, `{"a":[1,2,3]}`, false},
+		{"add past the end", `{"a":[1,2]}`, `[{"op":"add","path":"/a/3","value":3}]`, ``, true},
+		{"remove past the end", `{"a":[1,2]}`, `[{"op":"remove","path":"/a/2"}]`, ``, true},
+		{"leading zero index", `{"a":[1,2]}`, `[{"op":"remove","path":"/a/01"}]`, ``, true},
+		{"negative index", `{"a":[1,2]}`, `[{"op":"remove","path":"/a/-1"}]`, ``, true},
+		{"- only adds", `{"a":[1,2]}`, `[{"op":"replace","path":"/a/-","value":3}]`, ``, true},
+		{"replace a missing member", `{"a":1}`, `[{"op":"replace","path":"/b","value":2}]`, ``, true},
+		{"remove a missing member", `{"a":1}`, `[{"op":"remove","path":"/b"}]`, ``, true},
+		{"pointer without a slash", `{"a":1}`, `[{"op":"remove","path":"a"}]`, ``, true},
+		{"move into itself", `{"a":{"b":1}}`, `[{"op":"move","from":"/a","path":"/a/b"}]`, ``, true},
+		{"move to the same place", `{"a":{"b":1}}`, `[{"op":"move","from":"/a","path":"/a"}]`, `{"a":{"b":1}}`, false},
+		{"move to a sibling with a common prefix", `{"a":1}`, `[{"op":"move","from":"/a","path":"/ab"}]`, `{"ab":1}`, false},
+		{"copy is deep", `{"a":{"b":1}}`,
+			`[{"op":"copy","from":"/a","path":"/c"},{"op":"replace","path":"/c/b","value":2}]`,
+			`{"a":{"b":1},"c":{"b":2}}`, false},
+		{"operations apply in order", `{}`,
+			`[{"op":"add","path":"/a","value":[]},{"op":"add","path":"/a/-","value":1},{"op":"add","path":"/a/0","value":0}]`,
+			`{"a":[0,1]}`, false},
+		{"a failing operation fails them all", `{"a":1}`,
+			`[{"op":"add","path":"/b","value":2},{"op":"test","path":"/a","value":2}]`, ``, true},
+		{"test a whole object", `{"a":{"b":[1,{"c":null}]}}`, `[{"op":"test","path":"/a","value":{"b":[1,{"c":null}]}}]`,
+			`{"a":{"b":[1,{"c":null}]}}`, false},
+	}
+	for _, tt := range tests {
+		got, err := applyPatch(decodeJSON(t, tt.doc), decodePatch(t, tt.patch))
+		if tt.fails {
+			if err == nil {
+				t.Errorf("%s: applyPatch = %v, want an error", tt.name, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: applyPatch failed: %s", tt.name, err)
+			continue
+		}
+		if want := decodeJSON(t, tt.want); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: applyPatch = %v, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPatchOperationFromMap(t *testing.T) {
+	tests := []struct {
+		op    string
+		fails bool
+	}{
+		{`{"op":"add","path":"/a","value":null}`, false},
+		{`{"op":"remove","path":"/a"}`, false},
+		{`{"op":"move","from":"/a","path":"/b"}`, false},
+		{`{"op":"add","path":"/a"}`, true},
+		{`{"op":"test","path":"/a"}`, true},
+		{`{"op":"copy","path":"/a"}`, true},
+		{`{"op":"remove"}`, true},
+		{`{"op":"merge","path":"/a","value":1}`, true},
+		{`{"path":"/a","value":1}`, true},
+		{`"add"`, true},
+	}
+	for _, tt := range tests {
+		_, err := patchOperationFromMap(decodeJSON(t, tt.op))
+		if tt.fails != (err != nil) {
+			t.Errorf("patchOperationFromMap(%s) error = %v, want failure %v", tt.op, err, tt.fails)
+		}
+	}
+}
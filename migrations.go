@@ -0,0 +1,198 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//***************************************************
+//* MIGRATIONS
+//***************************************************
+
+// Migration is a step that brings ledger data written by contract version From
+// up to version To. Steps run in version order, and in the order they were
+// registered within a version, except that a step runs after the step of its
+// version named in After. Steps are registered by the code they migrate, so the
+// registration order across files is not something to rely on. A step that has
+// to change a value rather than just move it lists the change in the record of
+// the step.
+type Migration struct {
+	Name  string
+	From  string
+	To    string
+	After string
+	run   func(stub shim.ChaincodeStubInterface, state *ContractState, record *MigrationRecord) error
+}
+
+// MigrationRecord is kept in the contract state for each step that completed
+type MigrationRecord struct {
+	Name        string                `json:"name"`
+	From        string                `json:"from"`
+	To          string                `json:"to"`
+	TxID        string                `json:"txID"`
+	Timestamp   string                `json:"timestamp"`
+	Adjustments []MigrationAdjustment `json:"adjustments,omitempty"`
+}
+
+// MigrationAdjustment is a value a migration step changed, such as a legacy
+// balance rounded to its asset's scale
+type MigrationAdjustment struct {
+	Key    string `json:"key"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+var migrations []Migration
+
+func registerMigration(m Migration) {
+	migrations = append(migrations, m)
+}
+
+// compareVersions compares dotted version numbers, returning -1, 0 or 1
+func compareVersions(a string, b string) (int, error) {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var an, bn int
+		var err error
+		if i < len(as) {
+			an, err = strconv.Atoi(as[i])
+			if err != nil {
+				return 0, fmt.Errorf("version %s is not a dotted number", a)
+			}
+		}
+		if i < len(bs) {
+			bn, err = strconv.Atoi(bs[i])
+			if err != nil {
+				return 0, fmt.Errorf("version %s is not a dotted number", b)
+			}
+		}
+		if an < bn {
+			return -1, nil
+		}
+		if an > bn {
+			return 1, nil
+		}
+	}
+	return 0, nil
+}
+
+// pendingMigrations returns the steps between the ledger's version and this
+// contract's version, in the order they must run
+func pendingMigrations(from string) ([]Migration, error) {
+	pending := []Migration{}
+	for _, m := range migrations {
+		afterFrom, err := compareVersions(m.From, from)
+		if err != nil {
+			return nil, err
+		}
+		beforeMine, err := compareVersions(m.To, MYVERSION)
+		if err != nil {
+			return nil, err
+		}
+		if afterFrom < 0 || beforeMine > 0 {
+			continue
+		}
+		// insert after every step from the same or an earlier version
+		i := len(pending)
+		for i > 0 {
+			c, _ := compareVersions(pending[i-1].From, m.From)
+			if c <= 0 {
+				break
+			}
+			i--
+		}
+		pending = append(pending, Migration{})
+		copy(pending[i+1:], pending[i:])
+		pending[i] = m
+	}
+	return orderMigrations(pending)
+}
+
+// orderMigrations moves each step after the pending step of its version that
+// it names in After, and keeps the order of the rest. A step named in After
+// that is not pending has already run or does not exist, and holds nothing up.
+func orderMigrations(pending []Migration) ([]Migration, error) {
+	isPending := make(map[string]bool)
+	for _, m := range pending {
+		isPending[m.From+"~"+m.Name] = true
+	}
+	ran := make(map[string]bool)
+	ordered := make([]Migration, 0, len(pending))
+	for len(ordered) < len(pending) {
+		next := -1
+		for i, m := range pending {
+			if ran[m.From+"~"+m.Name] {
+				continue
+			}
+			after := m.From + "~" + m.After
+			if m.After == "" || !isPending[after] || ran[after] {
+				next = i
+				break
+			}
+		}
+		if next < 0 {
+			return nil, errors.New("migrations are waiting on each other")
+		}
+		ran[pending[next].From+"~"+pending[next].Name] = true
+		ordered = append(ordered, pending[next])
+	}
+	return ordered, nil
+}
+
+func migrationCompleted(state *ContractState, m Migration) bool {
+	for _, r := range state.Migrations {
+		if r.Name == m.Name && r.From == m.From && r.To == m.To {
+			return true
+		}
+	}
+	return false
+}
+
+// runMigrations brings the ledger up to this contract's version. A ledger
+// written by a later version is refused rather than downgraded. Each step is
+// recorded as it completes.
+func runMigrations(stub shim.ChaincodeStubInterface, state *ContractState) error {
+	c, err := compareVersions(state.Version, MYVERSION)
+	if err != nil {
+		return err
+	}
+	if c > 0 {
+		return fmt.Errorf("ledger is at contract version %s, version %s cannot downgrade it", state.Version, MYVERSION)
+	}
+	if c == 0 {
+		return nil
+	}
+	log.Noticef("Contract version has changed from %s to %s", state.Version, MYVERSION)
+	pending, err := pendingMigrations(state.Version)
+	if err != nil {
+		return err
+	}
+	for _, m := range pending {
+		if migrationCompleted(state, m) {
+			continue
+		}
+		log.Noticef("running migration %s from version %s to %s", m.Name, m.From, m.To)
+		record := MigrationRecord{
+			Name:      m.Name,
+			From:      m.From,
+			To:        m.To,
+			TxID:      stub.GetTxID(),
+			Timestamp: txTimestamp(stub).Format(time.RFC3339Nano),
+		}
+		err = m.run(stub, state, &record)
+		if err != nil {
+			return fmt.Errorf("migration %s from version %s to %s failed: %s", m.Name, m.From, m.To, err)
+		}
+		state.Migrations = append(state.Migrations, record)
+		err = PUTContractStateToLedger(stub, *state)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
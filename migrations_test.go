@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b  string
+		want  int
+		fails bool
+	}{
+		{"1.0", "1.0", 0, false},
+		{"1.0", "1.1", -1, false},
+		{"1.2", "1.1", 1, false},
+		// numerically, not as strings
+		{"1.10", "1.9", 1, false},
+		{"2.0", "10.0", -1, false},
+		// missing parts are 0
+		{"1", "1.0", 0, false},
+		{"1.0.0", "1", 0, false},
+		{"1.0.1", "1.0", 1, false},
+		{"1", "1.0.1", -1, false},
+		{"1.x", "1.0", 0, true},
+		{"1.0", "", 0, true},
+		{"1..0", "1.0", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := compareVersions(tt.a, tt.b)
+		if tt.fails {
+			if err == nil {
+				t.Errorf("compareVersions(%q, %q) = %d, want an error", tt.a, tt.b, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("compareVersions(%q, %q) failed: %s", tt.a, tt.b, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("compareVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestPendingMigrations(t *testing.T) {
+	registered := migrations
+	defer func() { migrations = registered }()
+	// registered out of version order, and with a step past this version
+	migrations = []Migration{
+		{Name: "b1", From: "1.1", To: "1.2"},
+		{Name: "a1", From: "1.0", To: "1.1"},
+		{Name: "future", From: MYVERSION, To: "99.0"},
+		{Name: "a2", From: "1.0", To: "1.1"},
+		{Name: "c1", From: "1.2", To: "1.3"},
+		{Name: "b2", From: "1.1", To: "1.2"},
+	}
+	tests := []struct {
+		from  string
+		want  []string
+		fails bool
+	}{
+		{"1.0", []string{"a1", "a2", "b1", "b2", "c1"}, false},
+		{"1.1", []string{"b1", "b2", "c1"}, false},
+		{"1.2", []string{"c1"}, false},
+		{MYVERSION, []string{}, false},
+		{"0.9", []string{"a1", "a2", "b1", "b2", "c1"}, false},
+		{"one", nil, true},
+	}
+	for _, tt := range tests {
+		pending, err := pendingMigrations(tt.from)
+		if tt.fails {
+			if err == nil {
+				t.Errorf("pendingMigrations(%q) = %v, want an error", tt.from, pending)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("pendingMigrations(%q) failed: %s", tt.from, err)
+			continue
+		}
+		got := []string{}
+		for _, m := range pending {
+			got = append(got, m.Name)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("pendingMigrations(%q) = %q, want %q", tt.from, got, tt.want)
+		}
+	}
+}
+
+// TestMigrationsAfter registers steps before the steps they must follow, as
+// the init functions of different files can
+func TestMigrationsAfter(t *testing.T) {
+	registered := migrations
+	defer func() { migrations = registered }()
+	migrations = []Migration{
+		{Name: "amounts", From: "1.0", To: "1.1", After: "indexes"},
+		{Name: "feeds", From: "1.1", To: "1.2"},
+		{Name: "keys", From: "1.0", To: "1.1", After: "amounts"},
+		{Name: "indexes", From: "1.0", To: "1.1"},
+		{Name: "holders", From: "1.1", To: "1.2", After: "gone"},
+	}
+	pending, err := pendingMigrations("1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []string{}
+	for _, m := range pending {
+		got = append(got, m.Name)
+	}
+	want := []string{"indexes", "amounts", "keys", "feeds", "holders"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("pendingMigrations = %q, want %q", got, want)
+	}
+
+	migrations = []Migration{
+		{Name: "a", From: "1.0", To: "1.1", After: "b"},
+		{Name: "b", From: "1.0", To: "1.1", After: "a"},
+	}
+	pending, err = pendingMigrations("1.0")
+	if err == nil {
+		t.Errorf("pendingMigrations = %v for steps that wait on each other, want an error", pending)
+	}
+}
+
+// TestRegisteredMigrations checks that the registered steps take a 1.0 ledger
+// all the way to this version without a gap
+func TestRegisteredMigrations(t *testing.T) {
+	pending, err := pendingMigrations("1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	version := "1.0"
+	ran := make(map[string]bool)
+	for _, m := range pending {
+		if c, _ := compareVersions(m.From, version); c > 0 {
+			t.Errorf("migration %s starts at %s, the ledger is only at %s", m.Name, m.From, version)
+		}
+		if m.run == nil {
+			t.Errorf("migration %s has nothing to run", m.Name)
+		}
+		if m.After != "" && !ran[m.After] {
+			t.Errorf("migration %s runs after %s, which is not an earlier step of %s", m.Name, m.After, m.From)
+		}
+		ran[m.Name] = true
+		version = m.To
+	}
+	if version != MYVERSION {
+		t.Errorf("migrations end at %s, want %s", version, MYVERSION)
+	}
+}
+
+// TestLegacyUpgrade deploys this version over a 1.0 ledger whose balances are
+// floats, one of which is not exact at its scale
+func TestLegacyUpgrade(t *testing.T) {
+	s := newMemStub(t)
+	s.state = map[string][]byte{
+		CONTRACTSTATEKEY: []byte(`{"version":"1.0","nickname":"BUILDING",
+			"IssueAccounts":{"alice_USD":true,"bob_USD":true}}`),
+		"alice_USD": []byte(`{"accountID":"alice","assetID":"USD","amount":0.30000000000000004}`),
+		"bob_USD":   []byte(`{"accountID":"bob","assetID":"USD","amount":12.5}`),
+	}
+	s.mustInit()
+
+	for key, want := range map[string]string{"alice_USD": "0.30", "bob_USD": "12.50"} {
+		if got := s.stateMap(key)[AMOUNT]; got != want {
+			t.Errorf("%s amount is %v, want %s", key, got, want)
+		}
+	}
+	var state ContractState
+	err := json.Unmarshal(s.state[CONTRACTSTATEKEY], &state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Version != MYVERSION || state.IssueAccounts != nil {
+		t.Errorf("contract state is at %s with holdings %v, want %s with the holdings indexed", state.Version, state.IssueAccounts, MYVERSION)
+	}
+	var adjustments []MigrationAdjustment
+	ran := []string{}
+	for _, r := range state.Migrations {
+		ran = append(ran, r.Name)
+		adjustments = append(adjustments, r.Adjustments...)
+	}
+	pending, _ := pendingMigrations("1.0")
+	if len(ran) != len(pending) {
+		t.Errorf("migrations %q ran, want all %d", ran, len(pending))
+	}
+	want := []MigrationAdjustment{{"alice_USD", "0.30000000000000004", "0.30"}}
+	if !reflect.DeepEqual(adjustments, want) {
+		t.Errorf("adjustments are %v, want %v", adjustments, want)
+	}
+	if got := s.mustRead("readBalance", `{"accountID":"alice","assetID":"USD"}`).(map[string]interface{}); got[AMOUNT] != "0.30" {
+		t.Errorf("alice's balance reads %v, want 0.30", got[AMOUNT])
+	}
+
+	// a second deploy of the same version runs nothing again
+	s.mustInit()
+	var again ContractState
+	json.Unmarshal(s.state[CONTRACTSTATEKEY], &again)
+	if len(again.Migrations) != len(state.Migrations) {
+		t.Errorf("a second Init recorded %d migrations, want %d", len(again.Migrations), len(state.Migrations))
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+//***************************************************
+//* PATHS
+//***************************************************
+
+// PATHWILDCARD selects every property of an object or element of an array
+const PATHWILDCARD string = "*"
+
+// Path addresses properties of a state. It is written either as an RFC 6901
+// JSON Pointer such as /sensors/3/name, which can reach keys containing dots,
+// or as a qualified name such as sensors[3].name. In both a * selects every
+// property or element at its level, as in channels.*.calibration. A token
+// that is an array index selects that element of an array.
+type Path struct {
+	text   string
+	tokens []string
+}
+
+// parsePointer splits an RFC 6901 JSON Pointer into its reference tokens
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return []string{}, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("%q is not a JSON Pointer", pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.Replace(strings.Replace(t, "~1", "/", -1), "~0", "~", -1)
+	}
+	return tokens, nil
+}
+
+// parsePath parses a JSON Pointer or a qualified name
+func parsePath(text string) (Path, error) {
+	p := Path{text: text}
+	if strings.HasPrefix(text, "/") {
+		tokens, err := parsePointer(text)
+		if err != nil {
+			return p, err
+		}
+		p.tokens = tokens
+		return p, nil
+	}
+	if text == "" {
+		return p, fmt.Errorf("path is empty")
+	}
+	for _, segment := range strings.Split(text, ".") {
+		name := segment
+		var indexes []string
+		if i := strings.Index(segment, "["); i >= 0 {
+			name = segment[:i]
+			rest := segment[i:]
+			for rest != "" {
+				end := strings.Index(rest, "]")
+				if !strings.HasPrefix(rest, "[") || end < 0 {
+					return p, fmt.Errorf("path %q has a malformed [index]", text)
+				}
+				index := rest[1:end]
+				if _, err := strconv.Atoi(index); err != nil && index != PATHWILDCARD {
+					return p, fmt.Errorf("path %q has [%s], which is neither an index nor *", text, index)
+				}
+				indexes = append(indexes, index)
+				rest = rest[end+1:]
+			}
+			if name == "" && len(p.tokens) > 0 {
+				return p, fmt.Errorf("path %q has an empty name before [", text)
+			}
+		} else if name == "" {
+			return p, fmt.Errorf("path %q has an empty name", text)
+		}
+		if name != "" {
+			p.tokens = append(p.tokens, name)
+		}
+		p.tokens = append(p.tokens, indexes...)
+	}
+	return p, nil
+}
+
+// hasWildcard is true when the path can select more than one value
+func (p Path) hasWildcard() bool {
+	for _, t := range p.tokens {
+		if t == PATHWILDCARD {
+			return true
+		}
+	}
+	return false
+}
+
+// last is the final token, the name of what the path selects
+func (p Path) last() string {
+	if len(p.tokens) == 0 {
+		return ""
+	}
+	return p.tokens[len(p.tokens)-1]
+}
+
+// children returns the keys or indexes of a value that a token selects, an
+// object key is matched as the contract's case mode says
+func children(value interface{}, token string) []string {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if token == PATHWILDCARD {
+			return sortedKeys(v)
+		}
+		if key, found := findMatchingKey(v, token); found {
+			return []string{key}
+		}
+	case ArgsMap:
+		return children(map[string]interface{}(v), token)
+	case []interface{}:
+		if token == PATHWILDCARD {
+			indexes := make([]string, len(v))
+			for i := range v {
+				indexes[i] = strconv.Itoa(i)
+			}
+			return indexes
+		}
+		if i, err := strconv.Atoi(token); err == nil && i >= 0 && i < len(v) {
+			return []string{token}
+		}
+	}
+	return nil
+}
+
+func child(value interface{}, key string) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return v[key]
+	case ArgsMap:
+		return v[key]
+	case []interface{}:
+		i, _ := strconv.Atoi(key)
+		return v[i]
+	}
+	return nil
+}
+
+// values returns every value the path selects, in key and index order
+func (p Path) values(doc interface{}) []interface{} {
+	found := []interface{}{doc}
+	for _, t := range p.tokens {
+		next := make([]interface{}, 0, len(found))
+		for _, v := range found {
+			for _, key := range children(v, t) {
+				next = append(next, child(v, key))
+			}
+		}
+		found = next
+	}
+	return found
+}
+
+// remove deletes every value the path selects and returns the document with
+// the number removed. Objects are changed in place, arrays are shortened.
+func (p Path) remove(doc interface{}) (interface{}, int) {
+	if len(p.tokens) == 0 {
+		return doc, 0
+	}
+	return removeTokens(doc, p.tokens)
+}
+
+func removeTokens(value interface{}, tokens []string) (interface{}, int) {
+	keys := children(value, tokens[0])
+	removed := 0
+	if len(tokens) > 1 {
+		for _, key := range keys {
+			c, n := removeTokens(child(value, key), tokens[1:])
+			removed += n
+			switch v := value.(type) {
+			case map[string]interface{}:
+				v[key] = c
+			case ArgsMap:
+				v[key] = c
+			case []interface{}:
+				i, _ := strconv.Atoi(key)
+				v[i] = c
+			}
+		}
+		return value, removed
+	}
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for _, key := range keys {
+			delete(v, key)
+		}
+	case ArgsMap:
+		for _, key := range keys {
+			delete(v, key)
+		}
+	case []interface{}:
+		// from the end, so that the indexes still to go stay valid
+		for j := len(keys) - 1; j >= 0; j-- {
+			i, _ := strconv.Atoi(keys[j])
+			v = append(v[:i], v[i+1:]...)
+		}
+		return v, len(keys)
+	}
+	return value, len(keys)
+}
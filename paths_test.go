@@ -0,0 +1,151 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestParsePointer runs the RFC 6901 escapes, ~1 is unescaped before ~0
+func TestParsePointer(t *testing.T) {
+	tests := []struct {
+		pointer string
+		want    []string
+		fails   bool
+	}{
+		{"", []string{}, false},
+		{"/", []string{""}, false},
+		{"/foo", []string{"foo"}, false},
+		{"/foo/0", []string{"foo", "0"}, false},
+		{"/a~1b", []string{"a/b"}, false},
+		{"/m~0n", []string{"m~n"}, false},
+		{"/~01", []string{"~1"}, false},
+		{"/~10", []string{"/0"}, false},
+		{"/a.b/c d", []string{"a.b", "c d"}, false},
+		{"//", []string{"", ""}, false},
+		{"foo", nil, true},
+		{"#/foo", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := parsePointer(tt.pointer)
+		if tt.fails {
+			if err == nil {
+				t.Errorf("parsePointer(%q) = %q, want an error", tt.pointer, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parsePointer(%q) failed: %s", tt.pointer, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parsePointer(%q) = %q, want %q", tt.pointer, got, tt.want)
+		}
+	}
+}
+
+func TestParsePath(t *testing.T) {
+	tests := []struct {
+		text  string
+		want  []string
+		fails bool
+	}{
+		{"a", []string{"a"}, false},
+		{"a.b.c", []string{"a", "b", "c"}, false},
+		{"sensors[3].name", []string{"sensors", "3", "name"}, false},
+		{"a[0][*]", []string{"a", "0", "*"}, false},
+		{"channels.*.calibration", []string{"channels", "*", "calibration"}, false},
+		{"[2]", []string{"2"}, false},
+		// a pointer reaches keys with dots and brackets
+		{"/a.b/c[0]", []string{"a.b", "c[0]"}, false},
+		{"/a~1b", []string{"a/b"}, false},
+		{"", nil, true},
+		{"a..b", nil, true},
+		{"a.", nil, true},
+		{".a", nil, true},
+		{"a.[0]", nil, true},
+		{"a[0", nil, true},
+		{"a[0]b", nil, true},
+		{"a[x]", nil, true},
+		{"a[]", nil, true},
+	}
+	for _, tt := range tests {
+		p, err := parsePath(tt.text)
+		if tt.fails {
+			if err == nil {
+				t.Errorf("parsePath(%q) = %q, want an error", tt.text, p.tokens)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parsePath(%q) failed: %s", tt.text, err)
+			continue
+		}
+		if !reflect.DeepEqual(p.tokens, tt.want) {
+			t.Errorf("parsePath(%q) = %q, want %q", tt.text, p.tokens, tt.want)
+		}
+	}
+}
+
+func TestPathValues(t *testing.T) {
+	doc := `{"a":{"b":1,"c":2},"s":[{"n":"x"},{"n":"y"},{"m":"z"}],"d.e":3,"Name":"motor"}`
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"a.b", `[1]`},
+		{"a", `[{"b":1,"c":2}]`},
+		{"a.*", `[1,2]`},
+		{"s[1].n", `["y"]`},
+		{"s[*].n", `["x","y"]`},
+		{"s.*.n", `["x","y"]`},
+		{"/s/0/n", `["x"]`},
+		{"/d.e", `[3]`},
+		{"name", `["motor"]`},
+		{"*", `["motor",{"b":1,"c":2},3,[{"n":"x"},{"n":"y"},{"m":"z"}]]`},
+		{"a.x", `[]`},
+		{"s[3]", `[]`},
+		{"a.b.c", `[]`},
+		{"d.e", `[]`},
+	}
+	for _, tt := range tests {
+		p, err := parsePath(tt.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := p.values(decodeJSON(t, doc))
+		if want := decodeJSON(t, tt.want); !reflect.DeepEqual(interface{}(got), want) {
+			t.Errorf("values(%s) = %v, want %s", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestPathRemove(t *testing.T) {
+	doc := `{"a":{"b":1,"c":2},"s":[{"n":"x"},{"n":"y"},{"m":"z"}],"l":[1,2,3]}`
+	tests := []struct {
+		path  string
+		want  string
+		count int
+	}{
+		{"a.b", `{"a":{"c":2},"s":[{"n":"x"},{"n":"y"},{"m":"z"}],"l":[1,2,3]}`, 1},
+		{"a.*", `{"a":{},"s":[{"n":"x"},{"n":"y"},{"m":"z"}],"l":[1,2,3]}`, 2},
+		{"s[*].n", `{"a":{"b":1,"c":2},"s":[{},{},{"m":"z"}],"l":[1,2,3]}`, 2},
+		{"l[1]", `{"a":{"b":1,"c":2},"s":[{"n":"x"},{"n":"y"},{"m":"z"}],"l":[1,3]}`, 1},
+		{"l[*]", `{"a":{"b":1,"c":2},"s":[{"n":"x"},{"n":"y"},{"m":"z"}],"l":[]}`, 3},
+		{"/s/2", `{"a":{"b":1,"c":2},"s":[{"n":"x"},{"n":"y"}],"l":[1,2,3]}`, 1},
+		{"a.x", doc, 0},
+		{"l[3]", doc, 0},
+	}
+	for _, tt := range tests {
+		p, err := parsePath(tt.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, n := p.remove(decodeJSON(t, doc))
+		if n != tt.count {
+			t.Errorf("remove(%s) removed %d, want %d", tt.path, n, tt.count)
+		}
+		if want := decodeJSON(t, tt.want); !reflect.DeepEqual(got, want) {
+			t.Errorf("remove(%s) = %v, want %s", tt.path, got, tt.want)
+		}
+	}
+}
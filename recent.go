@@ -0,0 +1,414 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//***************************************************
+//* RECENT FEEDS
+//***************************************************
+
+// Recent feeds, each a list of the most recent states of one kind
+const (
+	// RECENTDEVICES holds asset states, one per asset
+	RECENTDEVICES string = "devices"
+	// RECENTACCOUNTS holds account states, one per account
+	RECENTACCOUNTS string = "accounts"
+	// RECENTISSUANCE holds the holdings changed by issueAsset and redeemAsset, one per holding
+	RECENTISSUANCE string = "issuance"
+	// RECENTTRANSFERS holds each transfer
+	RECENTTRANSFERS string = "transfers"
+)
+
+// RECENTFEEDKEYPREFIX prefixes the ledger key of each recent feed
+const RECENTFEEDKEYPREFIX string = "RecentStates~"
+
+// RECENTDEPTHSKEY holds the depths set by setRecentDepth
+const RECENTDEPTHSKEY string = "RecentDepths"
+
+// MAXRECENTDEPTH limits the depth of a feed, every push rewrites the whole feed
+const MAXRECENTDEPTH int = 500
+
+// RECENTTIMEFORMAT is the layout of the timestamp of a recent entry. It is fixed
+// width, unlike time.RFC3339Nano which drops trailing zeros, so that timestamps
+// of the same zone sort as strings in time order.
+const RECENTTIMEFORMAT string = "2006-01-02T15:04:05.000000000Z07:00"
+
+// RecentEntry is one state in a recent feed, ID is what makes a later state
+// replace it
+type RecentEntry struct {
+	ID        string          `json:"id,omitempty"`
+	Timestamp string          `json:"timestamp,omitempty"`
+	State     json.RawMessage `json:"state"`
+}
+
+// recentFeed names a feed and how to identify its states, a feed with no
+// identity keeps every state pushed to it
+type recentFeed struct {
+	name     string
+	identity func(state ArgsMap) string
+}
+
+var recentFeeds = []recentFeed{
+	{RECENTDEVICES, func(state ArgsMap) string {
+		return stateString(state, ASSETID) + "_" + stateString(state, ASSETTYPE)
+	}},
+	{RECENTACCOUNTS, func(state ArgsMap) string {
+		return stateString(state, ACCOUNTID)
+	}},
+	{RECENTISSUANCE, func(state ArgsMap) string {
+		return stateString(state, ACCOUNTID) + "_" + stateString(state, ASSETID)
+	}},
+	{RECENTTRANSFERS, nil},
+}
+
+func stateString(state ArgsMap, name string) string {
+	s, _ := state[name].(string)
+	return s
+}
+
+func getRecentFeed(name string) (recentFeed, error) {
+	for _, f := range recentFeeds {
+		if f.name == name {
+			return f, nil
+		}
+	}
+	return recentFeed{}, fmt.Errorf("%s is not a recent feed", name)
+}
+
+// getRecentDepths returns the depth of each feed, MaxRecentStates unless set
+func getRecentDepths(stub shim.ChaincodeStubInterface) map[string]int {
+	depths := make(map[string]int, len(recentFeeds))
+	for _, f := range recentFeeds {
+		depths[f.name] = MaxRecentStates
+	}
+	depthsBytes, err := stub.GetState(RECENTDEPTHSKEY)
+	if err != nil || len(depthsBytes) == 0 {
+		return depths
+	}
+	var set map[string]int
+	err = json.Unmarshal(depthsBytes, &set)
+	if err != nil {
+		log.Noticef("Unmarshal failed for recent depths: %s", err)
+		return depths
+	}
+	for name, depth := range set {
+		depths[name] = depth
+	}
+	return depths
+}
+
+// GETRecentFeedFromLedger returns the entries of a feed, most recent first
+func GETRecentFeedFromLedger(stub shim.ChaincodeStubInterface, name string) ([]RecentEntry, error) {
+	entries := []RecentEntry{}
+	feedBytes, err := stub.GetState(RECENTFEEDKEYPREFIX + name)
+	if err != nil {
+		return entries, fmt.Errorf("recent feed %s GETSTATE failed: %s", name, err)
+	}
+	if len(feedBytes) == 0 {
+		return entries, nil
+	}
+	err = json.Unmarshal(feedBytes, &entries)
+	if err != nil {
+		return []RecentEntry{}, fmt.Errorf("recent feed %s unmarshal failed: %s", name, err)
+	}
+	return entries, nil
+}
+
+// PUTRecentFeedToLedger marshals and writes the entries of a feed
+func PUTRecentFeedToLedger(stub shim.ChaincodeStubInterface, name string, entries []RecentEntry) error {
+	feedJSON, err := json.Marshal(entries)
+	if err != nil {
+		return fmt.Errorf("recent feed %s marshal failed: %s", name, err)
+	}
+	err = stub.PutState(RECENTFEEDKEYPREFIX+name, feedJSON)
+	if err != nil {
+		return fmt.Errorf("recent feed %s PUTSTATE failed: %s", name, err)
+	}
+	return nil
+}
+
+func clearRecentStates(stub shim.ChaincodeStubInterface, name string) error {
+	return PUTRecentFeedToLedger(stub, name, []RecentEntry{})
+}
+
+// pushRecentState puts a state at the top of a feed, replacing the state with
+// the same identity and dropping the oldest once the feed is at its depth
+func pushRecentState(stub shim.ChaincodeStubInterface, name string, state string) error {
+	f, err := getRecentFeed(name)
+	if err != nil {
+		return err
+	}
+	entry := RecentEntry{
+		Timestamp: txTimestamp(stub).Format(RECENTTIMEFORMAT),
+		State:     json.RawMessage(state),
+	}
+	if f.identity != nil {
+		var stateMap ArgsMap
+		err = json.Unmarshal([]byte(state), &stateMap)
+		if err != nil {
+			return fmt.Errorf("recent feed %s state unmarshal failed: %s", name, err)
+		}
+		entry.ID = f.identity(stateMap)
+	}
+	return pushRecentEntry(stub, f, entry, getRecentDepths(stub)[name])
+}
+
+func pushRecentEntry(stub shim.ChaincodeStubInterface, f recentFeed, entry RecentEntry, depth int) error {
+	entries, err := GETRecentFeedFromLedger(stub, f.name)
+	if err != nil {
+		return err
+	}
+	feed := make([]RecentEntry, 0, depth)
+	feed = append(feed, entry)
+	for _, e := range entries {
+		if len(feed) >= depth {
+			break
+		}
+		if f.identity != nil && e.ID == entry.ID {
+			// a state can appear only once
+			continue
+		}
+		feed = append(feed, e)
+	}
+	return PUTRecentFeedToLedger(stub, f.name, feed)
+}
+
+// typically called when an asset is deleted
+func removeAssetFromRecentState(stub shim.ChaincodeStubInterface, sAssetKey string) error {
+	entries, err := GETRecentFeedFromLedger(stub, RECENTDEVICES)
+	if err != nil {
+		return err
+	}
+	feed := make([]RecentEntry, 0, len(entries))
+	for _, e := range entries {
+		if e.ID != sAssetKey {
+			feed = append(feed, e)
+		}
+	}
+	if len(feed) == len(entries) {
+		// nothing to do
+		return nil
+	}
+	return PUTRecentFeedToLedger(stub, RECENTDEVICES, feed)
+}
+
+// recentDepth is the argument to setRecentDepth
+type recentDepth struct {
+	Category string `json:"category"`
+	Depth    int    `json:"depth"`
+}
+
+// ************************************
+// setRecentDepth
+// ************************************
+func (t *SimpleChaincode) setRecentDepth(stub shim.ChaincodeStubInterface, args []string) error {
+	var arg recentDepth
+	var err error
+
+	err = json.Unmarshal([]byte(args[0]), &arg)
+	if err != nil {
+		err = fmt.Errorf("setRecentDepth failed to unmarshal arg: %s", err)
+		log.Error(err)
+		return err
+	}
+	_, err = getRecentFeed(arg.Category)
+	if err != nil {
+		err = fmt.Errorf("setRecentDepth %s", err)
+		log.Error(err)
+		return err
+	}
+	if arg.Depth < 1 || arg.Depth > MAXRECENTDEPTH {
+		err = fmt.Errorf("setRecentDepth depth must be between 1 and %d", MAXRECENTDEPTH)
+		log.Error(err)
+		return err
+	}
+	depths := getRecentDepths(stub)
+	depths[arg.Category] = arg.Depth
+	depthsJSON, err := json.Marshal(depths)
+	if err != nil {
+		err = fmt.Errorf("setRecentDepth failed to marshal depths: %s", err)
+		log.Error(err)
+		return err
+	}
+	err = stub.PutState(RECENTDEPTHSKEY, depthsJSON)
+	if err != nil {
+		err = fmt.Errorf("setRecentDepth PUTSTATE failed: %s", err)
+		log.Error(err)
+		return err
+	}
+
+	// a shallower feed is cut now rather than at its next push
+	entries, err := GETRecentFeedFromLedger(stub, arg.Category)
+	if err == nil && len(entries) > arg.Depth {
+		err = PUTRecentFeedToLedger(stub, arg.Category, entries[:arg.Depth])
+	}
+	if err != nil {
+		err = fmt.Errorf("setRecentDepth %s", err)
+		log.Error(err)
+		return err
+	}
+	return nil
+}
+
+// recentQuery is the optional argument to readRecentStates. Without a category
+// every feed is read, most recent first. Only asset states have an assettype.
+type recentQuery struct {
+	Category  string `json:"category"`
+	AssetType string `json:"assettype"`
+	Limit     int    `json:"limit"`
+}
+
+// byNewest orders entries most recent first. Times are compared rather than
+// strings, which also orders timestamps written in another zone or layout.
+type byNewest []RecentEntry
+
+func (e byNewest) Len() int           { return len(e) }
+func (e byNewest) Swap(i, j int)      { e[i], e[j] = e[j], e[i] }
+func (e byNewest) Less(i, j int) bool { return e[i].time().After(e[j].time()) }
+
+// time parses the timestamp of an entry, an entry without one is the oldest
+func (e RecentEntry) time() time.Time {
+	t, err := time.Parse(time.RFC3339Nano, e.Timestamp)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+func readRecentStates(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var q recentQuery
+	var err error
+
+	if len(args) == 1 {
+		err = json.Unmarshal([]byte(args[0]), &q)
+		if err != nil {
+			err = fmt.Errorf("readRecentStates failed to unmarshal arg: %s", err)
+			log.Error(err)
+			return nil, err
+		}
+	}
+	if q.Limit < 0 {
+		err = errors.New("readRecentStates limit cannot be negative")
+		log.Error(err)
+		return nil, err
+	}
+	names := make([]string, 0, len(recentFeeds))
+	if q.Category != "" {
+		_, err = getRecentFeed(q.Category)
+		if err != nil {
+			err = fmt.Errorf("readRecentStates %s", err)
+			log.Error(err)
+			return nil, err
+		}
+		names = append(names, q.Category)
+	} else {
+		for _, f := range recentFeeds {
+			names = append(names, f.name)
+		}
+	}
+
+	entries := []RecentEntry{}
+	for _, name := range names {
+		feed, err := GETRecentFeedFromLedger(stub, name)
+		if err != nil {
+			err = fmt.Errorf("readRecentStates %s", err)
+			log.Error(err)
+			return nil, err
+		}
+		entries = append(entries, feed...)
+	}
+	if len(names) > 1 {
+		sort.Stable(byNewest(entries))
+	}
+
+	limit := q.Limit
+	if limit == 0 {
+		limit = MaxRecentStates
+		if q.Category != "" {
+			limit = getRecentDepths(stub)[q.Category]
+		}
+	}
+	rstateOut := make([]interface{}, 0, limit)
+	for _, e := range entries {
+		if len(rstateOut) >= limit {
+			break
+		}
+		var state ArgsMap
+		err = json.Unmarshal(e.State, &state)
+		if err != nil {
+			log.Errorf("readRecentStates JSON unmarshal of entry %s failed [%s]", e.ID, string(e.State))
+			return nil, err
+		}
+		if q.AssetType != "" && stateString(state, ASSETTYPE) != q.AssetType {
+			continue
+		}
+		rstateOut = append(rstateOut, state)
+	}
+	rsBytes, err := json.Marshal(rstateOut)
+	if err != nil {
+		log.Errorf("readRecentStates JSON marshal of result failed: %s", err)
+		return nil, err
+	}
+	return rsBytes, nil
+}
+
+func init() {
+	registerMigration(Migration{Name: "recentFeeds", From: "1.1", To: "1.2",
+		run: migrateRecentFeeds})
+}
+
+// migrateRecentFeeds splits the single recent states list into the feeds. An
+// entry with an accountID and an assetID is a holding. The list kept no times,
+// so its entries are stamped with the time of the migration a nanosecond apart,
+// which keeps them in their order when the feeds are read together.
+func migrateRecentFeeds(stub shim.ChaincodeStubInterface, state *ContractState, record *MigrationRecord) error {
+	legacyBytes, err := stub.GetState(RECENTSTATESKEY)
+	if err != nil {
+		return err
+	}
+	if len(legacyBytes) == 0 {
+		return nil
+	}
+	var legacy []string
+	err = json.Unmarshal(legacyBytes, &legacy)
+	if err != nil {
+		log.Noticef("Unmarshal failed for recent states, dropping them: %s", err)
+		legacy = nil
+	}
+	depths := getRecentDepths(stub)
+	migratedAt := txTimestamp(stub)
+	// oldest first, so that each feed ends up most recent first
+	for i := len(legacy) - 1; i >= 0; i-- {
+		var stateMap ArgsMap
+		err = json.Unmarshal([]byte(legacy[i]), &stateMap)
+		if err != nil {
+			log.Noticef("recent state %d is not a JSON object, dropping it: %s", i, err)
+			continue
+		}
+		name := RECENTDEVICES
+		if stateString(stateMap, ACCOUNTID) != "" {
+			name = RECENTACCOUNTS
+			if stateString(stateMap, ASSETID) != "" {
+				name = RECENTISSUANCE
+			}
+		}
+		f, _ := getRecentFeed(name)
+		entry := RecentEntry{
+			ID:        f.identity(stateMap),
+			Timestamp: migratedAt.Add(-time.Duration(i)).Format(RECENTTIMEFORMAT),
+			State:     json.RawMessage(legacy[i]),
+		}
+		err = pushRecentEntry(stub, f, entry, depths[name])
+		if err != nil {
+			return err
+		}
+	}
+	return stub.DelState(RECENTSTATESKEY)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// recentIDs reads recent states and returns the assetID, or else the
+// accountID, of each in order
+func (s *memStub) recentIDs(arg string) []string {
+	s.t.Helper()
+	states, _ := s.mustRead("readRecentStates", arg).([]interface{})
+	ids := []string{}
+	for _, state := range states {
+		m := state.(map[string]interface{})
+		id, _ := m[ASSETID].(string)
+		if id == "" {
+			id, _ = m[ACCOUNTID].(string)
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func TestRecentFeeds(t *testing.T) {
+	s := withHoldings(t)
+	s.as("tech")
+	for _, id := range []string{"m1", "m2", "m3"} {
+		s.mustInvoke("createAsset", `{"assetID":"`+id+`","rpm":900,"max_rpm":1000}`)
+	}
+	s.mustInvoke("updateAsset", `{"assetID":"m1","rpm":800}`)
+	s.mustInvoke("transferAsset", `{"accountID":"alice","accountIDTo":"bob","assetID":"USD","amount":"1"}`)
+	s.mustInvoke("transferAsset", `{"accountID":"alice","accountIDTo":"bob","assetID":"USD","amount":"2"}`)
+
+	tests := []struct {
+		arg  string
+		want []string
+	}{
+		// an asset is in its feed once, at its latest state
+		{`{"category":"devices"}`, []string{"m1", "m3", "m2"}},
+		{`{"category":"accounts"}`, []string{"bob", "alice", "bank"}},
+		{`{"category":"issuance"}`, []string{"USD"}},
+		// every transfer is kept
+		{`{"category":"transfers"}`, []string{"USD", "USD"}},
+		{`{"category":"devices","limit":1}`, []string{"m1"}},
+		{`{"category":"devices","assettype":"smartplug"}`, []string{}},
+		// all feeds together, most recent first
+		{`{"limit":4}`, []string{"USD", "USD", "m1", "m3"}},
+	}
+	for _, tt := range tests {
+		if got := s.recentIDs(tt.arg); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("readRecentStates %s = %q, want %q", tt.arg, got, tt.want)
+		}
+	}
+
+	s.mustFailInvoke("setRecentDepth", `{"category":"devices","depth":2}`)
+	s.asAdmin()
+	s.mustFailInvoke("setRecentDepth", `{"category":"devices","depth":0}`)
+	s.mustFailInvoke("setRecentDepth", `{"category":"gadgets","depth":2}`)
+	s.mustInvoke("setRecentDepth", `{"category":"devices","depth":2}`)
+	if got := s.recentIDs(`{"category":"devices"}`); !reflect.DeepEqual(got, []string{"m1", "m3"}) {
+		t.Errorf("devices are %q after the depth was set to 2, want m1 m3", got)
+	}
+	s.as("tech").mustInvoke("createAsset", `{"assetID":"m4","rpm":900,"max_rpm":1000}`)
+	if got := s.recentIDs(`{"category":"devices"}`); !reflect.DeepEqual(got, []string{"m4", "m1"}) {
+		t.Errorf("devices are %q after m4 was created, want m4 m1", got)
+	}
+	// the other feeds keep their depth
+	if got := s.recentIDs(`{"category":"accounts"}`); len(got) != 3 {
+		t.Errorf("accounts are %q, want all three", got)
+	}
+
+	s.mustInvoke("deleteAsset", `{"assetID":"m4"}`)
+	if got := s.recentIDs(`{"category":"devices"}`); !reflect.DeepEqual(got, []string{"m1"}) {
+		t.Errorf("devices are %q after m4 was deleted, want m1", got)
+	}
+}
+
+// TestLegacyRecentStates upgrades a 1.1 ledger, whose recent states of every
+// kind are in one list, most recent first
+func TestLegacyRecentStates(t *testing.T) {
+	s := newMemStub(t)
+	var state ContractState
+	err := json.Unmarshal(s.state[CONTRACTSTATEKEY], &state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.Version = "1.1"
+	s.state[CONTRACTSTATEKEY], _ = json.Marshal(state)
+	s.state[RECENTSTATESKEY] = []byte(`[
+		"{\"assetID\":\"m2\",\"assettype\":\"motor\"}",
+		"{\"accountID\":\"carol\",\"assetID\":\"USD\",\"amount\":\"1\"}",
+		"{\"accountID\":\"carol\"}",
+		"not a state",
+		"{\"assetID\":\"m1\",\"assettype\":\"motor\"}"]`)
+	s.mustInit()
+
+	if s.state[RECENTSTATESKEY] != nil {
+		t.Error("the legacy recent states are still on the ledger")
+	}
+	tests := []struct {
+		arg  string
+		want []string
+	}{
+		{`{"category":"devices"}`, []string{"m2", "m1"}},
+		{`{"category":"accounts"}`, []string{"carol"}},
+		{`{"category":"issuance"}`, []string{"USD"}},
+		// the feeds keep the order of the list
+		{``, []string{"m2", "USD", "carol", "m1"}},
+	}
+	for _, tt := range tests {
+		if got := s.recentIDs(tt.arg); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("readRecentStates %s = %q, want %q", tt.arg, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,600 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//***************************************************
+//* FUNCTION REGISTRY
+//***************************************************
+
+// Function modes, a function can only be called through its own entry point
+const (
+	// INVOKEMODE functions change state and are called through Invoke
+	INVOKEMODE string = "invoke"
+	// QUERYMODE functions read state and are called through Query
+	QUERYMODE string = "query"
+)
+
+// FunctionHandler is the signature shared by every contract function
+type FunctionHandler func(t *SimpleChaincode, stub shim.ChaincodeStubInterface, args []string) ([]byte, error)
+
+// ContractFunction describes one function of the contract API. MinArgs and
+// MaxArgs are checked before the handler runs, ArgSchema is a JSON schema for
+// the first argument, which is validated against it before the handler runs
+// and is published by listFunctions for client SDKs. An AdminOnly function
+// changes the configuration of the contract and needs the admin role.
+type ContractFunction struct {
+	Name        string      `json:"name"`
+	Mode        string      `json:"mode"`
+	MinArgs     int         `json:"minArgs"`
+	MaxArgs     int         `json:"maxArgs"`
+	AdminOnly   bool        `json:"adminOnly,omitempty"`
+	Description string      `json:"description"`
+	ArgSchema   interface{} `json:"argSchema,omitempty"`
+	handler     FunctionHandler
+	argSchema   *Schema
+}
+
+// contractFunctions maps function name to its registration, filled by init so
+// that listFunctions can refer to the registry
+var contractFunctions = map[string]ContractFunction{}
+
+// registerFunction adds a function to the registry, a duplicate name or an
+// ArgSchema that cannot be validated against is a programming error
+func registerFunction(f ContractFunction) {
+	if _, found := contractFunctions[f.Name]; found {
+		panic("function " + f.Name + " is registered twice")
+	}
+	if f.ArgSchema != nil {
+		schemaBytes, err := json.Marshal(f.ArgSchema)
+		if err == nil {
+			err = json.Unmarshal(schemaBytes, &f.argSchema)
+		}
+		if err == nil {
+			err = f.argSchema.check(f.Name)
+		}
+		if err != nil {
+			panic(fmt.Sprintf("function %s has a bad argSchema: %s", f.Name, err))
+		}
+	}
+	contractFunctions[f.Name] = f
+}
+
+// schemaObject returns a JSON schema for an object argument
+func schemaObject(properties map[string]interface{}, required ...string) map[string]interface{} {
+	schema := map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+	}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// schemaType returns a JSON schema for a scalar of the given type
+func schemaType(jsonType string) map[string]interface{} {
+	return map[string]interface{}{"type": jsonType}
+}
+
+// schemaAmount accepts an amount as a decimal string or a number
+var schemaAmount = map[string]interface{}{"oneOf": []interface{}{schemaType("string"), schemaType("number")}}
+
+func init() {
+	// invoke
+	registerFunction(ContractFunction{Name: "createAsset", Mode: INVOKEMODE, MinArgs: 1, MaxArgs: 2,
+		Description: "create an asset from a partial state, its type is its assettype or is matched from its name",
+		ArgSchema: schemaObject(map[string]interface{}{
+			ASSETID:   schemaType("string"),
+			ASSETTYPE: schemaType("string"),
+			ASSETNAME: schemaType("string"),
+		}, ASSETID),
+		handler: (*SimpleChaincode).createAsset})
+	registerFunction(ContractFunction{Name: "updateAsset", Mode: INVOKEMODE, MinArgs: 1, MaxArgs: 1,
+		Description: "change an asset by merging a partial state, a merge patch, a replacement or JSON Patch operations",
+		ArgSchema: schemaObject(map[string]interface{}{
+			ASSETID:   schemaType("string"),
+			ASSETTYPE: schemaType("string"),
+			ASSETNAME: schemaType("string"),
+			MERGEMODE: map[string]interface{}{"type": "string", "enum": []string{MERGEDEEP, MERGEPATCH, MERGEREPLACE, MERGEJSONPATCH}},
+			ARRAYMODE: map[string]interface{}{"type": "string", "enum": []string{ARRAYREPLACE, ARRAYAPPEND, ARRAYUNION}},
+			PATCH: map[string]interface{}{"type": "array", "items": schemaObject(map[string]interface{}{
+				"op":    map[string]interface{}{"type": "string", "enum": []string{"add", "remove", "replace", "move", "copy", "test"}},
+				"path":  schemaType("string"),
+				"from":  schemaType("string"),
+				"value": map[string]interface{}{},
+			}, "op", "path")},
+		}, ASSETID),
+		handler: (*SimpleChaincode).updateAsset})
+	registerFunction(ContractFunction{Name: "deleteAsset", Mode: INVOKEMODE, MinArgs: 1, MaxArgs: 1,
+		Description: "delete an asset, it is kept with its history as a tombstone",
+		ArgSchema: schemaObject(map[string]interface{}{
+			ASSETID:   schemaType("string"),
+			ASSETTYPE: schemaType("string"),
+			ASSETNAME: schemaType("string"),
+			"reason":  schemaType("string"),
+			CALLER:    schemaType("string"),
+		}, ASSETID),
+		handler: (*SimpleChaincode).deleteAsset})
+	registerFunction(ContractFunction{Name: "deleteAllAssets", Mode: INVOKEMODE, MinArgs: 0, MaxArgs: 1,
+		Description: "delete every asset, each is kept with its history as a tombstone",
+		ArgSchema: schemaObject(map[string]interface{}{
+			"reason": schemaType("string"),
+			CALLER:   schemaType("string"),
+		}),
+		handler: (*SimpleChaincode).deleteAllAssets})
+	registerFunction(ContractFunction{Name: "deletePropertiesFromAsset", Mode: INVOKEMODE, MinArgs: 1, MaxArgs: 1,
+		Description: "remove the properties that paths such as common.location, sensors[3], channels.*.calibration or /a.b select from an asset",
+		ArgSchema: schemaObject(map[string]interface{}{
+			ASSETID:             schemaType("string"),
+			ASSETTYPE:           schemaType("string"),
+			ASSETNAME:           schemaType("string"),
+			"qualPropsToDelete": map[string]interface{}{"type": "array", "items": schemaType("string")},
+		}, ASSETID, "qualPropsToDelete"),
+		handler: (*SimpleChaincode).deletePropertiesFromAsset})
+	registerFunction(ContractFunction{Name: "setLoggingLevel", Mode: INVOKEMODE, MinArgs: 1, MaxArgs: 1, AdminOnly: true,
+		Description: "set the contract logging level",
+		ArgSchema: schemaObject(map[string]interface{}{
+			"logLevel": map[string]interface{}{"type": "string", "description": "one of " + strings.Join(logLevelNames, ", ") + " in any case"},
+		}, "logLevel"),
+		handler: func(t *SimpleChaincode, stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+			return nil, t.setLoggingLevel(stub, args)
+		}})
+	registerFunction(ContractFunction{Name: "setCreateOnUpdate", Mode: INVOKEMODE, MinArgs: 1, MaxArgs: 1, AdminOnly: true,
+		Description: "allow updateAsset to create assets that do not exist",
+		ArgSchema: schemaObject(map[string]interface{}{
+			"createOnUpdate": schemaType("boolean"),
+		}, "createOnUpdate"),
+		handler: func(t *SimpleChaincode, stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+			return nil, t.setCreateOnUpdate(stub, args)
+		}})
+	registerFunction(ContractFunction{Name: "setRecentDepth", Mode: INVOKEMODE, MinArgs: 1, MaxArgs: 1, AdminOnly: true,
+		Description: "set how many states a recent feed keeps",
+		ArgSchema: schemaObject(map[string]interface{}{
+			"category": recentCategorySchema(),
+			"depth":    map[string]interface{}{"type": "integer", "minimum": 1, "maximum": MAXRECENTDEPTH},
+		}, "category", "depth"),
+		handler: func(t *SimpleChaincode, stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+			return nil, t.setRecentDepth(stub, args)
+		}})
+	registerFunction(ContractFunction{Name: "defineAssetType", Mode: INVOKEMODE, MinArgs: 1, MaxArgs: 1, AdminOnly: true,
+		Description: "add or replace an asset type, its name rules, the rules run against its assets and the schema of their state",
+		ArgSchema: schemaObject(map[string]interface{}{
+			"name":         schemaType("string"),
+			"nameContains": map[string]interface{}{"type": "array", "items": schemaType("string")},
+			"rules":        map[string]interface{}{"type": "array", "items": schemaType("string")},
+			"default":      schemaType("boolean"),
+			"schema":       schemaType("object"),
+		}, "name"),
+		handler: (*SimpleChaincode).defineAssetType})
+	registerFunction(ContractFunction{Name: "defineRule", Mode: INVOKEMODE, MinArgs: 1, MaxArgs: 1, AdminOnly: true,
+		Description: "add or replace a rule that raises an alert while its condition holds",
+		ArgSchema: schemaObject(map[string]interface{}{
+			"name":        schemaType("string"),
+			"alert":       schemaType("string"),
+			"description": schemaType("string"),
+			"assetTypes":  map[string]interface{}{"type": "array", "items": schemaType("string")},
+			"condition":   schemaType("object"),
+			"severity":    map[string]interface{}{"type": "string", "enum": []string{SEVERITYINFO, SEVERITYWARNING, SEVERITYCRITICAL}},
+		}, "name", "condition", "severity"),
+		handler: (*SimpleChaincode).defineRule})
+	registerFunction(ContractFunction{Name: "disableRule", Mode: INVOKEMODE, MinArgs: 1, MaxArgs: 1, AdminOnly: true,
+		Description: "stop a rule from running, its alerts clear on the next update",
+		ArgSchema: schemaObject(map[string]interface{}{
+			"name": schemaType("string"),
+		}, "name"),
+		handler: (*SimpleChaincode).disableRule})
+	registerFunction(ContractFunction{Name: "setRuleThresholds", Mode: INVOKEMODE, MinArgs: 1, MaxArgs: 1, AdminOnly: true,
+		Description: "override rule thresholds for an asset type or a single asset of a type, null removes an override",
+		ArgSchema: schemaObject(map[string]interface{}{
+			ASSETTYPE: schemaType("string"),
+			ASSETID:   schemaType("string"),
+			"thresholds": map[string]interface{}{"type": "object", "additionalProperties": map[string]interface{}{
+				"oneOf": []interface{}{schemaType("number"), schemaType("null")}}},
+		}, "thresholds"),
+		handler: (*SimpleChaincode).setRuleThresholds})
+	registerFunction(ContractFunction{Name: "acknowledgeAlert", Mode: INVOKEMODE, MinArgs: 1, MaxArgs: 1,
+		Description: "record who has seen the current incident of an alert on an asset",
+		ArgSchema: schemaObject(map[string]interface{}{
+			ASSETID:   schemaType("string"),
+			ASSETTYPE: schemaType("string"),
+			ASSETNAME: schemaType("string"),
+			"alert":   schemaType("string"),
+			CALLER:    schemaType("string"),
+		}, ASSETID, "alert"),
+		handler: (*SimpleChaincode).acknowledgeAlert})
+	registerFunction(ContractFunction{Name: "createAccount", Mode: INVOKEMODE, MinArgs: 1, MaxArgs: 2,
+		Description: "register an account, unknown properties are kept as metadata",
+		ArgSchema: schemaObject(map[string]interface{}{
+			ACCOUNTID:   schemaType("string"),
+			ACCOUNTNAME: schemaType("string"),
+			ACCOUNTTYPE: schemaType("string"),
+		}, ACCOUNTID, ACCOUNTNAME),
+		handler: (*SimpleChaincode).createAccount})
+	registerFunction(ContractFunction{Name: "freezeAccount", Mode: INVOKEMODE, MinArgs: 1, MaxArgs: 1,
+		Description: "lock an active account",
+		ArgSchema:   accountStatusChangeSchema(),
+		handler:     (*SimpleChaincode).freezeAccount})
+	registerFunction(ContractFunction{Name: "unfreezeAccount", Mode: INVOKEMODE, MinArgs: 1, MaxArgs: 1,
+		Description: "reactivate a frozen account, only an admin can",
+		ArgSchema:   accountStatusChangeSchema(),
+		handler:     (*SimpleChaincode).unfreezeAccount})
+	registerFunction(ContractFunction{Name: "closeAccount", Mode: INVOKEMODE, MinArgs: 1, MaxArgs: 1,
+		Description: "retire an account that holds nothing, only an admin can retire a frozen account",
+		ArgSchema:   accountStatusChangeSchema(),
+		handler:     (*SimpleChaincode).closeAccount})
+	registerFunction(ContractFunction{Name: "defineAsset", Mode: INVOKEMODE, MinArgs: 1, MaxArgs: 1,
+		Description: "define an asset class, only its issuer may issue it",
+		ArgSchema: schemaObject(map[string]interface{}{
+			ASSETID:     schemaType("string"),
+			"symbol":    schemaType("string"),
+			"decimals":  map[string]interface{}{"type": "integer", "minimum": 0, "maximum": MAXASSETSCALE},
+			"issuer":    schemaType("string"),
+			"maxSupply": schemaAmount,
+			CALLER:      schemaType("string"),
+		}, ASSETID, "symbol", "issuer"),
+		handler: (*SimpleChaincode).defineAsset})
+	registerFunction(ContractFunction{Name: "setAssetScale", Mode: INVOKEMODE, MinArgs: 1, MaxArgs: 1, AdminOnly: true,
+		Description: "set the decimal places of an asset that is neither defined nor held",
+		ArgSchema: schemaObject(map[string]interface{}{
+			ASSETID: schemaType("string"),
+			"scale": map[string]interface{}{"type": "integer", "minimum": 0, "maximum": MAXASSETSCALE},
+		}, ASSETID, "scale"),
+		handler: func(t *SimpleChaincode, stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+			return nil, t.setAssetScale(stub, args)
+		}})
+	registerFunction(ContractFunction{Name: "issueAsset", Mode: INVOKEMODE, MinArgs: 1, MaxArgs: 1,
+		Description: "issue new units of a defined asset to an account",
+		ArgSchema:   holdingChangeSchema(),
+		handler:     (*SimpleChaincode).issueAsset})
+	registerFunction(ContractFunction{Name: "redeemAsset", Mode: INVOKEMODE, MinArgs: 1, MaxArgs: 1,
+		Description: "retire units of a defined asset from the caller's own account, or from any account in good standing as an admin",
+		ArgSchema:   holdingChangeSchema(),
+		handler:     (*SimpleChaincode).redeemAsset})
+	registerFunction(ContractFunction{Name: "transferAsset", Mode: INVOKEMODE, MinArgs: 1, MaxArgs: 1,
+		Description: "move units of an asset between two accounts",
+		ArgSchema: schemaObject(map[string]interface{}{
+			ACCOUNTID:   schemaType("string"),
+			ACCOUNTIDTO: schemaType("string"),
+			ASSETID:     schemaType("string"),
+			AMOUNT:      schemaAmount,
+		}, ACCOUNTID, ACCOUNTIDTO, ASSETID, AMOUNT),
+		handler: (*SimpleChaincode).transferAsset})
+
+	// query
+	registerFunction(ContractFunction{Name: "readAsset", Mode: QUERYMODE, MinArgs: 1, MaxArgs: 1,
+		Description: "read the state of an asset",
+		ArgSchema: schemaObject(map[string]interface{}{
+			ASSETID:   schemaType("string"),
+			ASSETTYPE: schemaType("string"),
+			ASSETNAME: schemaType("string"),
+		}, ASSETID),
+		handler: (*SimpleChaincode).readAsset})
+	registerFunction(ContractFunction{Name: "readAllAssets", Mode: QUERYMODE, MinArgs: 0, MaxArgs: 1,
+		Description: "read the state of every asset, deleted assets only with includeDeleted",
+		ArgSchema:   schemaObject(map[string]interface{}{"includeDeleted": schemaType("boolean")}),
+		handler:     (*SimpleChaincode).readAllAssets})
+	registerFunction(ContractFunction{Name: "readDeletedAssets", Mode: QUERYMODE, MinArgs: 0, MaxArgs: 0,
+		Description: "read the tombstones of deleted assets, with when, by whom and why they were deleted",
+		handler:     (*SimpleChaincode).readDeletedAssets})
+	registerFunction(ContractFunction{Name: "readAssetHistory", Mode: QUERYMODE, MinArgs: 1, MaxArgs: 1,
+		Description: "read the state history of an asset, most recent first, or a page of versions when any of from, to, cursor, order or pageSize is given",
+		ArgSchema: schemaObject(map[string]interface{}{
+			ASSETID:    schemaType("string"),
+			ASSETTYPE:  schemaType("string"),
+			ASSETNAME:  schemaType("string"),
+			"count":    schemaType("number"),
+			"from":     map[string]interface{}{"type": "string", "format": "date-time"},
+			"to":       map[string]interface{}{"type": "string", "format": "date-time"},
+			"cursor":   schemaType("string"),
+			"order":    map[string]interface{}{"type": "string", "enum": []string{"asc", "desc"}},
+			"pageSize": map[string]interface{}{"type": "integer", "minimum": 1, "maximum": MAXHISTORYPAGESIZE},
+		}, ASSETID),
+		handler: (*SimpleChaincode).readAssetHistory})
+	registerFunction(ContractFunction{Name: "readAssetAsOf", Mode: QUERYMODE, MinArgs: 1, MaxArgs: 1,
+		Description: "read the version of an asset that was current at a time, or the version of a sequence number",
+		ArgSchema: schemaObject(map[string]interface{}{
+			ASSETID:    schemaType("string"),
+			ASSETTYPE:  schemaType("string"),
+			ASSETNAME:  schemaType("string"),
+			"asOf":     map[string]interface{}{"type": "string", "format": "date-time"},
+			"sequence": map[string]interface{}{"type": "integer", "minimum": 1},
+		}, ASSETID),
+		handler: (*SimpleChaincode).readAssetAsOf})
+	registerFunction(ContractFunction{Name: "diffAssetVersions", Mode: QUERYMODE, MinArgs: 1, MaxArgs: 1,
+		Description: "compute the RFC 6902 JSON Patch between two versions of an asset, to defaults to the current version",
+		ArgSchema: schemaObject(map[string]interface{}{
+			ASSETID:   schemaType("string"),
+			ASSETTYPE: schemaType("string"),
+			ASSETNAME: schemaType("string"),
+			"from":    versionSchema(),
+			"to":      versionSchema(),
+		}, ASSETID, "from"),
+		handler: (*SimpleChaincode).diffAssetVersions})
+	registerFunction(ContractFunction{Name: "readRecentStates", Mode: QUERYMODE, MinArgs: 0, MaxArgs: 1,
+		Description: "read the most recently changed states of one recent feed or of all of them",
+		ArgSchema: schemaObject(map[string]interface{}{
+			"category": recentCategorySchema(),
+			ASSETTYPE:  schemaType("string"),
+			"limit":    map[string]interface{}{"type": "integer", "minimum": 0},
+		}),
+		handler: func(t *SimpleChaincode, stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+			return readRecentStates(stub, args)
+		}})
+	registerFunction(ContractFunction{Name: "readContractState", Mode: QUERYMODE, MinArgs: 0, MaxArgs: 0,
+		Description: "read the contract version, nickname and indexes",
+		handler:     (*SimpleChaincode).readContractState})
+	registerFunction(ContractFunction{Name: "readContractObjectModel", Mode: QUERYMODE, MinArgs: 0, MaxArgs: 0,
+		Description: "read the state schema of each asset type",
+		handler:     (*SimpleChaincode).readContractObjectModel})
+	registerFunction(ContractFunction{Name: "readAssetTypes", Mode: QUERYMODE, MinArgs: 0, MaxArgs: 0,
+		Description: "read the asset type registry",
+		handler:     (*SimpleChaincode).readAssetTypes})
+	registerFunction(ContractFunction{Name: "readRules", Mode: QUERYMODE, MinArgs: 0, MaxArgs: 0,
+		Description: "read the rules",
+		handler:     (*SimpleChaincode).readRules})
+	registerFunction(ContractFunction{Name: "readRuleThresholds", Mode: QUERYMODE, MinArgs: 1, MaxArgs: 1,
+		Description: "read the threshold overrides of an asset type or a single asset of a type",
+		ArgSchema: schemaObject(map[string]interface{}{
+			ASSETTYPE: schemaType("string"),
+			ASSETID:   schemaType("string"),
+		}),
+		handler: (*SimpleChaincode).readRuleThresholds})
+	registerFunction(ContractFunction{Name: "readActiveAlerts", Mode: QUERYMODE, MinArgs: 0, MaxArgs: 0,
+		Description: "read every active alert of every asset with its incident record",
+		handler:     (*SimpleChaincode).readActiveAlerts})
+	registerFunction(ContractFunction{Name: "readComplianceReport", Mode: QUERYMODE, MinArgs: 0, MaxArgs: 1,
+		Description: "summarise the compliance of the assets of each type with the noncompliant assets and for how long",
+		ArgSchema:   schemaObject(map[string]interface{}{ASSETTYPE: schemaType("string")}),
+		handler:     (*SimpleChaincode).readComplianceReport})
+	registerFunction(ContractFunction{Name: "readAccount", Mode: QUERYMODE, MinArgs: 1, MaxArgs: 1,
+		Description: "read a registered account",
+		ArgSchema: schemaObject(map[string]interface{}{
+			ACCOUNTID: schemaType("string"),
+		}, ACCOUNTID),
+		handler: (*SimpleChaincode).readAccount})
+	registerFunction(ContractFunction{Name: "readAllAccounts", Mode: QUERYMODE, MinArgs: 0, MaxArgs: 0,
+		Description: "read every registered account",
+		handler:     (*SimpleChaincode).readAllAccounts})
+	registerFunction(ContractFunction{Name: "readAllIssue", Mode: QUERYMODE, MinArgs: 0, MaxArgs: 0,
+		Description: "read every holding",
+		handler:     (*SimpleChaincode).readAllIssue})
+	registerFunction(ContractFunction{Name: "readAssetDefinition", Mode: QUERYMODE, MinArgs: 1, MaxArgs: 1,
+		Description: "read the definition and supply of an asset",
+		ArgSchema: schemaObject(map[string]interface{}{
+			ASSETID: schemaType("string"),
+		}, ASSETID),
+		handler: (*SimpleChaincode).readAssetDefinition})
+	registerFunction(ContractFunction{Name: "readBalance", Mode: QUERYMODE, MinArgs: 1, MaxArgs: 1,
+		Description: "read one account's balance of one asset",
+		ArgSchema: schemaObject(map[string]interface{}{
+			ACCOUNTID: schemaType("string"),
+			ASSETID:   schemaType("string"),
+		}, ACCOUNTID, ASSETID),
+		handler: (*SimpleChaincode).readBalance})
+	registerFunction(ContractFunction{Name: "readPortfolio", Mode: QUERYMODE, MinArgs: 1, MaxArgs: 1,
+		Description: "read every asset held by an account",
+		ArgSchema: schemaObject(map[string]interface{}{
+			ACCOUNTID: schemaType("string"),
+		}, ACCOUNTID),
+		handler: (*SimpleChaincode).readPortfolio})
+	registerFunction(ContractFunction{Name: "readHolders", Mode: QUERYMODE, MinArgs: 1, MaxArgs: 1,
+		Description: "read every holder of an asset with their share of the supply",
+		ArgSchema: schemaObject(map[string]interface{}{
+			ASSETID: schemaType("string"),
+		}, ASSETID),
+		handler: (*SimpleChaincode).readHolders})
+	registerFunction(ContractFunction{Name: "listFunctions", Mode: QUERYMODE, MinArgs: 0, MaxArgs: 0,
+		Description: "read this registry",
+		handler:     (*SimpleChaincode).listFunctions})
+}
+
+func accountStatusChangeSchema() map[string]interface{} {
+	return schemaObject(map[string]interface{}{
+		ACCOUNTID: schemaType("string"),
+		"reason":  schemaType("string"),
+		CALLER:    schemaType("string"),
+	}, ACCOUNTID, "reason")
+}
+
+// versionSchema selects an asset version by sequence number or time
+func versionSchema() map[string]interface{} {
+	return map[string]interface{}{"oneOf": []interface{}{
+		map[string]interface{}{"type": "integer", "minimum": 1},
+		map[string]interface{}{"type": "string", "format": "date-time"},
+	}}
+}
+
+func recentCategorySchema() map[string]interface{} {
+	categories := make([]interface{}, len(recentFeeds))
+	for i, f := range recentFeeds {
+		categories[i] = f.name
+	}
+	return map[string]interface{}{"type": "string", "enum": categories}
+}
+
+func holdingChangeSchema() map[string]interface{} {
+	return schemaObject(map[string]interface{}{
+		ACCOUNTID: schemaType("string"),
+		ASSETID:   schemaType("string"),
+		AMOUNT:    schemaAmount,
+		CALLER:    schemaType("string"),
+	}, ACCOUNTID, ASSETID, AMOUNT)
+}
+
+// dispatch finds a function in the registry, checks that it is called in its
+// own mode with an acceptable number of arguments, and runs it
+func (t *SimpleChaincode) dispatch(stub shim.ChaincodeStubInterface, mode string, function string, args []string) ([]byte, error) {
+	var err error
+	entry := "Invoke"
+	if mode == QUERYMODE {
+		entry = "Query"
+	}
+
+	f, found := contractFunctions[function]
+	if !found {
+		err = fmt.Errorf("%s received unknown invocation: %s", entry, function)
+		if suggestion := closestFunctionName(function, mode); suggestion != "" {
+			err = fmt.Errorf("%s received unknown invocation: %s, did you mean %s?", entry, function, suggestion)
+		}
+		log.Warning(err)
+		return nil, err
+	}
+	if f.Mode != mode {
+		err = fmt.Errorf("%s received %s function %s, it must be called in %s mode", entry, f.Mode, function, f.Mode)
+		log.Warning(err)
+		return nil, err
+	}
+	if f.AdminOnly && !callerHasRole(stub, ADMINROLE) {
+		err = fmt.Errorf("%s can only be called with the %s role", function, ADMINROLE)
+		log.Error(err)
+		return nil, err
+	}
+	if len(args) < f.MinArgs || len(args) > f.MaxArgs {
+		if f.MinArgs == f.MaxArgs {
+			err = fmt.Errorf("%s expects %d argument(s), received %d", function, f.MinArgs, len(args))
+		} else {
+			err = fmt.Errorf("%s expects %d to %d arguments, received %d", function, f.MinArgs, f.MaxArgs, len(args))
+		}
+		log.Error(err)
+		return nil, err
+	}
+	err = f.validateArg(args)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	if mode == QUERYMODE {
+		return f.handler(t, stub, args)
+	}
+
+	// an invoke stamps what it writes with the transaction's time, a local
+	// clock would give each peer a different state
+	_, err = getTxTimestamp(stub)
+	if err != nil {
+		err = fmt.Errorf("%s %s", function, err)
+		log.Error(err)
+		return nil, err
+	}
+
+	// events of an invoke are only sent when all of it succeeds
+	beginEvents(stub, function)
+	defer discardEvents(stub)
+	result, err := f.handler(t, stub, args)
+	if err != nil {
+		return nil, err
+	}
+	err = flushEvents(stub)
+	if err != nil {
+		err = fmt.Errorf("%s %s", function, err)
+		log.Error(err)
+		return nil, err
+	}
+	return result, nil
+}
+
+// validateArg checks the first argument against the function's ArgSchema
+func (f *ContractFunction) validateArg(args []string) error {
+	if f.argSchema == nil || len(args) == 0 {
+		return nil
+	}
+	var arg interface{}
+	decoder := json.NewDecoder(strings.NewReader(args[0]))
+	decoder.UseNumber()
+	err := decoder.Decode(&arg)
+	if err != nil {
+		return fmt.Errorf("%s arg is not JSON: %s", f.Name, err)
+	}
+	fieldErrors := f.argSchema.validate("", arg)
+	if len(fieldErrors) == 0 {
+		return nil
+	}
+	msgs := make([]string, 0, len(fieldErrors))
+	for _, fe := range fieldErrors {
+		msgs = append(msgs, fe.Field+": "+fe.Message)
+	}
+	return fmt.Errorf("%s arg violates its schema: %s", f.Name, strings.Join(msgs, "; "))
+}
+
+// closestFunctionName returns the registered name nearest to an unknown one,
+// preferring functions of the mode it was called in, or "" if nothing is close
+func closestFunctionName(function string, mode string) string {
+	best := ""
+	bestDistance := 0
+	for _, name := range sortedFunctionNames() {
+		d := editDistance(function, name)
+		if contractFunctions[name].Mode != mode {
+			d++
+		}
+		if best == "" || d < bestDistance {
+			best, bestDistance = name, d
+		}
+	}
+	// a guess that needs more edits than half the name is noise
+	if best == "" || bestDistance > (len(best)+1)/2 {
+		return ""
+	}
+	return best
+}
+
+// editDistance is the Levenshtein distance between two names, ignoring case
+func editDistance(a string, b string) int {
+	ra := []rune(strings.ToLower(a))
+	rb := []rune(strings.ToLower(b))
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(minInt(prev[j]+1, cur[j-1]+1), prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func sortedFunctionNames() []string {
+	names := make([]string, 0, len(contractFunctions))
+	for name := range contractFunctions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ************************************
+// listFunctions
+// ************************************
+func (t *SimpleChaincode) listFunctions(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	functions := make([]ContractFunction, 0, len(contractFunctions))
+	for _, name := range sortedFunctionNames() {
+		functions = append(functions, contractFunctions[name])
+	}
+	functionsJSON, err := json.Marshal(functions)
+	if err != nil {
+		err = fmt.Errorf("listFunctions failed to marshal the registry: %s", err)
+		log.Error(err)
+		return nil, err
+	}
+	return functionsJSON, nil
+}
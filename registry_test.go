@@ -0,0 +1,69 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestAdminOnly calls each function that changes the configuration of the
+// contract as an account holder and then as an admin
+func TestAdminOnly(t *testing.T) {
+	tests := []struct {
+		function string
+		arg      string
+	}{
+		{"setLoggingLevel", `{"logLevel":"info"}`},
+		{"setCreateOnUpdate", `{"createOnUpdate":true}`},
+		{"setRecentDepth", `{"category":"` + RECENTDEVICES + `","depth":5}`},
+		{"defineAssetType", `{"name":"pump","rules":["rpmCheck"]}`},
+		{"defineRule", `{"name":"hot","condition":{"op":"gt","left":{"path":"temp"},"right":{"value":90}},"severity":"warning"}`},
+		{"disableRule", `{"name":"timeCheck"}`},
+		{"setRuleThresholds", `{"assettype":"motor","thresholds":{"` + MINRPMPERCENT + `":30}}`},
+		{"setAssetScale", `{"assetID":"EUR","scale":2}`},
+	}
+	s := newMemStub(t)
+	for _, tt := range tests {
+		if !contractFunctions[tt.function].AdminOnly {
+			t.Errorf("%s is not registered as adminOnly", tt.function)
+		}
+		before := s.snapshot()
+		err := s.as("alice").invoke(tt.function, tt.arg)
+		if err == nil || !strings.Contains(err.Error(), ADMINROLE) {
+			t.Errorf("%s by an account holder = %v, want it refused", tt.function, err)
+		}
+		s.assertUnchanged(before, tt.function+" by an account holder")
+		err = s.asAdmin().invoke(tt.function, tt.arg)
+		if err != nil {
+			t.Errorf("%s by an admin failed: %s", tt.function, err)
+		}
+	}
+}
+
+// TestValidateArgKeywords checks the schema keywords the registry publishes
+// are the ones it enforces
+func TestValidateArgKeywords(t *testing.T) {
+	tests := []struct {
+		function string
+		arg      string
+		fails    bool
+	}{
+		{"readAssetHistory", `{"assetID":"m1","from":"2016-10-01T12:00:00Z"}`, false},
+		{"readAssetHistory", `{"assetID":"m1","from":"2016-10-01T12:00:00.5+02:00"}`, false},
+		{"readAssetHistory", `{"assetID":"m1","from":"yesterday"}`, true},
+		{"readAssetHistory", `{"assetID":"m1","to":"2016-10-01"}`, true},
+		{"readAssetAsOf", `{"assetID":"m1","asOf":"12:00"}`, true},
+		{"diffAssetVersions", `{"assetID":"m1","from":2}`, false},
+		{"diffAssetVersions", `{"assetID":"m1","from":"2016-10-01T12:00:00Z"}`, false},
+		{"diffAssetVersions", `{"assetID":"m1","from":"last week"}`, true},
+		{"setRuleThresholds", `{"assettype":"motor","thresholds":{"a":1,"b":null}}`, false},
+		{"setRuleThresholds", `{"assettype":"motor","thresholds":{"a":1,"b":"2"}}`, true},
+		{"setRuleThresholds", `{"assettype":"motor","thresholds":{"a":{}}}`, true},
+	}
+	for _, tt := range tests {
+		f := contractFunctions[tt.function]
+		err := f.validateArg([]string{tt.arg})
+		if tt.fails != (err != nil) {
+			t.Errorf("%s %s = %v, want failure %v", tt.function, tt.arg, err, tt.fails)
+		}
+	}
+}
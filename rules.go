@@ -0,0 +1,641 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//***************************************************
+//* RULES
+//***************************************************
+
+// RULESKEY is used to store the rules
+const RULESKEY string = "RulesKey"
+
+// Rule severities
+const (
+	// SEVERITYINFO alerts are worth knowing about
+	SEVERITYINFO string = "info"
+	// SEVERITYWARNING alerts need attention
+	SEVERITYWARNING string = "warning"
+	// SEVERITYCRITICAL alerts need attention now
+	SEVERITYCRITICAL string = "critical"
+)
+
+var severities = map[string]bool{SEVERITYINFO: true, SEVERITYWARNING: true, SEVERITYCRITICAL: true}
+
+// Rule raises its alert on an asset while its condition holds for the asset's
+// state, and clears it otherwise. With a ClearCondition an active alert only
+// clears once that holds, which gives the alert a hysteresis band. A rule
+// applies to the asset types it lists and to the asset types that list it.
+// Alert defaults to the rule name. Thresholds holds the default value of each
+// threshold the conditions use, see thresholds.go for the overrides.
+type Rule struct {
+	Name           string             `json:"name"`
+	Alert          string             `json:"alert,omitempty"`
+	Description    string             `json:"description,omitempty"`
+	AssetTypes     []string           `json:"assetTypes,omitempty"`
+	Condition      Condition          `json:"condition"`
+	ClearCondition *Condition         `json:"clearCondition,omitempty"`
+	Thresholds     map[string]float64 `json:"thresholds,omitempty"`
+	Severity       string             `json:"severity"`
+	Disabled       bool               `json:"disabled,omitempty"`
+}
+
+// Rules is the ordered list of rules, held under a single key
+type Rules struct {
+	Rules []Rule `json:"rules"`
+}
+
+// Condition is a boolean expression over an asset state. and, or and not
+// combine Args; eq, ne, lt, le, gt and ge compare Left with Right; exists
+// holds when Left resolves.
+type Condition struct {
+	Op    string      `json:"op"`
+	Args  []Condition `json:"args,omitempty"`
+	Left  *Operand    `json:"left,omitempty"`
+	Right *Operand    `json:"right,omitempty"`
+}
+
+// Operand is a value in a condition: the property at a Path such as common.rpm,
+// sensors[0].rpm or /common/rpm, a literal Value, a named Threshold, or the add,
+// sub, ratio or percent of its two Args. A comparison whose left Path has a *
+// wildcard holds when it holds for any of the properties the Path selects.
+type Operand struct {
+	Path      string      `json:"path,omitempty"`
+	Value     interface{} `json:"value,omitempty"`
+	Threshold string      `json:"threshold,omitempty"`
+	Op        string      `json:"op,omitempty"`
+	Args      []Operand   `json:"args,omitempty"`
+}
+
+// operandOps are the values allowed in Operand.Op
+var operandOps = map[string]bool{"add": true, "sub": true, "ratio": true, "percent": true}
+
+// ruleContext is what conditions are evaluated against
+type ruleContext struct {
+	state      map[string]interface{}
+	thresholds map[string]float64
+}
+
+// Built in thresholds
+const (
+	// MINRPMPERCENT is the lowest safe speed as a percent of max_rpm
+	MINRPMPERCENT string = "minRPMPercent"
+	// RPMHYSTERESIS is how many percent above the floor a slow motor must
+	// reach before its alert clears
+	RPMHYSTERESIS string = "rpmHysteresis"
+	// HVACTOLERANCE is how many degrees the ambient temperature can overshoot
+	// the target before the HVAC is alerted
+	HVACTOLERANCE string = "hvacTolerance"
+	// HVACHYSTERESIS is how many degrees back inside the tolerance the ambient
+	// temperature must come before the HVAC alert clears
+	HVACHYSTERESIS string = "hvacHysteresis"
+)
+
+// defaultRules are the rules of a contract that never defined one, they are
+// the rules the contract always had
+func defaultRules() Rules {
+	percentRPM := arithOperand("percent", pathOperand("rpm"), pathOperand("max_rpm"))
+	ambient := pathOperand("ambient_temperature_c")
+	// the band in which a heating HVAC is alerted starts at target + tolerance
+	// and one that is cooling at target - tolerance
+	overheatAt := arithOperand("add", pathOperand("target_temperature_c"), thresholdOperand(HVACTOLERANCE))
+	overcoolAt := arithOperand("sub", pathOperand("target_temperature_c"), thresholdOperand(HVACTOLERANCE))
+	hvacThresholds := map[string]float64{HVACTOLERANCE: 0, HVACHYSTERESIS: 0}
+	return Rules{[]Rule{
+		{
+			Name:        "timeCheck",
+			Alert:       "CREATE_TIME_GREATER_THAN_MODIFY_TIME",
+			Description: "the device was modified before it was created",
+			Condition:   compareCondition("gt", pathOperand("create_date"), pathOperand("last_mod_date")),
+			Severity:    SEVERITYWARNING,
+		},
+		{
+			// Reference : http://www.vfds.in/be-aware-of-vfd-running-in-low-speed-frequency-655982.html
+			Name:           "rpmCheck",
+			Alert:          "RPM_LESS_THAN_20PERCENT",
+			Description:    "a motor running this slowly will likely overheat",
+			Condition:      compareCondition("le", percentRPM, thresholdOperand(MINRPMPERCENT)),
+			ClearCondition: conditionPtr(compareCondition("gt", percentRPM, arithOperand("add", thresholdOperand(MINRPMPERCENT), thresholdOperand(RPMHYSTERESIS)))),
+			Thresholds:     map[string]float64{MINRPMPERCENT: 20, RPMHYSTERESIS: 0},
+			Severity:       SEVERITYCRITICAL,
+		},
+		{
+			Name:        "hvacOverheatCheck",
+			Alert:       "HVAC_OVERHEAT",
+			Description: "the HVAC is heating a space that is already over its target",
+			Condition: Condition{Op: "and", Args: []Condition{
+				compareCondition("eq", pathOperand("hvac_mode"), valueOperand("heat")),
+				compareCondition("gt", ambient, overheatAt),
+			}},
+			ClearCondition: &Condition{Op: "or", Args: []Condition{
+				compareCondition("ne", pathOperand("hvac_mode"), valueOperand("heat")),
+				compareCondition("le", ambient, arithOperand("sub", overheatAt, thresholdOperand(HVACHYSTERESIS))),
+			}},
+			Thresholds: hvacThresholds,
+			Severity:   SEVERITYWARNING,
+		},
+		{
+			Name:        "hvacOvercoolCheck",
+			Alert:       "HVAC_OVERCOOL",
+			Description: "the HVAC is cooling a space that is already under its target",
+			Condition: Condition{Op: "and", Args: []Condition{
+				compareCondition("eq", pathOperand("hvac_mode"), valueOperand("cool")),
+				compareCondition("lt", ambient, overcoolAt),
+			}},
+			ClearCondition: &Condition{Op: "or", Args: []Condition{
+				compareCondition("ne", pathOperand("hvac_mode"), valueOperand("cool")),
+				compareCondition("ge", ambient, arithOperand("add", overcoolAt, thresholdOperand(HVACHYSTERESIS))),
+			}},
+			Thresholds: hvacThresholds,
+			Severity:   SEVERITYWARNING,
+		},
+	}}
+}
+
+func pathOperand(path string) Operand {
+	return Operand{Path: path}
+}
+
+func valueOperand(v interface{}) Operand {
+	return Operand{Value: v}
+}
+
+func thresholdOperand(name string) Operand {
+	return Operand{Threshold: name}
+}
+
+func arithOperand(op string, a Operand, b Operand) Operand {
+	return Operand{Op: op, Args: []Operand{a, b}}
+}
+
+func compareCondition(op string, left Operand, right Operand) Condition {
+	return Condition{Op: op, Left: &left, Right: &right}
+}
+
+func conditionPtr(c Condition) *Condition {
+	return &c
+}
+
+func (r *Rule) alertName() string {
+	if r.Alert != "" {
+		return r.Alert
+	}
+	return r.Name
+}
+
+func (r *Rule) appliesTo(at AssetType) bool {
+	return contains(r.AssetTypes, at.Name) || contains(at.Rules, r.Name)
+}
+
+// thresholdNames lists the thresholds that the rule's conditions use
+func (r *Rule) thresholdNames() []string {
+	names := make(map[string]bool)
+	r.Condition.collectThresholds(names)
+	if r.ClearCondition != nil {
+		r.ClearCondition.collectThresholds(names)
+	}
+	list := make([]string, 0, len(names))
+	for name := range names {
+		list = append(list, name)
+	}
+	sort.Strings(list)
+	return list
+}
+
+func (c *Condition) collectThresholds(names map[string]bool) {
+	for i := range c.Args {
+		c.Args[i].collectThresholds(names)
+	}
+	c.Left.collectThresholds(names)
+	c.Right.collectThresholds(names)
+}
+
+func (o *Operand) collectThresholds(names map[string]bool) {
+	if o == nil {
+		return
+	}
+	if o.Threshold != "" {
+		names[o.Threshold] = true
+	}
+	for i := range o.Args {
+		o.Args[i].collectThresholds(names)
+	}
+}
+
+func (rules *Rules) find(name string) (int, bool) {
+	for i := range rules.Rules {
+		if rules.Rules[i].Name == name {
+			return i, true
+		}
+	}
+	return -1, false
+}
+
+// evaluate returns whether the condition holds, a condition over a property
+// that is missing or of the wrong type does not hold
+func (c *Condition) evaluate(ctx *ruleContext) bool {
+	switch c.Op {
+	case "and":
+		for i := range c.Args {
+			if !c.Args[i].evaluate(ctx) {
+				return false
+			}
+		}
+		return true
+	case "or":
+		for i := range c.Args {
+			if c.Args[i].evaluate(ctx) {
+				return true
+			}
+		}
+		return false
+	case "not":
+		return len(c.Args) == 1 && !c.Args[0].evaluate(ctx)
+	case "exists":
+		return len(c.Left.resolveEach(ctx)) > 0
+	}
+	right, found := c.Right.resolve(ctx)
+	if !found {
+		return false
+	}
+	for _, left := range c.Left.resolveEach(ctx) {
+		if c.compare(left, right) {
+			return true
+		}
+	}
+	return false
+}
+
+// compare applies the comparison op of the condition to two values
+func (c *Condition) compare(left interface{}, right interface{}) bool {
+	cmp, comparable := compareValues(left, right)
+	if !comparable {
+		// values of different kinds are only ever not equal
+		return c.Op == "ne"
+	}
+	switch c.Op {
+	case "eq":
+		return cmp == 0
+	case "ne":
+		return cmp != 0
+	case "lt":
+		return cmp < 0
+	case "le":
+		return cmp <= 0
+	case "gt":
+		return cmp > 0
+	case "ge":
+		return cmp >= 0
+	}
+	return false
+}
+
+// resolveEach returns each value of the operand, those that a wildcard Path
+// selects or its single value
+func (o *Operand) resolveEach(ctx *ruleContext) []interface{} {
+	if o != nil && o.Path != "" {
+		path, err := parsePath(o.Path)
+		if err == nil && path.hasWildcard() {
+			return path.values(ctx.state)
+		}
+	}
+	v, found := o.resolve(ctx)
+	if !found {
+		return nil
+	}
+	return []interface{}{v}
+}
+
+// check rejects a condition that evaluate could not apply
+func (c *Condition) check() error {
+	switch c.Op {
+	case "and", "or":
+		if len(c.Args) == 0 {
+			return fmt.Errorf("%s needs at least one condition in args", c.Op)
+		}
+	case "not":
+		if len(c.Args) != 1 {
+			return errors.New("not needs exactly one condition in args")
+		}
+	case "exists":
+		if c.Left == nil {
+			return errors.New("exists needs a left operand")
+		}
+		return c.Left.check()
+	case "eq", "ne", "lt", "le", "gt", "ge":
+		if c.Left == nil || c.Right == nil {
+			return fmt.Errorf("%s needs left and right operands", c.Op)
+		}
+		err := c.Left.check()
+		if err == nil {
+			err = c.Right.check()
+		}
+		return err
+	default:
+		return fmt.Errorf("unknown condition op %q", c.Op)
+	}
+	for i := range c.Args {
+		err := c.Args[i].check()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolve returns the value of the operand in the state
+func (o *Operand) resolve(ctx *ruleContext) (interface{}, bool) {
+	switch {
+	case o == nil:
+		return nil, false
+	case o.Op != "":
+		if len(o.Args) != 2 {
+			return nil, false
+		}
+		a, found := o.Args[0].resolve(ctx)
+		if !found {
+			return nil, false
+		}
+		b, found := o.Args[1].resolve(ctx)
+		if !found {
+			return nil, false
+		}
+		x, isNumber := toFloat(a)
+		y, isNumber2 := toFloat(b)
+		if !isNumber || !isNumber2 {
+			return nil, false
+		}
+		switch o.Op {
+		case "add":
+			return x + y, true
+		case "sub":
+			return x - y, true
+		}
+		if y == 0 {
+			return nil, false
+		}
+		if o.Op == "percent" {
+			return x / y * 100, true
+		}
+		return x / y, true
+	case o.Threshold != "":
+		v, found := ctx.thresholds[o.Threshold]
+		return v, found
+	case o.Path != "":
+		return getObject(ctx.state, o.Path)
+	}
+	return o.Value, o.Value != nil
+}
+
+func (o *Operand) check() error {
+	set := 0
+	if o.Path != "" {
+		set++
+		if _, err := parsePath(o.Path); err != nil {
+			return err
+		}
+	}
+	if o.Value != nil {
+		set++
+	}
+	if o.Threshold != "" {
+		set++
+	}
+	if o.Op != "" {
+		set++
+		if !operandOps[o.Op] {
+			return fmt.Errorf("unknown operand op %q", o.Op)
+		}
+		if len(o.Args) != 2 {
+			return fmt.Errorf("%s needs exactly two operands in args", o.Op)
+		}
+		for i := range o.Args {
+			err := o.Args[i].check()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if set != 1 {
+		return errors.New("an operand needs exactly one of path, value, threshold or op")
+	}
+	return nil
+}
+
+// compareValues orders two numbers, two strings or two booleans
+func compareValues(a interface{}, b interface{}) (int, bool) {
+	if x, isNumber := toFloat(a); isNumber {
+		y, isNumber := toFloat(b)
+		if !isNumber {
+			return 0, false
+		}
+		switch {
+		case x < y:
+			return -1, true
+		case x > y:
+			return 1, true
+		}
+		return 0, true
+	}
+	switch x := a.(type) {
+	case string:
+		y, isString := b.(string)
+		if !isString {
+			return 0, false
+		}
+		return strings.Compare(x, y), true
+	case bool:
+		y, isBool := b.(bool)
+		if !isBool {
+			return 0, false
+		}
+		if x == y {
+			return 0, true
+		}
+		if !x {
+			return -1, true
+		}
+		return 1, true
+	}
+	return 0, false
+}
+
+// ************************************
+// defineRule
+// ************************************
+func (t *SimpleChaincode) defineRule(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var rule Rule
+	var err error
+
+	log.Info("Entering defineRule")
+
+	if len(args) != 1 {
+		err = errors.New("defineRule expects one JSON object with name, assetTypes, condition and severity")
+		log.Error(err)
+		return nil, err
+	}
+	err = json.Unmarshal([]byte(args[0]), &rule)
+	if err != nil {
+		err = fmt.Errorf("defineRule failed to unmarshal arg: %s", err)
+		log.Error(err)
+		return nil, err
+	}
+	if rule.Name == "" {
+		err = errors.New("defineRule arg does not include name")
+		log.Error(err)
+		return nil, err
+	}
+	if !severities[rule.Severity] {
+		err = fmt.Errorf("defineRule rule %s severity %q is not one of info, warning or critical", rule.Name, rule.Severity)
+		log.Error(err)
+		return nil, err
+	}
+	err = rule.Condition.check()
+	if err != nil {
+		err = fmt.Errorf("defineRule rule %s condition: %s", rule.Name, err)
+		log.Error(err)
+		return nil, err
+	}
+	if rule.ClearCondition != nil {
+		err = rule.ClearCondition.check()
+		if err != nil {
+			err = fmt.Errorf("defineRule rule %s clearCondition: %s", rule.Name, err)
+			log.Error(err)
+			return nil, err
+		}
+	}
+	for _, name := range rule.thresholdNames() {
+		if _, found := rule.Thresholds[name]; !found {
+			err = fmt.Errorf("defineRule rule %s uses threshold %s without giving it a default in thresholds", rule.Name, name)
+			log.Error(err)
+			return nil, err
+		}
+	}
+	types, err := GETAssetTypesFromLedger(stub)
+	if err != nil {
+		err = fmt.Errorf("defineRule %s", err)
+		log.Error(err)
+		return nil, err
+	}
+	for _, typeName := range rule.AssetTypes {
+		if _, found := types.find(typeName); !found {
+			err = fmt.Errorf("defineRule rule %s names unregistered asset type %s", rule.Name, typeName)
+			log.Error(err)
+			return nil, err
+		}
+	}
+
+	rules, err := GETRulesFromLedger(stub)
+	if err != nil {
+		err = fmt.Errorf("defineRule %s", err)
+		log.Error(err)
+		return nil, err
+	}
+	if i, found := rules.find(rule.Name); found {
+		rules.Rules[i] = rule
+	} else {
+		rules.Rules = append(rules.Rules, rule)
+	}
+	err = PUTRulesToLedger(stub, rules)
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("defineRule rule %s defined", rule.Name)
+	return nil, nil
+}
+
+// ************************************
+// disableRule
+// ************************************
+func (t *SimpleChaincode) disableRule(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var arg struct {
+		Name string `json:"name"`
+	}
+	var err error
+
+	if len(args) != 1 {
+		err = errors.New("disableRule expects one JSON object with a name")
+		log.Error(err)
+		return nil, err
+	}
+	err = json.Unmarshal([]byte(args[0]), &arg)
+	if err != nil || arg.Name == "" {
+		err = fmt.Errorf("disableRule arg must be a JSON object with a name: %s", args[0])
+		log.Error(err)
+		return nil, err
+	}
+	rules, err := GETRulesFromLedger(stub)
+	if err != nil {
+		err = fmt.Errorf("disableRule %s", err)
+		log.Error(err)
+		return nil, err
+	}
+	i, found := rules.find(arg.Name)
+	if !found {
+		err = fmt.Errorf("disableRule rule %s does not exist", arg.Name)
+		log.Error(err)
+		return nil, err
+	}
+	rules.Rules[i].Disabled = true
+	err = PUTRulesToLedger(stub, rules)
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("disableRule rule %s disabled", arg.Name)
+	return nil, nil
+}
+
+// ************************************
+// readRules
+// ************************************
+func (t *SimpleChaincode) readRules(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	rules, err := GETRulesFromLedger(stub)
+	if err != nil {
+		err = fmt.Errorf("readRules %s", err)
+		log.Error(err)
+		return nil, err
+	}
+	return json.Marshal(rules)
+}
+
+// GETRulesFromLedger returns the rules, or the default rules when no rule was
+// ever defined
+func GETRulesFromLedger(stub shim.ChaincodeStubInterface) (Rules, error) {
+	var rules Rules
+	rulesBytes, err := stub.GetState(RULESKEY)
+	if err != nil {
+		return rules, fmt.Errorf("GETSTATE for rules failed: %s", err)
+	}
+	if len(rulesBytes) == 0 {
+		return defaultRules(), nil
+	}
+	err = json.Unmarshal(rulesBytes, &rules)
+	if err != nil {
+		return rules, fmt.Errorf("rules failed to unmarshal: %s", err)
+	}
+	return rules, nil
+}
+
+// PUTRulesToLedger marshals the rules and writes them to the ledger
+func PUTRulesToLedger(stub shim.ChaincodeStubInterface, rules Rules) error {
+	rulesBytes, err := json.Marshal(rules)
+	if err != nil {
+		err = fmt.Errorf("Failed to marshal rules: %s", err)
+		log.Critical(err)
+		return err
+	}
+	err = stub.PutState(RULESKEY, rulesBytes)
+	if err != nil {
+		err = fmt.Errorf("Failed to PUTSTATE rules: %s", err)
+		log.Critical(err)
+		return err
+	}
+	return nil
+}
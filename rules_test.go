@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// decodeCondition decodes a test condition as defineRule does
+func decodeCondition(t *testing.T, s string) Condition {
+	t.Helper()
+	var c Condition
+	err := json.Unmarshal([]byte(s), &c)
+	if err != nil {
+		t.Fatalf("bad test condition %s: %s", s, err)
+	}
+	err = c.check()
+	if err != nil {
+		t.Fatalf("test condition %s fails its check: %s", s, err)
+	}
+	return c
+}
+
+func TestConditionEvaluate(t *testing.T) {
+	state := `{"common":{"rpm":900,"max_rpm":3000,"status":"running","on":true},
+		"sensors":[{"temp":40},{"temp":85},{"name":"spare"}],"zero":0}`
+	thresholds := map[string]float64{"limit": 80, MINRPMPERCENT: 25}
+	tests := []struct {
+		condition string
+		want      bool
+	}{
+		{`{"op":"eq","left":{"path":"common.rpm"},"right":{"value":900}}`, true},
+		{`{"op":"ne","left":{"path":"common.rpm"},"right":{"value":900}}`, false},
+		{`{"op":"lt","left":{"path":"common.rpm"},"right":{"value":1000}}`, true},
+		{`{"op":"le","left":{"path":"common.rpm"},"right":{"value":900}}`, true},
+		{`{"op":"gt","left":{"path":"common.rpm"},"right":{"value":900}}`, false},
+		{`{"op":"ge","left":{"path":"/common/rpm"},"right":{"value":900}}`, true},
+		{`{"op":"eq","left":{"path":"common.status"},"right":{"value":"running"}}`, true},
+		{`{"op":"lt","left":{"path":"common.status"},"right":{"value":"stopped"}}`, true},
+		{`{"op":"eq","left":{"path":"common.on"},"right":{"value":true}}`, true},
+		// values of different kinds are only ever not equal
+		{`{"op":"eq","left":{"path":"common.rpm"},"right":{"value":"900"}}`, false},
+		{`{"op":"ne","left":{"path":"common.rpm"},"right":{"value":"900"}}`, true},
+		{`{"op":"lt","left":{"path":"common.status"},"right":{"value":1}}`, false},
+		// a missing property holds for nothing, not even ne
+		{`{"op":"ne","left":{"path":"common.missing"},"right":{"value":1}}`, false},
+		{`{"op":"eq","left":{"path":"common.rpm"},"right":{"path":"common.missing"}}`, false},
+		{`{"op":"exists","left":{"path":"common.rpm"}}`, true},
+		{`{"op":"exists","left":{"path":"common.missing"}}`, false},
+		{`{"op":"exists","left":{"path":"sensors[*].name"}}`, true},
+		{`{"op":"exists","left":{"path":"sensors[*].speed"}}`, false},
+		// a wildcard holds when any of its values holds
+		{`{"op":"gt","left":{"path":"sensors[*].temp"},"right":{"threshold":"limit"}}`, true},
+		{`{"op":"gt","left":{"path":"sensors[*].temp"},"right":{"value":90}}`, false},
+		{`{"op":"gt","left":{"path":"sensors[*].temp"},"right":{"threshold":"unknown"}}`, false},
+		{`{"op":"lt","left":{"path":"common.rpm"},"right":{"op":"percent","args":[
+			{"threshold":"minRPMPercent"},{"value":100}]}}`, false},
+		{`{"op":"lt","left":{"op":"percent","args":[{"path":"common.rpm"},{"path":"common.max_rpm"}]},
+			"right":{"threshold":"minRPMPercent"}}`, false},
+		{`{"op":"eq","left":{"op":"ratio","args":[{"path":"common.rpm"},{"path":"common.max_rpm"}]},
+			"right":{"value":0.3}}`, true},
+		{`{"op":"eq","left":{"op":"add","args":[{"path":"common.rpm"},{"value":100}]},"right":{"value":1000}}`, true},
+		{`{"op":"eq","left":{"op":"sub","args":[{"path":"common.rpm"},{"value":100}]},"right":{"value":800}}`, true},
+		// division by zero does not resolve
+		{`{"op":"ne","left":{"op":"ratio","args":[{"path":"common.rpm"},{"path":"zero"}]},"right":{"value":0}}`, false},
+		{`{"op":"ne","left":{"op":"percent","args":[{"path":"common.rpm"},{"value":0}]},"right":{"value":0}}`, false},
+		{`{"op":"eq","left":{"op":"add","args":[{"path":"common.status"},{"value":1}]},"right":{"value":1}}`, false},
+		{`{"op":"and","args":[{"op":"exists","left":{"path":"common.rpm"}},{"op":"exists","left":{"path":"zero"}}]}`, true},
+		{`{"op":"and","args":[{"op":"exists","left":{"path":"common.rpm"}},{"op":"exists","left":{"path":"x"}}]}`, false},
+		{`{"op":"or","args":[{"op":"exists","left":{"path":"x"}},{"op":"exists","left":{"path":"zero"}}]}`, true},
+		{`{"op":"or","args":[{"op":"exists","left":{"path":"x"}},{"op":"exists","left":{"path":"y"}}]}`, false},
+		{`{"op":"not","args":[{"op":"exists","left":{"path":"x"}}]}`, true},
+		{`{"op":"not","args":[{"op":"exists","left":{"path":"zero"}}]}`, false},
+	}
+	for _, tt := range tests {
+		c := decodeCondition(t, tt.condition)
+		ctx := &ruleContext{state: decodeJSON(t, state).(map[string]interface{}), thresholds: thresholds}
+		if got := c.evaluate(ctx); got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.condition, got, tt.want)
+		}
+	}
+}
+
+func TestConditionCheck(t *testing.T) {
+	tests := []string{
+		`{"op":"within","left":{"path":"a"},"right":{"value":1}}`,
+		`{"op":"eq","left":{"path":"a"}}`,
+		`{"op":"exists"}`,
+		`{"op":"and","args":[]}`,
+		`{"op":"not","args":[{"op":"exists","left":{"path":"a"}},{"op":"exists","left":{"path":"b"}}]}`,
+		`{"op":"or","args":[{"op":"eq"}]}`,
+		`{"op":"eq","left":{"path":"a..b"},"right":{"value":1}}`,
+		`{"op":"eq","left":{"path":"a","value":1},"right":{"value":1}}`,
+		`{"op":"eq","left":{},"right":{"value":1}}`,
+		`{"op":"eq","left":{"op":"mod","args":[{"value":1},{"value":2}]},"right":{"value":1}}`,
+		`{"op":"eq","left":{"op":"add","args":[{"value":1}]},"right":{"value":1}}`,
+		`{"op":"eq","left":{"op":"add","args":[{"value":1},{}]},"right":{"value":1}}`,
+	}
+	for _, s := range tests {
+		var c Condition
+		err := json.Unmarshal([]byte(s), &c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.check() == nil {
+			t.Errorf("check passed %s", s)
+		}
+	}
+}
+
+func TestDefaultRulesCheck(t *testing.T) {
+	for _, r := range defaultRules().Rules {
+		err := r.Condition.check()
+		if err == nil && r.ClearCondition != nil {
+			err = r.ClearCondition.check()
+		}
+		if err != nil {
+			t.Errorf("default rule %s: %s", r.Name, err)
+		}
+		if !severities[r.Severity] {
+			t.Errorf("default rule %s has severity %q", r.Name, r.Severity)
+		}
+	}
+}
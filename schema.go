@@ -0,0 +1,330 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//***************************************************
+//* ASSET SCHEMAS
+//***************************************************
+
+// Schema is the subset of JSON Schema that asset types use to describe their
+// state, and the registry to describe function arguments: types, required
+// properties, numeric ranges, enums, string formats, nested objects, the
+// properties an object does not list, array items and oneOf. Property names
+// are matched as findMatchingKey matches them.
+type Schema struct {
+	Type                 string             `json:"type,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	ExclusiveMinimum     *float64           `json:"exclusiveMinimum,omitempty"`
+	ExclusiveMaximum     *float64           `json:"exclusiveMaximum,omitempty"`
+	Enum                 []interface{}      `json:"enum,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	OneOf                []*Schema          `json:"oneOf,omitempty"`
+}
+
+// schemaTypes are the values allowed in Schema.Type
+var schemaTypes = map[string]bool{
+	"object":  true,
+	"array":   true,
+	"string":  true,
+	"number":  true,
+	"integer": true,
+	"boolean": true,
+	"null":    true,
+}
+
+// schemaFormats are the values allowed in Schema.Format, each checks a string
+var schemaFormats = map[string]func(string) bool{
+	"date-time": func(s string) bool {
+		_, err := time.Parse(time.RFC3339Nano, s)
+		return err == nil
+	},
+}
+
+// FieldError is one violation of a schema, Field is the qualified property
+// name such as common.location.latitude
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// SchemaValidationError is returned when an asset state violates the schema of
+// its asset type, it lists every violation
+type SchemaValidationError struct {
+	AssetID   string       `json:"assetID"`
+	AssetType string       `json:"assettype"`
+	Errors    []FieldError `json:"errors"`
+}
+
+func (e *SchemaValidationError) Error() string {
+	msgs := make([]string, 0, len(e.Errors))
+	for _, fe := range e.Errors {
+		msgs = append(msgs, fe.Field+": "+fe.Message)
+	}
+	return fmt.Sprintf("asset %s of type %s violates its schema: %s", e.AssetID, e.AssetType, strings.Join(msgs, "; "))
+}
+
+// validateAssetState checks the full state of an asset against the schema of
+// its type, an asset type without a schema accepts any state
+func validateAssetState(at AssetType, assetID string, state map[string]interface{}) error {
+	if at.Schema == nil {
+		return nil
+	}
+	fieldErrors := at.Schema.validate("", state)
+	if len(fieldErrors) == 0 {
+		return nil
+	}
+	return &SchemaValidationError{assetID, at.Name, fieldErrors}
+}
+
+func (s *Schema) validate(field string, v interface{}) []FieldError {
+	var fieldErrors []FieldError
+	fail := func(format string, args ...interface{}) {
+		name := field
+		if name == "" {
+			name = "(state)"
+		}
+		fieldErrors = append(fieldErrors, FieldError{name, fmt.Sprintf(format, args...)})
+	}
+
+	if s.Type != "" && !schemaTypeMatches(s.Type, v) {
+		fail("expected %s, got %s", s.Type, jsonTypeOf(v))
+		return fieldErrors
+	}
+	if len(s.OneOf) > 0 {
+		matches := 0
+		for _, alternative := range s.OneOf {
+			if len(alternative.validate(field, v)) == 0 {
+				matches++
+			}
+		}
+		if matches != 1 {
+			fail("%v matches %d of the %d alternatives, it must match one", v, matches, len(s.OneOf))
+		}
+	}
+	if len(s.Enum) > 0 && !enumContains(s.Enum, v) {
+		fail("%v is not one of %v", v, s.Enum)
+	}
+	if str, isString := v.(string); isString && s.Format != "" && !schemaFormats[s.Format](str) {
+		fail("%q is not a %s", str, s.Format)
+	}
+	if n, isNumber := toFloat(v); isNumber {
+		if s.Minimum != nil && n < *s.Minimum {
+			fail("%v is less than the minimum %v", n, *s.Minimum)
+		}
+		if s.Maximum != nil && n > *s.Maximum {
+			fail("%v is greater than the maximum %v", n, *s.Maximum)
+		}
+		if s.ExclusiveMinimum != nil && n <= *s.ExclusiveMinimum {
+			fail("%v must be greater than %v", n, *s.ExclusiveMinimum)
+		}
+		if s.ExclusiveMaximum != nil && n >= *s.ExclusiveMaximum {
+			fail("%v must be less than %v", n, *s.ExclusiveMaximum)
+		}
+	}
+
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, found := findMatchingKey(val, name); !found {
+				fieldErrors = append(fieldErrors, FieldError{qualifyField(field, name), "is required"})
+			}
+		}
+		names := make([]string, 0, len(s.Properties))
+		for name := range s.Properties {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		listed := make(map[string]bool)
+		for _, name := range names {
+			key, found := findMatchingKey(val, name)
+			if !found {
+				continue
+			}
+			listed[key] = true
+			fieldErrors = append(fieldErrors, s.Properties[name].validate(qualifyField(field, name), val[key])...)
+		}
+		if s.AdditionalProperties != nil {
+			keys := make([]string, 0, len(val))
+			for key := range val {
+				if !listed[key] {
+					keys = append(keys, key)
+				}
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				fieldErrors = append(fieldErrors, s.AdditionalProperties.validate(qualifyField(field, key), val[key])...)
+			}
+		}
+	case []interface{}:
+		if s.Items != nil {
+			for i, item := range val {
+				fieldErrors = append(fieldErrors, s.Items.validate(fmt.Sprintf("%s[%d]", field, i), item)...)
+			}
+		}
+	}
+	return fieldErrors
+}
+
+// check rejects a schema that validate could not apply
+func (s *Schema) check(field string) error {
+	if s.Type != "" && !schemaTypes[s.Type] {
+		return fmt.Errorf("schema %s has unknown type %s", field, s.Type)
+	}
+	if _, found := schemaFormats[s.Format]; s.Format != "" && !found {
+		return fmt.Errorf("schema %s has unknown format %s", field, s.Format)
+	}
+	for name, p := range s.Properties {
+		if p == nil {
+			return fmt.Errorf("schema %s is empty", qualifyField(field, name))
+		}
+		err := p.check(qualifyField(field, name))
+		if err != nil {
+			return err
+		}
+	}
+	for i, alternative := range s.OneOf {
+		if alternative == nil {
+			return fmt.Errorf("schema %s alternative %d is empty", field, i)
+		}
+		err := alternative.check(field)
+		if err != nil {
+			return err
+		}
+	}
+	if s.AdditionalProperties != nil {
+		err := s.AdditionalProperties.check(qualifyField(field, "*"))
+		if err != nil {
+			return err
+		}
+	}
+	if s.Items != nil {
+		return s.Items.check(field + "[]")
+	}
+	return nil
+}
+
+func qualifyField(parent string, name string) string {
+	if parent == "" {
+		return name
+	}
+	return parent + "." + name
+}
+
+func schemaTypeMatches(schemaType string, v interface{}) bool {
+	switch schemaType {
+	case "object":
+		_, ok := v.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := v.([]interface{})
+		return ok
+	case "string":
+		_, ok := v.(string)
+		return ok
+	case "boolean":
+		_, ok := v.(bool)
+		return ok
+	case "number":
+		_, ok := toFloat(v)
+		return ok
+	case "integer":
+		n, ok := toFloat(v)
+		return ok && n == math.Trunc(n)
+	case "null":
+		return v == nil
+	}
+	return false
+}
+
+func jsonTypeOf(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case float64, json.Number:
+		return "number"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// toFloat returns the value of a JSON number, decoded with or without UseNumber
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func enumContains(enum []interface{}, v interface{}) bool {
+	vf, vIsNumber := toFloat(v)
+	for _, e := range enum {
+		if ef, isNumber := toFloat(e); isNumber && vIsNumber {
+			if ef == vf {
+				return true
+			}
+		} else if reflect.DeepEqual(e, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// ObjectModel describes the state this contract keeps for each asset type
+type ObjectModel struct {
+	Version    string             `json:"version"`
+	AssetTypes map[string]*Schema `json:"assetTypes"`
+}
+
+// ************************************
+// readContractObjectModel
+// ************************************
+func (t *SimpleChaincode) readContractObjectModel(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	types, err := GETAssetTypesFromLedger(stub)
+	if err != nil {
+		err = fmt.Errorf("readContractObjectModel %s", err)
+		log.Error(err)
+		return nil, err
+	}
+	model := ObjectModel{MYVERSION, make(map[string]*Schema)}
+	for _, at := range types.Types {
+		schema := at.Schema
+		if schema == nil {
+			schema = &Schema{Type: "object"}
+		}
+		model.AssetTypes[at.Name] = schema
+	}
+	modelJSON, err := json.Marshal(model)
+	if err != nil {
+		err = fmt.Errorf("JSON Marshal failed for contract object model: %s", err)
+		log.Error(err)
+		return nil, err
+	}
+	return modelJSON, nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// testSchema is a motor state, it uses every keyword the contract supports
+const testSchema = `{
+	"type": "object",
+	"required": ["assetID", "common"],
+	"properties": {
+		"assetID": {"type": "string"},
+		"common": {
+			"type": "object",
+			"required": ["rpm"],
+			"properties": {
+				"rpm": {"type": "number", "minimum": 0, "maximum": 10000},
+				"load": {"type": "number", "exclusiveMinimum": 0, "exclusiveMaximum": 1},
+				"phases": {"type": "integer", "enum": [1, 3]},
+				"status": {"type": "string", "enum": ["running", "stopped"]},
+				"on": {"type": "boolean"},
+				"location": {"type": "object", "enum": [{"x": 1, "y": 2}, {"x": 0, "y": 0}]},
+				"range": {"type": "array", "enum": [[0, 100], [0, 1000]]}
+			}
+		},
+		"sensors": {
+			"type": "array",
+			"items": {"type": "object", "required": ["name"], "properties": {"name": {"type": "string"}}}
+		},
+		"limit": {"oneOf": [{"type": "number", "minimum": 0}, {"type": "string", "enum": ["none"]}]}
+	}
+}`
+
+func TestValidateAssetState(t *testing.T) {
+	var schema Schema
+	err := json.Unmarshal([]byte(testSchema), &schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = schema.check("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := AssetType{Name: "motor", Schema: &schema}
+	tests := []struct {
+		state  string
+		fields []string
+	}{
+		{`{"assetID":"m1","common":{"rpm":900}}`, nil},
+		{`{"assetID":"m1","common":{"rpm":0,"load":0.5,"phases":3,"status":"running","on":true,
+			"location":{"y":2,"x":1},"range":[0,1000]},"sensors":[{"name":"a"}],"limit":"none","extra":1}`, nil},
+		// names match as findMatchingKey matches them
+		{`{"ASSETID":"m1","Common":{"RPM":900}}`, nil},
+		{`{}`, []string{"assetID", "common"}},
+		{`{"assetID":1,"common":{}}`, []string{"assetID", "common.rpm"}},
+		{`{"assetID":"m1","common":"fast"}`, []string{"common"}},
+		{`{"assetID":"m1","common":{"rpm":-1}}`, []string{"common.rpm"}},
+		{`{"assetID":"m1","common":{"rpm":10001}}`, []string{"common.rpm"}},
+		{`{"assetID":"m1","common":{"rpm":10000,"load":0}}`, []string{"common.load"}},
+		{`{"assetID":"m1","common":{"rpm":900,"load":1}}`, []string{"common.load"}},
+		{`{"assetID":"m1","common":{"rpm":"900"}}`, []string{"common.rpm"}},
+		{`{"assetID":"m1","common":{"rpm":null}}`, []string{"common.rpm"}},
+		{`{"assetID":"m1","common":{"rpm":900,"phases":1.5}}`, []string{"common.phases"}},
+		{`{"assetID":"m1","common":{"rpm":900,"phases":2}}`, []string{"common.phases"}},
+		{`{"assetID":"m1","common":{"rpm":900,"status":"broken"}}`, []string{"common.status"}},
+		{`{"assetID":"m1","common":{"rpm":900,"on":"yes"}}`, []string{"common.on"}},
+		// object and array enums compare whole values
+		{`{"assetID":"m1","common":{"rpm":900,"location":{"x":1,"y":3}}}`, []string{"common.location"}},
+		{`{"assetID":"m1","common":{"rpm":900,"location":{"x":1}}}`, []string{"common.location"}},
+		{`{"assetID":"m1","common":{"rpm":900,"range":[100,0]}}`, []string{"common.range"}},
+		{`{"assetID":"m1","common":{"rpm":900},"sensors":[{"name":"a"},{},{"name":2}]}`,
+			[]string{"sensors[1].name", "sensors[2].name"}},
+		{`{"assetID":"m1","common":{"rpm":900},"sensors":{"name":"a"}}`, []string{"sensors"}},
+		{`{"assetID":"m1","common":{"rpm":900},"limit":-1}`, []string{"limit"}},
+		{`{"assetID":"m1","common":{"rpm":900},"limit":"all"}`, []string{"limit"}},
+		{`{"assetID":"m1","common":{"rpm":900},"limit":true}`, []string{"limit"}},
+		// every violation is listed
+		{`{"common":{"rpm":-1,"status":"broken"},"sensors":[{}]}`,
+			[]string{"assetID", "common.rpm", "common.status", "sensors[0].name"}},
+	}
+	for _, tt := range tests {
+		state := decodeJSON(t, tt.state).(map[string]interface{})
+		err := validateAssetState(at, "m1", state)
+		var got []string
+		if err != nil {
+			verr, isSchemaError := err.(*SchemaValidationError)
+			if !isSchemaError {
+				t.Fatalf("validateAssetState(%s) returned %T", tt.state, err)
+			}
+			if verr.AssetID != "m1" || verr.AssetType != "motor" {
+				t.Errorf("validateAssetState(%s) names %s of type %s", tt.state, verr.AssetID, verr.AssetType)
+			}
+			for _, fe := range verr.Errors {
+				got = append(got, fe.Field)
+			}
+		}
+		if !reflect.DeepEqual(got, tt.fields) {
+			t.Errorf("validateAssetState(%s) fields %q, want %q (%v)", tt.state, got, tt.fields, err)
+		}
+	}
+}
+
+func TestValidateAssetStateRoot(t *testing.T) {
+	at := AssetType{Name: "motor", Schema: &Schema{Type: "object", Enum: []interface{}{map[string]interface{}{}}}}
+	err := validateAssetState(at, "m1", map[string]interface{}{"a": 1.0})
+	verr, isSchemaError := err.(*SchemaValidationError)
+	if !isSchemaError || len(verr.Errors) != 1 || verr.Errors[0].Field != "(state)" {
+		t.Errorf("validateAssetState = %v, want one error on (state)", err)
+	}
+	err = validateAssetState(AssetType{Name: "motor"}, "m1", map[string]interface{}{"a": 1.0})
+	if err != nil {
+		t.Errorf("an asset type without a schema rejected a state: %s", err)
+	}
+}
+
+func TestSchemaCheck(t *testing.T) {
+	tests := []struct {
+		schema string
+		fails  bool
+	}{
+		{testSchema, false},
+		{`{}`, false},
+		{`{"type":"float"}`, true},
+		{`{"type":"object","properties":{"a":null}}`, true},
+		{`{"type":"object","properties":{"a":{"properties":{"b":{"type":"int"}}}}}`, true},
+		{`{"type":"array","items":{"type":"list"}}`, true},
+		{`{"oneOf":[{"type":"number"},null]}`, true},
+		{`{"oneOf":[{"type":"number"},{"type":"text"}]}`, true},
+		{`{"type":"string","format":"date-time"}`, false},
+		{`{"type":"string","format":"email"}`, true},
+		{`{"type":"object","additionalProperties":{"type":"null"}}`, false},
+		{`{"type":"object","additionalProperties":{"type":"text"}}`, true},
+	}
+	for _, tt := range tests {
+		var s Schema
+		err := json.Unmarshal([]byte(tt.schema), &s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = s.check("")
+		if tt.fails != (err != nil) {
+			t.Errorf("check(%s) = %v, want failure %v", tt.schema, err, tt.fails)
+		}
+	}
+}
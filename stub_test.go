@@ -0,0 +1,327 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	gp "google/protobuf"
+)
+
+// memStub is an in-memory ledger for behaviour tests. As on a peer, the writes
+// of a transaction are only committed when it succeeds, a query cannot write,
+// and each transaction has its own ID and a later timestamp. Outside of a
+// transaction writes go straight to the ledger, so that helpers can be called
+// directly. It embeds the stub interface for the methods the contract never
+// calls.
+type memStub struct {
+	shim.ChaincodeStubInterface
+	t      *testing.T
+	cc     *SimpleChaincode
+	state  map[string][]byte
+	writes map[string][]byte
+	inTx   bool
+	query  bool
+	txNum  int
+	now    time.Time
+	attrs  map[string]string
+	events []string
+	// payloads are the decoded payloads of events, in order
+	payloads []ContractEvents
+	// failPut makes a PutState of this key fail
+	failPut string
+	// noTimestamp makes GetTxTimestamp fail
+	noTimestamp bool
+}
+
+// newMemStub returns a ledger with a freshly deployed contract
+func newMemStub(t *testing.T) *memStub {
+	t.Helper()
+	s := &memStub{
+		t:     t,
+		cc:    new(SimpleChaincode),
+		state: map[string][]byte{},
+		now:   time.Date(2016, 10, 1, 12, 0, 0, 0, time.UTC),
+		attrs: map[string]string{},
+	}
+	s.mustInit()
+	return s
+}
+
+func (s *memStub) begin() {
+	s.txNum++
+	s.now = s.now.Add(time.Minute)
+	s.writes = map[string][]byte{}
+	s.inTx = true
+}
+
+// end commits the writes of the transaction, or drops them when it failed
+func (s *memStub) end(err error) {
+	if err == nil {
+		for k, v := range s.writes {
+			if v == nil {
+				delete(s.state, k)
+			} else {
+				s.state[k] = v
+			}
+		}
+	}
+	s.writes = nil
+	s.inTx = false
+}
+
+// mustInit deploys the contract, or upgrades it when the ledger holds an
+// older version
+func (s *memStub) mustInit() {
+	s.t.Helper()
+	s.begin()
+	_, err := s.cc.Init(s, "init", []string{`{"version":"` + MYVERSION + `"}`})
+	s.end(err)
+	if err != nil {
+		s.t.Fatalf("Init failed: %s", err)
+	}
+}
+
+func (s *memStub) invoke(function string, arg string) error {
+	s.begin()
+	_, err := s.cc.Invoke(s, function, []string{arg})
+	s.end(err)
+	return err
+}
+
+func (s *memStub) mustInvoke(function string, arg string) {
+	s.t.Helper()
+	err := s.invoke(function, arg)
+	if err != nil {
+		s.t.Fatalf("%s %s failed: %s", function, arg, err)
+	}
+}
+
+func (s *memStub) mustFailInvoke(function string, arg string) error {
+	s.t.Helper()
+	err := s.invoke(function, arg)
+	if err == nil {
+		s.t.Fatalf("%s %s succeeded, want a failure", function, arg)
+	}
+	return err
+}
+
+func (s *memStub) read(function string, arg string) ([]byte, error) {
+	s.query = true
+	defer func() { s.query = false }()
+	args := []string{}
+	if arg != "" {
+		args = append(args, arg)
+	}
+	return s.cc.Query(s, function, args)
+}
+
+// mustRead runs a query and decodes its result
+func (s *memStub) mustRead(function string, arg string) interface{} {
+	s.t.Helper()
+	result, err := s.read(function, arg)
+	if err != nil {
+		s.t.Fatalf("%s %s failed: %s", function, arg, err)
+	}
+	var v interface{}
+	if len(result) > 0 {
+		err = json.Unmarshal(result, &v)
+		if err != nil {
+			s.t.Fatalf("%s %s returned bad JSON %s: %s", function, arg, result, err)
+		}
+	}
+	return v
+}
+
+// as makes the following calls come from the account, without any role
+func (s *memStub) as(accountID string) *memStub {
+	s.attrs = map[string]string{ACCOUNTID: accountID}
+	return s
+}
+
+// asAdmin makes the following calls come from an admin, who has no account
+func (s *memStub) asAdmin() *memStub {
+	s.attrs = map[string]string{ROLE: ADMINROLE}
+	return s
+}
+
+// stateMap decodes a committed state, nil when there is none
+func (s *memStub) stateMap(key string) map[string]interface{} {
+	s.t.Helper()
+	b, found := s.state[key]
+	if !found {
+		return nil
+	}
+	var m map[string]interface{}
+	err := json.Unmarshal(b, &m)
+	if err != nil {
+		s.t.Fatalf("state %s is not a JSON object: %s", key, err)
+	}
+	return m
+}
+
+// snapshot copies the committed ledger, for checking that a failure left it alone
+func (s *memStub) snapshot() map[string]string {
+	snap := make(map[string]string, len(s.state))
+	for k, v := range s.state {
+		snap[k] = string(v)
+	}
+	return snap
+}
+
+func (s *memStub) assertUnchanged(before map[string]string, what string) {
+	s.t.Helper()
+	after := s.snapshot()
+	if reflect.DeepEqual(before, after) {
+		return
+	}
+	for k, v := range after {
+		if before[k] != v {
+			s.t.Errorf("%s changed %s to %s", what, k, v)
+		}
+	}
+	for k := range before {
+		if _, found := after[k]; !found {
+			s.t.Errorf("%s removed %s", what, k)
+		}
+	}
+}
+
+func (s *memStub) GetState(key string) ([]byte, error) {
+	if v, found := s.writes[key]; found {
+		return v, nil
+	}
+	return s.state[key], nil
+}
+
+func (s *memStub) PutState(key string, value []byte) error {
+	if s.query {
+		return errors.New("a query cannot write to the ledger")
+	}
+	if key == "" {
+		return errors.New("key must not be empty")
+	}
+	if key == s.failPut {
+		return fmt.Errorf("injected failure writing %s", key)
+	}
+	value = append([]byte{}, value...)
+	if s.inTx {
+		s.writes[key] = value
+	} else {
+		s.state[key] = value
+	}
+	return nil
+}
+
+func (s *memStub) DelState(key string) error {
+	if s.query {
+		return errors.New("a query cannot write to the ledger")
+	}
+	if s.inTx {
+		s.writes[key] = nil
+	} else {
+		delete(s.state, key)
+	}
+	return nil
+}
+
+// RangeQueryState includes endKey, as the v0.6 ledger does
+func (s *memStub) RangeQueryState(startKey, endKey string) (shim.StateRangeQueryIteratorInterface, error) {
+	merged := map[string][]byte{}
+	for k, v := range s.state {
+		merged[k] = v
+	}
+	for k, v := range s.writes {
+		merged[k] = v
+	}
+	it := &memIterator{}
+	for k, v := range merged {
+		if v != nil && k >= startKey && k <= endKey {
+			it.keys = append(it.keys, k)
+			it.values = append(it.values, v)
+		}
+	}
+	sort.Sort(it)
+	return it, nil
+}
+
+func (s *memStub) GetTxID() string {
+	return fmt.Sprintf("tx%d", s.txNum)
+}
+
+func (s *memStub) GetTxTimestamp() (*gp.Timestamp, error) {
+	if s.noTimestamp {
+		return nil, errors.New("no timestamp in this transaction")
+	}
+	return &gp.Timestamp{Seconds: s.now.Unix(), Nanos: int32(s.now.Nanosecond())}, nil
+}
+
+func (s *memStub) ReadCertAttribute(name string) ([]byte, error) {
+	v, found := s.attrs[name]
+	if !found {
+		return nil, fmt.Errorf("certificate has no attribute %s", name)
+	}
+	return []byte(v), nil
+}
+
+func (s *memStub) SetEvent(name string, payload []byte) error {
+	var events ContractEvents
+	err := json.Unmarshal(payload, &events)
+	if err != nil {
+		s.t.Errorf("event %s payload is not JSON: %s", name, err)
+	}
+	s.events = append(s.events, name)
+	s.payloads = append(s.payloads, events)
+	return nil
+}
+
+type memIterator struct {
+	keys   []string
+	values [][]byte
+	next   int
+}
+
+func (it *memIterator) Len() int           { return len(it.keys) }
+func (it *memIterator) Less(i, j int) bool { return it.keys[i] < it.keys[j] }
+func (it *memIterator) Swap(i, j int) {
+	it.keys[i], it.keys[j] = it.keys[j], it.keys[i]
+	it.values[i], it.values[j] = it.values[j], it.values[i]
+}
+func (it *memIterator) HasNext() bool { return it.next < len(it.keys) }
+func (it *memIterator) Close() error  { return nil }
+func (it *memIterator) Next() (string, []byte, error) {
+	if !it.HasNext() {
+		return "", nil, errors.New("iterator is exhausted")
+	}
+	it.next++
+	return it.keys[it.next-1], it.values[it.next-1], nil
+}
+
+// withHoldings opens accounts for the bank, alice and bob, and has the bank
+// define USD and issue 100.00 of it to alice
+func withHoldings(t *testing.T) *memStub {
+	t.Helper()
+	s := newMemStub(t)
+	for _, id := range []string{"bank", "alice", "bob"} {
+		s.as(id).mustInvoke("createAccount", `{"accountID":"`+id+`","acname":"`+id+`"}`)
+	}
+	s.as("bank").mustInvoke("defineAsset", `{"assetID":"USD","symbol":"$","decimals":2,"issuer":"bank","maxSupply":"1000"}`)
+	s.as("bank").mustInvoke("issueAsset", `{"accountID":"alice","assetID":"USD","amount":"100"}`)
+	return s
+}
+
+// holding returns the amount of a committed holding, "" when there is none
+func (s *memStub) holding(accountID string, assetID string) string {
+	s.t.Helper()
+	m := s.stateMap(accountID + "_" + assetID)
+	if m == nil {
+		return ""
+	}
+	amount, _ := m[AMOUNT].(string)
+	return amount
+}
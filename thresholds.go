@@ -0,0 +1,332 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//***************************************************
+//* RULE THRESHOLDS
+//***************************************************
+
+// RULETHRESHOLDSKEYPREFIX prefixes the ledger keys of threshold overrides, an
+// asset type's overrides are under type~<assettype> and an asset's under
+// asset~<assetID>_<assettype>, as assets with one ID and different types are
+// different assets
+const RULETHRESHOLDSKEYPREFIX string = "RuleThresholds_"
+
+// RuleThresholds overrides the default values of rule thresholds for either
+// every asset of a type or for a single asset, which has both its assetID and
+// its type. A single asset's overrides win over its type's, which win over the
+// defaults in the rules.
+type RuleThresholds struct {
+	AssetType  string             `json:"assettype,omitempty"`
+	AssetID    string             `json:"assetID,omitempty"`
+	Thresholds map[string]float64 `json:"thresholds"`
+}
+
+// ruleThresholdsArg is the setRuleThresholds argument, a null threshold
+// removes the override. The assettype of an assetID can be left out when only
+// one asset has that ID.
+type ruleThresholdsArg struct {
+	AssetType  string              `json:"assettype"`
+	AssetID    string              `json:"assetID"`
+	Thresholds map[string]*float64 `json:"thresholds"`
+}
+
+func ruleThresholdsKey(assetType string, assetID string) string {
+	if assetID != "" {
+		return RULETHRESHOLDSKEYPREFIX + "asset~" + assetID + "_" + assetType
+	}
+	return RULETHRESHOLDSKEYPREFIX + "type~" + assetType
+}
+
+// ************************************
+// setRuleThresholds
+// ************************************
+func (t *SimpleChaincode) setRuleThresholds(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var arg ruleThresholdsArg
+	var err error
+
+	log.Info("Entering setRuleThresholds")
+
+	if len(args) != 1 {
+		err = errors.New("setRuleThresholds expects one JSON object with assettype or assetID and thresholds")
+		log.Error(err)
+		return nil, err
+	}
+	err = json.Unmarshal([]byte(args[0]), &arg)
+	if err != nil {
+		err = fmt.Errorf("setRuleThresholds failed to unmarshal arg: %s", err)
+		log.Error(err)
+		return nil, err
+	}
+	if arg.AssetType == "" && arg.AssetID == "" {
+		err = errors.New("setRuleThresholds arg must include assettype, assetID or both")
+		log.Error(err)
+		return nil, err
+	}
+	if len(arg.Thresholds) == 0 {
+		err = errors.New("setRuleThresholds arg does not include thresholds")
+		log.Error(err)
+		return nil, err
+	}
+	arg.AssetType, err = thresholdsAssetType(stub, arg.AssetType, arg.AssetID)
+	if err != nil {
+		err = fmt.Errorf("setRuleThresholds %s", err)
+		log.Error(err)
+		return nil, err
+	}
+
+	// only thresholds that some rule uses can be set
+	rules, err := GETRulesFromLedger(stub)
+	if err != nil {
+		err = fmt.Errorf("setRuleThresholds %s", err)
+		log.Error(err)
+		return nil, err
+	}
+	known := make(map[string]bool)
+	for i := range rules.Rules {
+		for _, name := range rules.Rules[i].thresholdNames() {
+			known[name] = true
+		}
+	}
+
+	overrides, err := GETRuleThresholdsFromLedger(stub, arg.AssetType, arg.AssetID)
+	if err != nil {
+		err = fmt.Errorf("setRuleThresholds %s", err)
+		log.Error(err)
+		return nil, err
+	}
+	for name, v := range arg.Thresholds {
+		if !known[name] {
+			err = fmt.Errorf("setRuleThresholds no rule uses threshold %s", name)
+			log.Error(err)
+			return nil, err
+		}
+		if v == nil {
+			delete(overrides.Thresholds, name)
+			continue
+		}
+		overrides.Thresholds[name] = *v
+	}
+	err = PUTRuleThresholdsToLedger(stub, overrides)
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("setRuleThresholds thresholds for %s set", ruleThresholdsKey(arg.AssetType, arg.AssetID))
+	return nil, nil
+}
+
+// ************************************
+// readRuleThresholds
+// ************************************
+func (t *SimpleChaincode) readRuleThresholds(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var arg ruleThresholdsArg
+	var err error
+
+	err = json.Unmarshal([]byte(args[0]), &arg)
+	if err != nil || (arg.AssetType == "" && arg.AssetID == "") {
+		err = fmt.Errorf("readRuleThresholds arg must be a JSON object with assettype, assetID or both: %s", args[0])
+		log.Error(err)
+		return nil, err
+	}
+	arg.AssetType, err = thresholdsAssetType(stub, arg.AssetType, arg.AssetID)
+	if err != nil {
+		err = fmt.Errorf("readRuleThresholds %s", err)
+		log.Error(err)
+		return nil, err
+	}
+	overrides, err := GETRuleThresholdsFromLedger(stub, arg.AssetType, arg.AssetID)
+	if err != nil {
+		err = fmt.Errorf("readRuleThresholds %s", err)
+		log.Error(err)
+		return nil, err
+	}
+	return json.Marshal(overrides)
+}
+
+// thresholdsAssetType returns the registered type whose overrides, or whose
+// asset's overrides, are set or read. Without a type, the type is that of the
+// only active or deleted asset with the assetID.
+func thresholdsAssetType(stub shim.ChaincodeStubInterface, assetType string, assetID string) (string, error) {
+	types, err := GETAssetTypesFromLedger(stub)
+	if err != nil {
+		return "", err
+	}
+	if assetType != "" {
+		if _, found := types.find(assetType); !found {
+			return "", fmt.Errorf("asset type %s is not registered", assetType)
+		}
+		return assetType, nil
+	}
+	var names []string
+	for _, at := range types.Types {
+		sAssetKey := assetID + "_" + at.Name
+		if assetIsActive(stub, sAssetKey) || assetIsDeleted(stub, sAssetKey) {
+			names = append(names, at.Name)
+		}
+	}
+	switch len(names) {
+	case 0:
+		return "", fmt.Errorf("asset %s does not exist, its assettype is needed", assetID)
+	case 1:
+		return names[0], nil
+	}
+	return "", fmt.Errorf("assets of types %v have ID %s, its assettype is needed", names, assetID)
+}
+
+// GETRuleThresholdsFromLedger returns the overrides of an asset type, or of
+// the asset of that type with the assetID, which are empty when none were set
+func GETRuleThresholdsFromLedger(stub shim.ChaincodeStubInterface, assetType string, assetID string) (RuleThresholds, error) {
+	overrides := RuleThresholds{AssetType: assetType, AssetID: assetID}
+	key := ruleThresholdsKey(assetType, assetID)
+	overridesBytes, err := stub.GetState(key)
+	if err != nil {
+		return overrides, fmt.Errorf("GETSTATE for %s failed: %s", key, err)
+	}
+	if len(overridesBytes) > 0 {
+		err = json.Unmarshal(overridesBytes, &overrides)
+		if err != nil {
+			return overrides, fmt.Errorf("%s failed to unmarshal: %s", key, err)
+		}
+	}
+	if overrides.Thresholds == nil {
+		overrides.Thresholds = make(map[string]float64)
+	}
+	return overrides, nil
+}
+
+// PUTRuleThresholdsToLedger marshals threshold overrides and writes them to the ledger
+func PUTRuleThresholdsToLedger(stub shim.ChaincodeStubInterface, overrides RuleThresholds) error {
+	key := ruleThresholdsKey(overrides.AssetType, overrides.AssetID)
+	overridesBytes, err := json.Marshal(overrides)
+	if err != nil {
+		err = fmt.Errorf("Failed to marshal %s: %s", key, err)
+		log.Critical(err)
+		return err
+	}
+	err = stub.PutState(key, overridesBytes)
+	if err != nil {
+		err = fmt.Errorf("Failed to PUTSTATE %s: %s", key, err)
+		log.Critical(err)
+		return err
+	}
+	return nil
+}
+
+// effectiveThresholds layers the defaults of the rules, the asset type's
+// overrides and the asset's overrides
+func effectiveThresholds(stub shim.ChaincodeStubInterface, rules Rules, assetType string, assetID string) (map[string]float64, error) {
+	thresholds := make(map[string]float64)
+	// rules are applied in order, the first default given for a name is kept
+	for i := range rules.Rules {
+		names := make([]string, 0, len(rules.Rules[i].Thresholds))
+		for name := range rules.Rules[i].Thresholds {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if _, found := thresholds[name]; !found {
+				thresholds[name] = rules.Rules[i].Thresholds[name]
+			}
+		}
+	}
+	typeOverrides, err := GETRuleThresholdsFromLedger(stub, assetType, "")
+	if err != nil {
+		return nil, err
+	}
+	for name, v := range typeOverrides.Thresholds {
+		thresholds[name] = v
+	}
+	if assetID == "" {
+		return thresholds, nil
+	}
+	assetOverrides, err := GETRuleThresholdsFromLedger(stub, assetType, assetID)
+	if err != nil {
+		return nil, err
+	}
+	for name, v := range assetOverrides.Thresholds {
+		thresholds[name] = v
+	}
+	return thresholds, nil
+}
+
+func init() {
+	registerMigration(Migration{Name: "assetThresholdKeys", From: "1.3", To: "1.4",
+		run: migrateAssetThresholdKeys})
+}
+
+// migrateAssetThresholdKeys moves each asset's overrides from its asset~<assetID>
+// key, which every asset with the ID shared, to the key of each active or
+// deleted asset with the ID. Overrides of an ID that no asset has are dropped
+// and listed in the record of the migration.
+func migrateAssetThresholdKeys(stub shim.ChaincodeStubInterface, state *ContractState, record *MigrationRecord) error {
+	prefix := RULETHRESHOLDSKEYPREFIX + "asset~"
+	iter, err := stub.RangeQueryState(prefix, prefix+"\U0010FFFF")
+	if err != nil {
+		return fmt.Errorf("rule thresholds range query failed: %s", err)
+	}
+	legacy := make(map[string]RuleThresholds)
+	for iter.HasNext() {
+		key, overridesBytes, err := iter.Next()
+		if err != nil {
+			iter.Close()
+			return fmt.Errorf("rule thresholds iteration failed: %s", err)
+		}
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		var overrides RuleThresholds
+		err = json.Unmarshal(overridesBytes, &overrides)
+		if err != nil {
+			iter.Close()
+			return fmt.Errorf("%s failed to unmarshal: %s", key, err)
+		}
+		if overrides.AssetType == "" {
+			legacy[key] = overrides
+		}
+	}
+	iter.Close()
+
+	types, err := GETAssetTypesFromLedger(stub)
+	if err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(legacy))
+	for key := range legacy {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		overrides := legacy[key]
+		moved := false
+		for _, at := range types.Types {
+			sAssetKey := overrides.AssetID + "_" + at.Name
+			if !assetIsActive(stub, sAssetKey) && !assetIsDeleted(stub, sAssetKey) {
+				continue
+			}
+			overrides.AssetType = at.Name
+			err = PUTRuleThresholdsToLedger(stub, overrides)
+			if err != nil {
+				return err
+			}
+			moved = true
+		}
+		if !moved {
+			before, _ := stub.GetState(key)
+			log.Warningf("migrateAssetThresholdKeys no asset has ID %s, its overrides are dropped", overrides.AssetID)
+			record.Adjustments = append(record.Adjustments, MigrationAdjustment{key, string(before), ""})
+		}
+		err = stub.DelState(key)
+		if err != nil {
+			return fmt.Errorf("Failed to DELSTATE %s: %s", key, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// activeAlerts returns the committed active alerts of an asset
+func (s *memStub) activeAlerts(sAssetKey string) []interface{} {
+	s.t.Helper()
+	alerts, _ := s.stateMap(sAssetKey)["alerts"].(map[string]interface{})
+	active, _ := alerts["active"].([]interface{})
+	return active
+}
+
+// TestAssetThresholdsByType gives a motor and a smartplug the same ID, an
+// override of the motor's threshold must leave the smartplug alone
+func TestAssetThresholdsByType(t *testing.T) {
+	s := newMemStub(t)
+	s.as("tech")
+	s.mustInvoke("createAsset", `{"assetID":"c1","assettype":"motor","rpm":100,"max_rpm":1000}`)
+	s.mustInvoke("createAsset", `{"assetID":"c1","assettype":"smartplug","rpm":100,"max_rpm":1000}`)
+
+	// the ID alone is ambiguous
+	s.asAdmin().mustFailInvoke("setRuleThresholds", `{"assetID":"c1","thresholds":{"`+MINRPMPERCENT+`":5}}`)
+	s.mustFailInvoke("setRuleThresholds", `{"assetID":"c2","thresholds":{"`+MINRPMPERCENT+`":5}}`)
+	s.mustInvoke("setRuleThresholds", `{"assetID":"c1","assettype":"motor","thresholds":{"`+MINRPMPERCENT+`":5}}`)
+
+	s.as("tech")
+	s.mustInvoke("updateAsset", `{"assetID":"c1","assettype":"motor","rpm":100}`)
+	s.mustInvoke("updateAsset", `{"assetID":"c1","assettype":"smartplug","rpm":100}`)
+	if active := s.activeAlerts("c1_motor"); len(active) != 0 {
+		t.Errorf("motor c1 has alerts %v under its own threshold", active)
+	}
+	if active := s.activeAlerts("c1_smartplug"); len(active) != 1 {
+		t.Errorf("smartplug c1 has alerts %v, the motor's threshold was applied to it", active)
+	}
+
+	read := s.mustRead("readRuleThresholds", `{"assetID":"c1","assettype":"smartplug"}`).(map[string]interface{})
+	if thresholds, _ := read["thresholds"].(map[string]interface{}); len(thresholds) != 0 {
+		t.Errorf("smartplug c1 reads overrides %v, want none", thresholds)
+	}
+	read = s.mustRead("readRuleThresholds", `{"assetID":"c1","assettype":"motor"}`).(map[string]interface{})
+	if read[ASSETTYPE] != "motor" || read["thresholds"].(map[string]interface{})[MINRPMPERCENT] != 5.0 {
+		t.Errorf("motor c1 reads %v, want its override of %s", read, MINRPMPERCENT)
+	}
+}
+
+// TestLegacyThresholdKeys upgrades a ledger whose asset overrides are keyed by
+// assetID alone
+func TestLegacyThresholdKeys(t *testing.T) {
+	s := newMemStub(t)
+	s.as("tech")
+	s.mustInvoke("createAsset", `{"assetID":"c1","assettype":"motor","rpm":100,"max_rpm":1000}`)
+	s.mustInvoke("createAsset", `{"assetID":"c1","assettype":"smartplug","rpm":100,"max_rpm":1000}`)
+	var state ContractState
+	err := json.Unmarshal(s.state[CONTRACTSTATEKEY], &state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.Version = "1.3"
+	s.state[CONTRACTSTATEKEY], _ = json.Marshal(state)
+	legacy := RULETHRESHOLDSKEYPREFIX + "asset~c1"
+	s.state[legacy] = []byte(`{"assetID":"c1","thresholds":{"` + MINRPMPERCENT + `":5}}`)
+	gone := RULETHRESHOLDSKEYPREFIX + "asset~c9"
+	s.state[gone] = []byte(`{"assetID":"c9","thresholds":{"` + MINRPMPERCENT + `":5}}`)
+	s.mustInit()
+
+	for _, assetType := range []string{"motor", "smartplug"} {
+		overrides := s.stateMap(ruleThresholdsKey(assetType, "c1"))
+		if overrides[ASSETTYPE] != assetType || overrides["thresholds"].(map[string]interface{})[MINRPMPERCENT] != 5.0 {
+			t.Errorf("c1 of type %s has overrides %v, want the legacy ones", assetType, overrides)
+		}
+	}
+	if s.state[legacy] != nil || s.state[gone] != nil {
+		t.Error("legacy asset overrides are still on the ledger")
+	}
+	err = json.Unmarshal(s.state[CONTRACTSTATEKEY], &state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record := state.Migrations[len(state.Migrations)-1]
+	want := []MigrationAdjustment{{gone, `{"assetID":"c9","thresholds":{"` + MINRPMPERCENT + `":5}}`, ""}}
+	if record.Name != "assetThresholdKeys" || !reflect.DeepEqual(record.Adjustments, want) {
+		t.Errorf("last migration is %s with adjustments %v, want assetThresholdKeys dropping c9", record.Name, record.Adjustments)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//***************************************************
+//* DELETED ASSETS
+//***************************************************
+
+// Properties of a deleted asset's state, which stays on the ledger as a
+// tombstone with its history
+const (
+	DELETED        string = "deleted"
+	DELETEDAT      string = "deletedAt"
+	DELETEDBY      string = "deletedBy"
+	DELETIONREASON string = "deletionReason"
+)
+
+// deletionArg is the optional reason and caller of a delete
+type deletionArg struct {
+	Reason string `json:"reason"`
+}
+
+// deletionFromArgs reads the reason and the caller of a delete, a caller that
+// cannot be identified is recorded as unknown rather than refusing the delete
+func deletionFromArgs(stub shim.ChaincodeStubInterface, args []string) (string, string) {
+	var arg deletionArg
+	var argsMap ArgsMap
+	if len(args) > 0 {
+		_ = json.Unmarshal([]byte(args[0]), &arg)
+		_ = json.Unmarshal([]byte(args[0]), &argsMap)
+	}
+	caller, err := getCaller(stub, argsMap)
+	if err != nil {
+		log.Warningf("delete recorded without a caller: %s", err)
+		caller = ""
+	}
+	return arg.Reason, caller
+}
+
+// tombstoneAsset marks an asset deleted, moves it from the active to the
+// deleted assets and adds the deletion to its history. It returns the
+// tombstone.
+func tombstoneAsset(stub shim.ChaincodeStubInterface, sAssetKey string, function string, args []string, reason string, caller string) (map[string]interface{}, error) {
+	state, found := ledgerValue(stub, sAssetKey).(map[string]interface{})
+	if !found {
+		return nil, fmt.Errorf("asset %s could not be read", sAssetKey)
+	}
+	state[DELETED] = true
+	state[DELETEDAT] = txTimestamp(stub).Format(time.RFC3339Nano)
+	state[DELETEDBY] = caller
+	state[DELETIONREASON] = reason
+	lastEvent := map[string]interface{}{"function": function}
+	if len(args) > 0 {
+		lastEvent["args"] = args[0]
+	}
+	state["lastEvent"] = lastEvent
+
+	stateJSON, err := json.Marshal(state)
+	if err != nil {
+		return nil, fmt.Errorf("asset %s tombstone marshal failed: %s", sAssetKey, err)
+	}
+	err = stub.PutState(sAssetKey, stateJSON)
+	if err != nil {
+		return nil, fmt.Errorf("asset %s tombstone PUTSTATE failed: %s", sAssetKey, err)
+	}
+
+	err = removeFromIndex(stub, ASSETINDEX, sAssetKey)
+	if err == nil {
+		err = addToIndex(stub, DELETEDASSETINDEX, sAssetKey)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("asset %s failed to move to the deleted assets: %s", sAssetKey, err)
+	}
+
+	err = updateStateHistory(stub, sAssetKey, string(stateJSON))
+	if err != nil {
+		return nil, fmt.Errorf("asset %s push to history failed: %s", sAssetKey, err)
+	}
+	return state, nil
+}
+
+func assetIsDeleted(stub shim.ChaincodeStubInterface, sAssetKey string) bool {
+	return inIndex(stub, DELETEDASSETINDEX, sAssetKey)
+}
+
+func getDeletedAssets(stub shim.ChaincodeStubInterface) ([]string, error) {
+	return indexMembers(stub, DELETEDASSETINDEX)
+}
+
+// readStates reads the states of a list of keys, those that cannot be read are
+// left out
+func readStates(stub shim.ChaincodeStubInterface, function string, keys []string) []interface{} {
+	results := make([]interface{}, 0, len(keys))
+	for _, key := range keys {
+		var state interface{}
+		stateBytes, err := stub.GetState(key)
+		if err == nil {
+			err = json.Unmarshal(stateBytes, &state)
+		}
+		if err != nil {
+			// best efforts, return what we can
+			log.Errorf("%s asset %s could not be read: %s", function, key, err)
+			continue
+		}
+		results = append(results, state)
+	}
+	return results
+}
+
+// ************************************
+// readDeletedAssets
+// ************************************
+func (t *SimpleChaincode) readDeletedAssets(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var err error
+
+	if len(args) > 0 {
+		err = errors.New("readDeletedAssets expects no arguments")
+		log.Error(err)
+		return nil, err
+	}
+	da, err := getDeletedAssets(stub)
+	if err != nil {
+		err = fmt.Errorf("readDeletedAssets failed to get the deleted assets: %s", err)
+		log.Error(err)
+		return nil, err
+	}
+	return json.Marshal(readStates(stub, "readDeletedAssets", da))
+}
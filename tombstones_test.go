@@ -0,0 +1,78 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// assetIDs returns the sorted assetIDs of the states a query returns
+func assetIDs(v interface{}) []string {
+	ids := []string{}
+	states, _ := v.([]interface{})
+	for _, state := range states {
+		id, _ := state.(map[string]interface{})[ASSETID].(string)
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func TestDeleteAsset(t *testing.T) {
+	s := newMemStub(t)
+	s.as("tech")
+	s.mustInvoke("createAsset", `{"assetID":"m1","rpm":900,"max_rpm":1000}`)
+	s.mustInvoke("createAsset", `{"assetID":"m2","rpm":900,"max_rpm":1000}`)
+	s.mustInvoke("deleteAsset", `{"assetID":"m1","reason":"scrapped"}`)
+
+	tombstone := s.stateMap("m1_motor")
+	if tombstone[DELETED] != true || tombstone[DELETEDAT] != s.now.Format(time.RFC3339Nano) ||
+		tombstone[DELETEDBY] != "tech" || tombstone[DELETIONREASON] != "scrapped" || tombstone["rpm"] != 900.0 {
+		t.Errorf("m1 is %v, want its state deleted by tech for scrapped", tombstone)
+	}
+	if assetIsActive(s, "m1_motor") || !assetIsDeleted(s, "m1_motor") {
+		t.Error("m1 did not move from the active to the deleted assets")
+	}
+	if got := assetIDs(s.mustRead("readAllAssets", "")); !reflect.DeepEqual(got, []string{"m2"}) {
+		t.Errorf("readAllAssets = %q, want m2", got)
+	}
+	if got := assetIDs(s.mustRead("readAllAssets", `{"includeDeleted":true}`)); !reflect.DeepEqual(got, []string{"m1", "m2"}) {
+		t.Errorf("readAllAssets with deleted = %q, want m1 and m2", got)
+	}
+	if got := assetIDs(s.mustRead("readDeletedAssets", "")); !reflect.DeepEqual(got, []string{"m1"}) {
+		t.Errorf("readDeletedAssets = %q, want m1", got)
+	}
+	history := s.mustRead("readAssetHistory", `{"assetID":"m1"}`).([]interface{})
+	if len(history) != 2 || history[0].(map[string]interface{})[DELETED] != true {
+		t.Errorf("m1's history is %v, want its tombstone on top of its creation", history)
+	}
+
+	// without create on update, an update cannot bring it back
+	s.asAdmin().mustInvoke("setCreateOnUpdate", `{"createOnUpdate":false}`)
+	s.as("tech")
+	before := s.snapshot()
+	s.mustFailInvoke("updateAsset", `{"assetID":"m1","rpm":800}`)
+	s.mustFailInvoke("deleteAsset", `{"assetID":"m1","reason":"again"}`)
+	s.assertUnchanged(before, "changing a deleted asset")
+
+	// a create brings the asset back with the history it had
+	s.mustInvoke("createAsset", `{"assetID":"m1","rpm":800,"max_rpm":1000}`)
+	if !assetIsActive(s, "m1_motor") || assetIsDeleted(s, "m1_motor") || s.stateMap("m1_motor")[DELETED] != nil {
+		t.Errorf("m1 is %v after it was created again, want it active", s.stateMap("m1_motor"))
+	}
+	if head := s.stateMap("m1_motor" + STATEHISTORYKEY); head["latest"] != 3.0 {
+		t.Errorf("m1's history head is %v, want version 3", head)
+	}
+
+	s.mustInvoke("deleteAllAssets", `{"reason":"decommissioned"}`)
+	if got := assetIDs(s.mustRead("readDeletedAssets", "")); !reflect.DeepEqual(got, []string{"m1", "m2"}) {
+		t.Errorf("readDeletedAssets after deleteAllAssets = %q, want m1 and m2", got)
+	}
+	if got := assetIDs(s.mustRead("readAllAssets", "")); len(got) != 0 {
+		t.Errorf("readAllAssets after deleteAllAssets = %q, want nothing", got)
+	}
+	if reason := s.stateMap("m2_motor")[DELETIONREASON]; reason != "decommissioned" {
+		t.Errorf("m2 was deleted for %v, want decommissioned", reason)
+	}
+}
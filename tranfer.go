@@ -11,59 +11,45 @@ import (
 	"strings"
 	"time"
 	 "sort"
-	 "encoding/gob"
 	 "bytes"
 )
 
 //***************************************************
-const MYVERSION string = "1.0"
+const MYVERSION string = "1.4"
 //***************************************************
 //* CONTRACT initialization and runtime engine
 //***************************************************
 // DEFAULTNICKNAME is used when a contract is initialized without giving it a nickname
 const DEFAULTNICKNAME string = "BUILDING" 
 
-// CONTRACTSTATEKEY is used to store contract state, including version, nickname and activeAssets
+// CONTRACTSTATEKEY is used to store contract state, including version and nickname
 const CONTRACTSTATEKEY string = "ContractStateKey"
 
 // ContractState struct defines contract state. Unlike the main contract maps, structs work fine
-// for this fixed structure.
+// for this fixed structure. The maps are only on the ledger for contracts that have not been
+// migrated to the indexes yet, and are filled in from the indexes by readContractState.
 type ContractState struct {
-	Version      string           `json:"version"`
-    Nickname     string           `json:"nickname"`
-	ActiveAssets map[string]bool  `json:"activeAssets"`
-	ActiveAccounts map[string]bool  `json:"activeAccounts"`
-	IssueAccounts map[string]bool  `json:"IssueAccounts"`
+	Version        string            `json:"version"`
+	Nickname       string            `json:"nickname"`
+	ActiveAssets   map[string]bool   `json:"activeAssets,omitempty"`
+	ActiveAccounts map[string]bool   `json:"activeAccounts,omitempty"`
+	IssueAccounts  map[string]bool   `json:"IssueAccounts,omitempty"`
+	DeletedAssets  map[string]bool   `json:"deletedAssets,omitempty"`
+	Migrations     []MigrationRecord `json:"migrations,omitempty"`
+	InsecureCaller bool              `json:"insecureCaller,omitempty"`
 	//TransferAccounts map[string]bool  `json:"TransferAccounts"`
 }
 
 //*************************************************** Recent 
-// RECENTSTATESKEY is used as key for recent states bucket
+// RECENTSTATESKEY is where the single list of recent states was kept before
+// there was a recent feed for each kind of state
 const RECENTSTATESKEY string = "RecentStatesKey"
 
-// RecentStates is JSON encoded string slice 
-type RecentStates struct {
-    RecentStates []string `json:"recentStates"`
-}
-
 // AssetIDT is assetID as type, used for simple unmarshaling
 type AssetIDT struct {
     ID string `json:"assetID"`
 } 
-// AccountIDT is accountID as type, used for simple unmarshaling
-type AccountIDT struct {
-    ID string `json:"accountID"`
-} 
-
-type IssueIDT struct {
-    ID string `json:"accountID"`
-} 
-
-type TransferIDT struct {
-    ID string `json:"accountID"`
-}
-// MaxRecentStates is an arbitrary limit on how many asset states we track across the 
-// entire contract
+// MaxRecentStates is the depth of a recent feed until setRecentDepth changes it
 const MaxRecentStates int = 20
 ///********************** Map ******************
 var CASESENSITIVEMODE bool = false
@@ -172,7 +158,7 @@ func (t *SimpleChaincode) Init(stub shim.ChaincodeStubInterface, function string
 	log.Info("Entering INIT")
 
 	if len(args) != 1 {
-		err = errors.New("init expects one argument, a JSON string with  mandatory version and optional nickname")
+		err = errors.New("init expects one argument, a JSON string with  mandatory version and optional nickname and insecureCaller")
 		log.Critical(err)
 		return nil, err
 	}
@@ -190,7 +176,19 @@ func (t *SimpleChaincode) Init(stub shim.ChaincodeStubInterface, function string
 
 	(*log).setModule(stateArg.Nickname)
 
-	err = initializeContractState(stub, stateArg.Version, stateArg.Nickname)
+	if stateArg.InsecureCaller {
+		log.Warning("insecureCaller is set, the caller named in an argument will be trusted when there is no accountID certificate attribute")
+	}
+
+	// migration records are stamped with the deploy transaction's time
+	_, err = getTxTimestamp(stub)
+	if err != nil {
+		err = fmt.Errorf("init %s", err)
+		log.Critical(err)
+		return nil, err
+	}
+
+	err = initializeContractState(stub, stateArg.Version, stateArg.Nickname, stateArg.InsecureCaller)
 	if err != nil {
 		return nil, err
 	}
@@ -199,62 +197,16 @@ func (t *SimpleChaincode) Init(stub shim.ChaincodeStubInterface, function string
 	return nil, nil
 }
 
-// Invoke is called in invoke mode to delegate state changing function messages
+// Invoke is called in invoke mode to delegate state changing function messages,
+// see registry.go for the functions
 func (t *SimpleChaincode) Invoke(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
-	if function == "createAsset" {
-		return t.createAsset(stub, args)
-	} else if function == "updateAsset" {
-		return t.updateAsset(stub, args)
-	} else if function == "deleteAsset" {
-		return t.deleteAsset(stub, args)
-	} else if function == "deleteAllAssets" {
-		return t.deleteAllAssets(stub, args)
-	} else if function == "deletePropertiesFromAsset" {
-		return t.deletePropertiesFromAsset(stub, args)
-	} else if function == "setLoggingLevel" {
-		return nil, t.setLoggingLevel(stub, args)
-	} else if function == "setCreateOnUpdate" {
-		return nil, t.setCreateOnUpdate(stub, args)
-	}else if function == "createAccount" {
-		return  t.createAccount(stub, args)
-	}else if function == "issueAsset" {
-		return t.issueAsset(stub, args)
-	}else if function == "transferAsset" {
-		return t.transferAsset(stub, args)
-	}
-	
-	err := fmt.Errorf("Invoke received unknown invocation: %s", function)
-	log.Warning(err)
-	return nil, err
-}
-
-// Query is called in query mode to delegate non-state-changing queries
+	return t.dispatch(stub, INVOKEMODE, function, args)
+}
+
+// Query is called in query mode to delegate non-state-changing queries,
+// see registry.go for the functions
 func (t *SimpleChaincode) Query(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
-	if function == "readAsset" {
-		return t.readAsset(stub, args)
-	} else if function == "readAccount" {
-		return t.readAccount(stub, args)
-	} else if function == "readAllAssets" {
-		return t.readAllAssets(stub, args)
-	} else if function == "readRecentStates" {
-		return readRecentStates(stub)
-	} else if function == "readAssetHistory" {
-		return t.readAssetHistory(stub, args)
-	} else if function == "readContractObjectModel" {
-		return t.readContractObjectModel(stub, args)
-	} else if function == "readContractState" {
-		return t.readContractState(stub, args)
-	} else if function == "readAllAccounts" {
-		return t.readAllAccounts(stub, args)
-	}	else if function == "readAllIssue" {
-		return t.readAllIssue(stub, args)
-	}
-	// To be added
-	/*   else if function == "readAllAssetsOfType" {
-	return t.readAllAssetsOfType(stub, args)*/
-	err := fmt.Errorf("Query received unknown invocation: %s", function)
-	log.Warning(err)
-	return nil, err
+	return t.dispatch(stub, QUERYMODE, function, args)
 }
 
 //***************************************************
@@ -321,6 +273,12 @@ func (t *SimpleChaincode) createAsset(stub shim.ChaincodeStubInterface, args []s
 			return nil, err
 		}
 	}
+	err = checkID(ASSETID, assetID)
+	if err != nil {
+		err = fmt.Errorf("createAsset %s", err)
+		log.Error(err)
+		return nil, err
+	}
 	// Is asset name present?
 	assetTypeBytes, found := getObject(argsMap, ASSETNAME)
 	if found {
@@ -331,10 +289,16 @@ func (t *SimpleChaincode) createAsset(stub shim.ChaincodeStubInterface, args []s
 			return nil, err
 		}
 	}
-	if strings.Contains(assetName, "Plug") {
-		assetType = "smartplug"
-	} else {
-		assetType = "motor"
+	at, err := resolveAssetType(stub, argsMap, assetID)
+	if err != nil {
+		err = fmt.Errorf("createAsset %s", err)
+		log.Error(err)
+		return nil, err
+	}
+	assetType = at.Name
+	// record the resolved type so that readers do not have to infer it again
+	if _, found := getObject(argsMap, ASSETTYPE); !found {
+		argsMap[ASSETTYPE] = assetType
 	}
 
 	log.Info(assetType)
@@ -345,27 +309,36 @@ func (t *SimpleChaincode) createAsset(stub shim.ChaincodeStubInterface, args []s
 		log.Error(err)
 		return nil, err
 	}
+	// devices and holdings share the ledger's keys
+	if issueAccountIsActive(stub, sAssetKey) {
+		err := fmt.Errorf("createAsset asset %s of type %s would overwrite the holding %s", assetID, assetType, sAssetKey)
+		log.Error(err)
+		return nil, err
+	}
 
 	// For now, timestamp is being sent in from the invocation to the contract
 	// Once the BlueMix instance supports GetTxnTimestamp, we will incorporate the
 	// changes to the contract
 
+	err = validateAssetState(at, assetID, argsMap)
+	if err != nil {
+		log.Errorf("createAsset %s", err)
+		return nil, err
+	}
+
 	// run the rules and raise or clear alerts
 	alerts := newAlertStatus()
-	if argsMap.executeRules(&alerts) {
-		// NOT compliant!
-		log.Noticef("createAsset assetID %s of type %s is noncompliant", assetID, assetType)
-		argsMap["alerts"] = alerts
-		delete(argsMap, "incompliance")
-	} else {
-		if alerts.AllClear() {
-			// all false, no need to appear
-			delete(argsMap, "alerts")
-		} else {
-			argsMap["alerts"] = alerts
-		}
-		argsMap["incompliance"] = true
+	compliance, err := argsMap.executeRules(stub, at, &alerts)
+	if err != nil {
+		err = fmt.Errorf("createAsset assetID %s of type %s rules failed: %s", assetID, assetType, err)
+		log.Error(err)
+		return nil, err
 	}
+	if compliance.Status != COMPLIANCECOMPLIANT {
+		log.Noticef("createAsset assetID %s of type %s is %s", assetID, assetType, compliance.Status)
+	}
+	// a new asset has no prior compliance, whatever the caller sent
+	setCompliance(stub, argsMap, Compliance{}, compliance, alerts)
 
 	// copy incoming event to outgoing state
 	// this contract respects the fact that createAsset can accept a partial state
@@ -411,7 +384,7 @@ func (t *SimpleChaincode) createAsset(stub shim.ChaincodeStubInterface, args []s
 		return nil, err
 	}
 
-	err = pushRecentState(stub, string(stateJSON),"0")
+	err = pushRecentState(stub, RECENTDEVICES, string(stateJSON))
 	if err != nil {
 		err = fmt.Errorf("createAsset AssetID %s of type %s push to recentstates failed: %s", assetID, assetType, err)
 		log.Error(err)
@@ -476,6 +449,14 @@ func (t *SimpleChaincode) updateAsset(stub shim.ChaincodeStubInterface, args []s
 		return nil, err
 	}
 
+	// how the arg changes the state, these properties are not part of it
+	mode, err := updateModeFromArgs(argsMap)
+	if err != nil {
+		err = fmt.Errorf("updateAsset %s", err)
+		log.Error(err)
+		return nil, err
+	}
+
 	// is assetID present or blank?
 	assetIDBytes, found := getObject(argsMap, ASSETID)
 	if found {
@@ -498,20 +479,28 @@ func (t *SimpleChaincode) updateAsset(stub shim.ChaincodeStubInterface, args []s
 			return nil, err
 		}
 	}
-	if strings.Contains(assetName, "Plug") {
-		assetType = "smartplug"
-	} else {
-		assetType = "motor"
+	at, err := resolveAssetType(stub, argsMap, assetID)
+	if err != nil {
+		err = fmt.Errorf("updateAsset %s", err)
+		log.Error(err)
+		return nil, err
 	}
+	assetType = at.Name
 	log.Noticef("updateAsset found assetID %s of type %s ", assetID, assetType)
 
 	sAssetKey := assetID + "_" + assetType
 	found = assetIsActive(stub, sAssetKey)
 	if !found {
 		// redirect to createAsset with same parameter list
-		if canCreateOnUpdate(stub) {
+		if canCreateOnUpdate(stub) && mode.merge != MERGEJSONPATCH {
 			log.Noticef("updateAsset redirecting asset %s of type %s to createAsset", assetID, assetType)
-			var newArgs = []string{args[0], "updateAsset"}
+			createJSON, err := json.Marshal(argsMap)
+			if err != nil {
+				err = fmt.Errorf("updateAsset asset %s of type %s marshal for createAsset failed: %s", assetID, assetType, err)
+				log.Error(err)
+				return nil, err
+			}
+			var newArgs = []string{string(createJSON), "updateAsset"}
 			return t.createAsset(stub, newArgs)
 		}
 		err = fmt.Errorf("updateAsset asset %s of type %s does not exist", assetID, assetType)
@@ -546,16 +535,29 @@ func (t *SimpleChaincode) updateAsset(stub shim.ChaincodeStubInterface, args []s
 		return nil, err
 	}
 
-	// now add incoming map values to existing state to merge them
+	// now apply the incoming event to the existing state as its mergeMode says
 	// this contract respects the fact that updateAsset can accept a partial state
 	// as the moral equivalent of one or more discrete events
 	// further: this contract understands that its schema has two discrete objects
 	// that are meant to be used to send events: common, and custom
 	// ledger has to have common section
-	stateOut := deepMerge(map[string]interface{}(argsMap),
+	priorCompliance := complianceFromMap(ledgerMap)
+	stateOut, err := mergeState(mode, map[string]interface{}(argsMap),
 		map[string]interface{}(ledgerMap))
+	if err != nil {
+		err = fmt.Errorf("updateAsset assetID %s of type %s %s", assetID, assetType, err)
+		log.Error(err)
+		return nil, err
+	}
 	log.Debugf("updateAsset assetID %s merged state: %s of type %s", assetID, assetType, stateOut)
 
+	// the merged state is what must satisfy the schema, not the partial event
+	err = validateAssetState(at, assetID, stateOut)
+	if err != nil {
+		log.Errorf("updateAsset %s", err)
+		return nil, err
+	}
+
 	// handle compliance section
 	alerts := newAlertStatus()
 	a, found := stateOut["alerts"] // is there an existing alert state?
@@ -568,21 +570,17 @@ func (t *SimpleChaincode) updateAsset(stub shim.ChaincodeStubInterface, args []s
 		alerts.alertStatusFromMap(a.(map[string]interface{}))
 	}
 	// important: rules need access to the entire calculated state
-	if ledgerMap.executeRules(&alerts) {
-		// true means noncompliant
-		log.Noticef("updateAsset assetID %s of type %s is noncompliant", assetID, assetType)
-		// update ledger with new state, if all clear then delete
-		stateOut["alerts"] = alerts
-		delete(stateOut, "incompliance")
-	} else {
-		if alerts.AllClear() {
-			// all false, no need to appear
-			delete(stateOut, "alerts")
-		} else {
-			stateOut["alerts"] = alerts
-		}
-		stateOut["incompliance"] = true
+	stateMap := ArgsMap(stateOut)
+	compliance, err := stateMap.executeRules(stub, at, &alerts)
+	if err != nil {
+		err = fmt.Errorf("updateAsset assetID %s of type %s rules failed: %s", assetID, assetType, err)
+		log.Error(err)
+		return nil, err
 	}
+	if compliance.Status != priorCompliance.Status {
+		log.Noticef("updateAsset assetID %s of type %s is now %s", assetID, assetType, compliance.Status)
+	}
+	setCompliance(stub, stateOut, priorCompliance, compliance, alerts)
 
 	// save the original event
 	stateOut["lastEvent"] = make(map[string]interface{})
@@ -590,7 +588,7 @@ func (t *SimpleChaincode) updateAsset(stub shim.ChaincodeStubInterface, args []s
 	stateOut["lastEvent"].(map[string]interface{})["args"] = args[0]
 
 	// Write the new state to the ledger
-	stateJSON, err := json.Marshal(ledgerMap)
+	stateJSON, err := json.Marshal(stateOut)
 	if err != nil {
 		err = fmt.Errorf("updateAsset AssetID %s of type %s marshal failed: %s", assetID, assetType, err)
 		log.Error(err)
@@ -604,7 +602,7 @@ func (t *SimpleChaincode) updateAsset(stub shim.ChaincodeStubInterface, args []s
 		log.Error(err)
 		return nil, err
 	}
-	err = pushRecentState(stub, string(stateJSON),"0")
+	err = pushRecentState(stub, RECENTDEVICES, string(stateJSON))
 	if err != nil {
 		err = fmt.Errorf("updateAsset AssetID %s push to recentstates failed: %s", assetID, err)
 		log.Error(err)
@@ -682,11 +680,13 @@ func (t *SimpleChaincode) deleteAsset(stub shim.ChaincodeStubInterface, args []s
 			return nil, err
 		}
 	}
-	if strings.Contains(assetName, "Plug") {
-		assetType = "smartplug"
-	} else {
-		assetType = "motor"
+	at, err := resolveAssetType(stub, argsMap, assetID)
+	if err != nil {
+		err = fmt.Errorf("deleteAsset %s", err)
+		log.Error(err)
+		return nil, err
 	}
+	assetType = at.Name
 	sAssetKey := assetID + "_" + assetType
 	found = assetIsActive(stub, sAssetKey)
 	if !found {
@@ -695,33 +695,23 @@ func (t *SimpleChaincode) deleteAsset(stub shim.ChaincodeStubInterface, args []s
 		return nil, err
 	}
 
-	// Delete the key / asset from the ledger
-	err = stub.DelState(sAssetKey)
-	if err != nil {
-		log.Errorf("deleteAsset assetID %s of type %s failed DELSTATE", assetID, assetType)
-		return nil, err
-	}
-	// remove asset from contract state
-	err = removeAssetFromContractState(stub, sAssetKey)
-	if err != nil {
-		err := fmt.Errorf("deleteAsset asset %s of type %s failed to remove asset from contract state: %s", assetID, assetType, err)
-		log.Critical(err)
-		return nil, err
-	}
-	// save state history
-	err = deleteStateHistory(stub, sAssetKey)
+	// the asset stays on the ledger as a tombstone, its history is kept
+	before := ledgerValue(stub, sAssetKey)
+	reason, caller := deletionFromArgs(stub, args)
+	tombstone, err := tombstoneAsset(stub, sAssetKey, "deleteAsset", args, reason, caller)
 	if err != nil {
-		err := fmt.Errorf("deleteAsset asset %s of type %s state history delete failed: %s", assetID, assetType, err)
+		err = fmt.Errorf("deleteAsset asset %s of type %s %s", assetID, assetType, err)
 		log.Critical(err)
 		return nil, err
 	}
 	// push the recent state
 	err = removeAssetFromRecentState(stub, sAssetKey)
 	if err != nil {
-		err := fmt.Errorf("deleteAsset asset %s recent state removal failed: %s", assetID, assetType, err)
+		err := fmt.Errorf("deleteAsset asset %s of type %s recent state removal failed: %s", assetID, assetType, err)
 		log.Critical(err)
 		return nil, err
 	}
+	emitEvent(stub, EVENTASSETDELETED, sAssetKey, before, tombstone)
 
 	return nil, nil
 }
@@ -782,11 +772,13 @@ func (t *SimpleChaincode) deletePropertiesFromAsset(stub shim.ChaincodeStubInter
 			return nil, err
 		}
 	}
-	if strings.Contains(assetName, "Plug") {
-		assetType = "smartplug"
-	} else {
-		assetType = "motor"
+	at, err := resolveAssetType(stub, argsMap, assetID)
+	if err != nil {
+		err = fmt.Errorf("deletePropertiesFromAsset %s", err)
+		log.Error(err)
+		return nil, err
 	}
+	assetType = at.Name
 	sAssetKey := assetID + "_" + assetType
 
 	found = assetIsActive(stub, sAssetKey)
@@ -803,11 +795,13 @@ func (t *SimpleChaincode) deletePropertiesFromAsset(stub shim.ChaincodeStubInter
 		qprops, found = qpropsBytes.([]interface{})
 		log.Debugf("deletePropertiesFromAsset qProps: %+v, Found: %+v, Type: %+v", qprops, found, reflect.TypeOf(qprops))
 		if !found || len(qprops) < 1 {
-			log.Errorf("deletePropertiesFromAsset asset %s of type %s qualPropsToDelete is not an array or is empty", assetID, assetType)
+			err = fmt.Errorf("deletePropertiesFromAsset asset %s of type %s qualPropsToDelete is not an array or is empty", assetID, assetType)
+			log.Error(err)
 			return nil, err
 		}
 	} else {
-		log.Errorf("deletePropertiesFromAsset asset %s of type %s has no qualPropsToDelete argument", assetID, assetType)
+		err = fmt.Errorf("deletePropertiesFromAsset asset %s of type %s has no qualPropsToDelete argument", assetID, assetType)
+		log.Error(err)
 		return nil, err
 	}
 
@@ -838,56 +832,48 @@ func (t *SimpleChaincode) deletePropertiesFromAsset(stub shim.ChaincodeStubInter
 		return nil, err
 	}
 
-	// now remove properties from state, they are qualified by level
-OUTERDELETELOOP:
+	priorCompliance := complianceFromMap(ledgerMap)
+
+	// now remove properties from state, each is a path that may select many
+	protected := map[string]interface{}{ASSETID: ledgerMap[ASSETID], ASSETTYPE: ledgerMap[ASSETTYPE]}
 	for p := range qprops {
-		prop := qprops[p].(string)
+		prop, _ := qprops[p].(string)
+		path, err := parsePath(prop)
+		if err != nil {
+			err = fmt.Errorf("deletePropertiesFromAsset AssetID %s of type %s %s", assetID, assetType, err)
+			log.Error(err)
+			return nil, err
+		}
+		if (CASESENSITIVEMODE && (path.last() == ASSETID || path.last() == ASSETTYPE)) ||
+			(!CASESENSITIVEMODE && (strings.EqualFold(path.last(), ASSETID) || strings.EqualFold(path.last(), ASSETTYPE))) {
+			log.Warningf("deletePropertiesFromAsset AssetID %s of type %s cannot delete protected qualified property: %s", assetID, assetType, prop)
+			continue
+		}
 		log.Debugf("deletePropertiesFromAsset AssetID %s of type %s deleting qualified property: %s", assetID, assetType, prop)
-		// TODO Ugly, isolate in a function at some point
-		if (CASESENSITIVEMODE && strings.HasSuffix(prop, ASSETID)) ||
-			(!CASESENSITIVEMODE && strings.HasSuffix(strings.ToLower(prop), strings.ToLower(ASSETID)) ||
-				CASESENSITIVEMODE && strings.HasSuffix(prop, ASSETTYPE)) ||
-			(!CASESENSITIVEMODE && strings.HasSuffix(strings.ToLower(prop), strings.ToLower(ASSETTYPE))) {
-			log.Warningf("deletePropertiesFromAsset AssetID %s of type %s cannot delete protected qualified property: %s or type %s", assetID, assetType, prop)
-		} else {
-			levels := strings.Split(prop, ".")
-			lm := (map[string]interface{})(ledgerMap)
-			for l := range levels {
-				// lev is the name of a level
-				lev := levels[l]
-				if l == len(levels)-1 {
-					// we're here, delete the actual property name from this level of the map
-					levActual, found := findMatchingKey(lm, lev)
-					if !found {
-						log.Warningf("deletePropertiesFromAsset AssetID %s of type %s property match %s not found", assetID, assetType, lev)
-						continue OUTERDELETELOOP
-					}
-					log.Debugf("deletePropertiesFromAsset AssetID %s of type %s deleting %s", assetID, assetType, prop)
-					delete(lm, levActual)
-				} else {
-					// navigate to the next level object
-					log.Debugf("deletePropertiesFromAsset AssetID %s of type %s navigating to level %s", assetID, assetType, lev)
-					lmBytes, found := findObjectByKey(lm, lev)
-					if found {
-						lm, found = lmBytes.(map[string]interface{})
-						if !found {
-							log.Noticef("deletePropertiesFromAsset AssetID %s of type %s level %s not found in ledger", assetID, assetType, lev)
-							continue OUTERDELETELOOP
-						}
-					}
-				}
-			}
+		_, removed := path.remove(map[string]interface{}(ledgerMap))
+		if removed == 0 {
+			log.Warningf("deletePropertiesFromAsset AssetID %s of type %s property match %s not found", assetID, assetType, prop)
+		}
+	}
+	// a wildcard cannot take the identity of the asset with it
+	for k, v := range protected {
+		if v != nil {
+			ledgerMap[k] = v
 		}
 	}
 	log.Debugf("updateAsset AssetID %s final state: %s of type %s ", assetID, assetType, ledgerMap)
+	err = validateAssetState(at, assetID, ledgerMap)
+	if err != nil {
+		log.Errorf("deletePropertiesFromAsset %s", err)
+		return nil, err
+	}
 
-	// set timestamp
-	// TODO timestamp from the stub - GetTxnTimestamp
-	ledgerMap[TIMESTAMP] = time.Now()
+	// set timestamp, from the transaction so that every peer writes the same one
+	ledgerMap[TIMESTAMP] = txTimestamp(stub)
 
 	// handle compliance section
 	alerts = newAlertStatus()
-	a, found := argsMap["alerts"] // is there an existing alert state?
+	a, found := ledgerMap["alerts"] // is there an existing alert state?
 	if found {
 		// convert to an AlertStatus, which does not work by type assertion
 		log.Debugf("deletePropertiesFromAsset Found existing alerts state: %s", a)
@@ -897,21 +883,16 @@ OUTERDELETELOOP:
 		alerts.alertStatusFromMap(a.(map[string]interface{}))
 	}
 	// important: rules need access to the entire calculated state
-	if ledgerMap.executeRules(&alerts) {
-		// true means noncompliant
-		log.Noticef("deletePropertiesFromAsset assetID %s of type %s is noncompliant", assetID, assetType)
-		// update ledger with new state, if all clear then delete
-		ledgerMap["alerts"] = alerts
-		delete(ledgerMap, "incompliance")
-	} else {
-		if alerts.AllClear() {
-			// all false, no need to appear
-			delete(ledgerMap, "alerts")
-		} else {
-			ledgerMap["alerts"] = alerts
-		}
-		ledgerMap["incompliance"] = true
+	compliance, err := ledgerMap.executeRules(stub, at, &alerts)
+	if err != nil {
+		err = fmt.Errorf("deletePropertiesFromAsset assetID %s of type %s rules failed: %s", assetID, assetType, err)
+		log.Error(err)
+		return nil, err
 	}
+	if compliance.Status != priorCompliance.Status {
+		log.Noticef("deletePropertiesFromAsset assetID %s of type %s is now %s", assetID, assetType, compliance.Status)
+	}
+	setCompliance(stub, ledgerMap, priorCompliance, compliance, alerts)
 
 	// save the original event
 	ledgerMap["lastEvent"] = make(map[string]interface{})
@@ -933,7 +914,7 @@ OUTERDELETELOOP:
 		log.Error(err)
 		return nil, err
 	}
-	err = pushRecentState(stub, string(stateJSON),"0")
+	err = pushRecentState(stub, RECENTDEVICES, string(stateJSON))
 	if err != nil {
 		err = fmt.Errorf("deletePropertiesFromAsset AssetID %s of type %s push to recentstates failed: %s", assetID, assetType, err)
 		log.Error(err)
@@ -958,12 +939,13 @@ func (t *SimpleChaincode) deleteAllAssets(stub shim.ChaincodeStubInterface, args
 	var sAssetKey string
 	var err error
 
-	if len(args) > 0 {
-		err = errors.New("Too many arguments. Expecting none.")
+	if len(args) > 1 {
+		err = errors.New("Too many arguments. Expecting none, or one JSON object with a reason.")
 		log.Error(err)
 		return nil, err
 	}
 
+	reason, caller := deletionFromArgs(stub, args)
 	aa, err := getActiveAssets(stub)
 	if err != nil {
 		err = fmt.Errorf("deleteAllAssets failed to get the active assets: %s", err)
@@ -973,29 +955,17 @@ func (t *SimpleChaincode) deleteAllAssets(stub shim.ChaincodeStubInterface, args
 	for i := range aa {
 		sAssetKey = aa[i]
 
-		// Delete the key / asset from the ledger
-		err = stub.DelState(sAssetKey)
-		if err != nil {
-			err = fmt.Errorf("deleteAllAssets arg %d AssetKey %s failed DELSTATE", i, sAssetKey)
-			log.Error(err)
-			return nil, err
-		}
-		// remove asset from contract state
-		err = removeAssetFromContractState(stub, sAssetKey)
-		if err != nil {
-			err = fmt.Errorf("deleteAllAssets asset %s failed to remove asset from contract state: %s", sAssetKey, err)
-			log.Critical(err)
-			return nil, err
-		}
-		// save state history
-		err = deleteStateHistory(stub, sAssetKey)
+		// the asset stays on the ledger as a tombstone, its history is kept
+		before := ledgerValue(stub, sAssetKey)
+		tombstone, err := tombstoneAsset(stub, sAssetKey, "deleteAllAssets", args, reason, caller)
 		if err != nil {
-			err := fmt.Errorf("deleteAllAssets asset %s state history delete failed: %s", sAssetKey, err)
+			err = fmt.Errorf("deleteAllAssets asset %s %s", sAssetKey, err)
 			log.Critical(err)
 			return nil, err
 		}
+		emitEvent(stub, EVENTASSETDELETED, sAssetKey, before, tombstone)
 	}
-	err = clearRecentStates(stub)
+	err = clearRecentStates(stub, RECENTDEVICES)
 	if err != nil {
 		err = fmt.Errorf("deleteAllAssets clearRecentStates failed: %s", err)
 		log.Error(err)
@@ -1061,11 +1031,13 @@ func (t *SimpleChaincode) readAsset(stub shim.ChaincodeStubInterface, args []str
 	}
 	sMsg := "Inside readAsset assetName: " + assetName
 	log.Info(sMsg)
-	if strings.Contains(assetName, "Plug") {
-		assetType = "smartplug"
-	} else {
-		assetType = "motor"
+	at, err := resolveAssetType(stub, argsMap, assetID)
+	if err != nil {
+		err = fmt.Errorf("readAsset %s", err)
+		log.Error(err)
+		return nil, err
 	}
+	assetType = at.Name
 	sMsgTyoe := "Inside readAsset assetType: " + assetType
 	log.Info(sMsgTyoe)
 	sAssetKey := assetID + "_" + assetType
@@ -1091,86 +1063,62 @@ func (t *SimpleChaincode) readAsset(stub shim.ChaincodeStubInterface, args []str
 //*************************************
 
 func (t *SimpleChaincode) readAccount(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
-	var accountID string
-	var accountType string
-	var accountName string
-	var argsMap ArgsMap
-	var request interface{}
-	var assetBytes []byte
-	var found bool
+	var arg AccountStatusChange
 	var err error
 
-	requestBytes := []byte(args[0])
-	fmt.Println("accountType first =", accountType)
-	fmt.Println(requestBytes)
-	log.Debugf("readAccount arg: %s", args[0])
-
-	err = json.Unmarshal(requestBytes, &request)
-	if err != nil {
-		log.Errorf("readAccount failed to unmarshal arg: %s", err)
+	if len(args) != 1 {
+		err = errors.New("readAccount expects one JSON object with an accountID")
+		log.Error(err)
 		return nil, err
 	}
+	log.Debugf("readAccount arg: %s", args[0])
 
-	argsMap, found = request.(map[string]interface{})
-	if !found {
-		err := errors.New("readAccount arg is not a map shape")
+	err = json.Unmarshal([]byte(args[0]), &arg)
+	if err != nil || arg.AccountID == "" {
+		err = fmt.Errorf("readAccount arg must be a JSON object with an accountID: %s", args[0])
 		log.Error(err)
 		return nil, err
 	}
 
-	// is assetID present or blank?
-	assetIDBytes, found := getObject(argsMap, ACCOUNTID)
-	if found {
-		accountID, found = assetIDBytes.(string)
-		if !found || accountID == "" {
-			err := errors.New("readAccount arg does not include accountID")
-			log.Error(err)
-			return nil, err
-		}
-	}
-	// Is asset name present?
-	assetTypeBytes, found := getObject(argsMap, ACCOUNTNAME)
-	if found {
-		accountName, found = assetTypeBytes.(string)
-		if !found || accountName == "" {
-			err := errors.New("createAccount arg does not include accountName ")
-			log.Error(err)
-			return nil, err
-		}
-	}
-	fmt.Println("accountType second =", accountType)
-	sMsg := "Inside readAccount accountName: " + accountName
-	log.Info(sMsg)
-	
-	sMsgTyoe := "Inside readAccount accountType: " + accountType
-	log.Info(sMsgTyoe)
-	fmt.Println("accountType 3rd =", accountType)
-	sAssetKey := accountID + "_" + accountType
-	fmt.Println(sAssetKey)
-	// Get the state from the ledger
-	assetBytes, err = stub.GetState(sAssetKey)
+	// queries cannot write, a legacy account is returned converted and is
+	// moved to its new key by the next invoke that touches it
+	account, found, err := getAccount(stub, arg.AccountID, false)
 	if err != nil {
-		log.Errorf("readAccount accountID %s of type %s failed GETSTATE", accountID, accountType)
+		err = fmt.Errorf("readAccount %s", err)
+		log.Error(err)
 		return nil, err
 	}
-
-	return assetBytes, nil
+	if !found {
+		err = &UnknownAccountError{ACCOUNTID, arg.AccountID}
+		log.Errorf("readAccount %s", err)
+		return nil, err
+	}
+	return json.Marshal(account)
 }
 
 // ************************************
 // readAllAssets
 // ************************************
 func (t *SimpleChaincode) readAllAssets(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
-	var sAssetKey string
+	var filter struct {
+		IncludeDeleted bool `json:"includeDeleted"`
+	}
 	var err error
 	var results []interface{}
-	var state interface{}
 
-	if len(args) > 0 {
-		err = errors.New("readAllAssets expects no arguments")
+	if len(args) > 1 {
+		err = errors.New("readAllAssets expects no arguments, or one JSON object with includeDeleted")
 		log.Error(err)
 		return nil, err
 	}
+	if len(args) == 1 {
+		err = json.Unmarshal([]byte(args[0]), &filter)
+		if err != nil {
+			err = fmt.Errorf("readAllAssets failed to unmarshal arg: %s", err)
+			log.Error(err)
+			return nil, err
+		}
+	}
 
 	aa, err := getActiveAssets(stub)
 	if err != nil {
@@ -1178,25 +1126,16 @@ func (t *SimpleChaincode) readAllAssets(stub shim.ChaincodeStubInterface, args [
 		log.Error(err)
 		return nil, err
 	}
-	results = make([]interface{}, 0, len(aa))
-	for i := range aa {
-		sAssetKey = aa[i]
-		// Get the state from the ledger
-		assetBytes, err := stub.GetState(sAssetKey)
+	if filter.IncludeDeleted {
+		da, err := getDeletedAssets(stub)
 		if err != nil {
-			// best efforts, return what we can
-			log.Errorf("readAllAssets assetID %s failed GETSTATE", sAssetKey)
-			continue
-		} else {
-			err = json.Unmarshal(assetBytes, &state)
-			if err != nil {
-				// best efforts, return what we can
-				log.Errorf("readAllAssets assetID %s failed to unmarshal", sAssetKey)
-				continue
-			}
-			results = append(results, state)
+			err = fmt.Errorf("readAllAssets failed to get the deleted assets: %s", err)
+			log.Error(err)
+			return nil, err
 		}
+		aa = append(aa, da...)
 	}
+	results = readStates(stub, "readAllAssets", aa)
 
 	resultsStr, err := json.Marshal(results)
 	if err != nil {
@@ -1222,7 +1161,7 @@ func (t *SimpleChaincode) readAssetHistory(stub shim.ChaincodeStubInterface, arg
 	var err error
 
 	if len(args) != 1 {
-		err = errors.New("readAssetHistory expects a JSON encoded object with assetID and count")
+		err = errors.New("readAssetHistory expects a JSON encoded object with assetID and count, or from, to, cursor, order and pageSize")
 		log.Error(err)
 		return nil, err
 	}
@@ -1264,47 +1203,54 @@ func (t *SimpleChaincode) readAssetHistory(stub shim.ChaincodeStubInterface, arg
 			return nil, err
 		}
 	}
-	if strings.Contains(assetName, "Plug") {
-		assetType = "smartplug"
-	} else {
-		assetType = "motor"
+	at, err := resolveAssetType(stub, argsMap, assetID)
+	if err != nil {
+		err = fmt.Errorf("readAssetHistory %s", err)
+		log.Error(err)
+		return nil, err
 	}
+	assetType = at.Name
 	sAssetKey := assetID + "_" + assetType
-	found = assetIsActive(stub, sAssetKey)
+	// the history of a deleted asset stays readable
+	found = assetIsActive(stub, sAssetKey) || assetIsDeleted(stub, sAssetKey)
 	if !found {
 		err := fmt.Errorf("readAssetHistory arg asset %s does not exist", assetID)
 		log.Error(err)
 		return nil, err
 	}
 
-	// Get the history from the ledger
-	stateHistory, err := readStateHistory(stub, sAssetKey)
+	var query historyQuery
+	err = json.Unmarshal(requestBytes, &query)
 	if err != nil {
-		err = fmt.Errorf("readAssetHistory assetID %s of type %s failed readStateHistory: %s", assetID, assetType, err)
+		err = fmt.Errorf("readAssetHistory arg has a bad count, from, to, cursor, order or pageSize: %s", err)
 		log.Error(err)
 		return nil, err
 	}
 
-	// is count present?
-	var olen int
-	countBytes, found := getObject(argsMap, "count")
-	if found {
-		olen = int(countBytes.(float64))
+	// Get the history from the ledger
+	stateHistory, err := getStateHistory(stub, sAssetKey)
+	if err != nil {
+		err = fmt.Errorf("readAssetHistory assetID %s of type %s failed to read history: %s", assetID, assetType, err)
+		log.Error(err)
+		return nil, err
 	}
-	if olen <= 0 || olen > len(stateHistory.AssetHistory) {
-		olen = len(stateHistory.AssetHistory)
+	page, err := stateHistory.readPage(query)
+	if err != nil {
+		err = fmt.Errorf("readAssetHistory assetID %s of type %s %s", assetID, assetType, err)
+		log.Error(err)
+		return nil, err
 	}
-	var hStatesOut = make([]interface{}, 0, olen)
-	for i := 0; i < olen; i++ {
-		var obj interface{}
-		err = json.Unmarshal([]byte(stateHistory.AssetHistory[i]), &obj)
-		if err != nil {
-			log.Errorf("readAssetHistory JSON unmarshal of entry %d failed [%#v]", i, stateHistory.AssetHistory[i])
-			return nil, err
+
+	if query.paged() {
+		assetBytes, err = json.Marshal(page)
+	} else {
+		// only count, return the states as always
+		var hStatesOut = make([]json.RawMessage, 0, len(page.Entries))
+		for _, e := range page.Entries {
+			hStatesOut = append(hStatesOut, e.State)
 		}
-		hStatesOut = append(hStatesOut, obj)
+		assetBytes, err = json.Marshal(hStatesOut)
 	}
-	assetBytes, err = json.Marshal(hStatesOut)
 	if err != nil {
 		log.Errorf("readAssetHistory failed to marshal results: %s", err)
 		return nil, err
@@ -1328,15 +1274,18 @@ func (t *SimpleChaincode) readContractState(stub shim.ChaincodeStubInterface, ar
 		return nil, err
 	}
 
-	// Get the state from the ledger
-	chaincodeBytes, err := stub.GetState(CONTRACTSTATEKEY)
+	// Get the state from the ledger, with the members of the indexes
+	state, err := GETContractStateFromLedger(stub)
+	if err == nil {
+		state, err = withIndexes(stub, state)
+	}
 	if err != nil {
-		err = fmt.Errorf("readContractState failed GETSTATE: %s", err)
+		err = fmt.Errorf("readContractState failed: %s", err)
 		log.Error(err)
 		return nil, err
 	}
 
-	return chaincodeBytes, nil
+	return json.Marshal(state)
 }
 
 //***************************************************
@@ -1347,21 +1296,6 @@ func (t *SimpleChaincode) readContractState(stub shim.ChaincodeStubInterface, ar
 
 
 
-// ************************************
-// readContractObjectModel
-// ************************************
-func (t *SimpleChaincode) readContractObjectModel(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
-	var state = ContractState{MYVERSION, DEFAULTNICKNAME, make(map[string]bool),make(map[string]bool),make(map[string]bool)}
-
-	stateJSON, err := json.Marshal(state)
-	if err != nil {
-		err := fmt.Errorf("JSON Marshal failed for get contract object model empty state: %+v with error [%s]", state, err)
-		log.Error(err)
-		return nil, err
-	}
-	return stateJSON, nil
-}
-
 // ************************************
 // setLoggingLevel
 // ************************************
@@ -1459,39 +1393,95 @@ func canCreateOnUpdate(stub shim.ChaincodeStubInterface) bool {
 	}
 	return createOnUpdate.CreateOnUpdate
 }
+
+// CALLER is the JSON tag with which a client names its own account on development
+// networks that run without attribute certificates
+const CALLER string = "caller"
+
+// getCaller returns the account on whose behalf the transaction runs, which is the
+// accountID attribute of the caller's certificate. The caller named in the argument
+// is trusted only when the contract was deployed with insecureCaller set.
+func getCaller(stub shim.ChaincodeStubInterface, argsMap ArgsMap) (string, error) {
+	attr, err := stub.ReadCertAttribute(ACCOUNTID)
+	if err == nil && len(attr) > 0 {
+		return string(attr), nil
+	}
+	state, err := GETContractStateFromLedger(stub)
+	if err != nil {
+		return "", err
+	}
+	if !state.InsecureCaller {
+		return "", errors.New("caller cannot be identified, there is no accountID certificate attribute")
+	}
+	callerBytes, found := getObject(argsMap, CALLER)
+	if found {
+		caller, found := callerBytes.(string)
+		if found && caller != "" {
+			return caller, nil
+		}
+	}
+	return "", errors.New("caller cannot be identified, there is no accountID certificate attribute and no caller in the arg")
+}
+
+// ROLE is the certificate attribute that carries the caller's role
+const ROLE string = "role"
+
+// ADMINROLE is the role of callers who may act on any account
+const ADMINROLE string = "admin"
+
+// callerHasRole is true when the caller's certificate carries the role, the role
+// can never be claimed in an argument
+func callerHasRole(stub shim.ChaincodeStubInterface, role string) bool {
+	attr, err := stub.ReadCertAttribute(ROLE)
+	return err == nil && string(attr) == role
+}
+
+// getTxTimestamp returns the transaction timestamp, which all peers agree on
+func getTxTimestamp(stub shim.ChaincodeStubInterface) (time.Time, error) {
+	ts, err := stub.GetTxTimestamp()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("transaction timestamp is not available: %s", err)
+	}
+	if ts == nil {
+		return time.Time{}, errors.New("transaction has no timestamp")
+	}
+	return time.Unix(ts.Seconds, int64(ts.Nanos)).UTC(), nil
+}
+
+// txTimestamp returns the transaction timestamp. Init and every invoke fail
+// before they run when there is none, so only a query, which writes nothing
+// the peers must agree on, falls back to the local clock.
+func txTimestamp(stub shim.ChaincodeStubInterface) time.Time {
+	ts, err := getTxTimestamp(stub)
+	if err != nil {
+		log.Warningf("txTimestamp falling back to local time: %s", err)
+		return time.Now().UTC()
+	}
+	return ts
+}
 // *********************************** ContractState ***************************************************************
 
 // GETContractStateFromLedger retrieves state from ledger and returns to caller
 func GETContractStateFromLedger(stub shim.ChaincodeStubInterface) (ContractState, error) {
-    var state = ContractState{ MYVERSION, DEFAULTNICKNAME, make(map[string]bool),make(map[string]bool),make(map[string]bool) }
-    var err error
+	var state = ContractState{Version: MYVERSION, Nickname: DEFAULTNICKNAME}
+	var err error
 	contractStateBytes, err := stub.GetState(CONTRACTSTATEKEY)
-    // minimum string is {"version":""} and version cannot be empty 
-	if err == nil && len(contractStateBytes) > 14 {    
-		// apparently, this blockchain instance is being reloaded, has the version changed?
+	// minimum string is {"version":""} and version cannot be empty
+	if err == nil && len(contractStateBytes) > 14 {
+		// apparently, this blockchain instance is being reloaded, the version
+		// stays as written until Init has run the migrations
 		err = json.Unmarshal(contractStateBytes, &state)
 		if err != nil {
-            err = fmt.Errorf("Unmarshal failed for contract state: %s", err)
-            log.Critical(err)
+			err = fmt.Errorf("Unmarshal failed for contract state: %s", err)
+			log.Critical(err)
 			return ContractState{}, err
 		}
-        if MYVERSION != state.Version {
-            log.Noticef("Contract version has changed from %s to %s", state.Version, MYVERSION)
-            state.Version = MYVERSION
-        }
 	} else {
-        // empty state already initialized 
+		// empty state already initialized
 		log.Noticef("Initialized newly deployed contract state version %s", state.Version)
 	}
-    // this MUST be here
-    if state.ActiveAssets == nil {
-        state.ActiveAssets = make(map[string]bool)
-    }
-	 if state.ActiveAccounts == nil {
-        state.ActiveAccounts = make(map[string]bool)
-    }
-    log.Debug("GETContractState successful")
-    return state, nil 
+	log.Debug("GETContractState successful")
+	return state, nil
 }
 
 // PUTContractStateToLedger writes a contract state into the ledger
@@ -1514,67 +1504,46 @@ func PUTContractStateToLedger(stub shim.ChaincodeStubInterface, state ContractSt
     return nil 
 }
 
-func addAssetToContractState(stub shim.ChaincodeStubInterface, sAssetKey string) (error) {
-    var state ContractState
-    var err error
-    state, err = GETContractStateFromLedger(stub)  
-    if err != nil {
-        return err
-    }
-    log.Debugf("Adding asset %s to contract", sAssetKey)
-    state.ActiveAssets[sAssetKey] = true
-    return PUTContractStateToLedger(stub, state)
+func addAssetToContractState(stub shim.ChaincodeStubInterface, sAssetKey string) error {
+	log.Debugf("Adding asset %s to contract", sAssetKey)
+	err := addToIndex(stub, ASSETINDEX, sAssetKey)
+	if err != nil {
+		return err
+	}
+	// an asset created again is no longer deleted
+	return removeFromIndex(stub, DELETEDASSETINDEX, sAssetKey)
 }
 
-func removeAssetFromContractState(stub shim.ChaincodeStubInterface, assetID string) (error) {
-    var state ContractState
-    var err error
-    state, err = GETContractStateFromLedger(stub)  
-    if err != nil {
-        return err
-    }
-    log.Debugf("Deleting asset %s from contract", assetID)
-    delete(state.ActiveAssets, assetID)
-    return PUTContractStateToLedger(stub, state)
+func removeAssetFromContractState(stub shim.ChaincodeStubInterface, assetID string) error {
+	log.Debugf("Deleting asset %s from contract", assetID)
+	return removeFromIndex(stub, ASSETINDEX, assetID)
 }
 
 func getActiveAssets(stub shim.ChaincodeStubInterface) ([]string, error) {
-    var state ContractState
-    var err error
-    state, err = GETContractStateFromLedger(stub)  
-    if err != nil {
-        return []string{}, err
-    }
-    var a = make([]string, len(state.ActiveAssets))
-    i := 0
-    for id := range state.ActiveAssets {
-        a[i] = id
-        i++ 
-    }
-    sort.Strings(a)
-    return a, nil
+	return indexMembers(stub, ASSETINDEX)
 }
 
-func initializeContractState(stub shim.ChaincodeStubInterface, version string, nickname string) (error) {
-    var state ContractState
-    var err error
-    if version != MYVERSION {
-        err = fmt.Errorf("Contract version: %s does not match version argument: %s", MYVERSION, version)
-        log.Critical(err)
-        return err
-    }
-    state, err = GETContractStateFromLedger(stub)
-    if err != nil {
-        return err
-    }  
-    if version != state.Version {
-        log.Noticef("Contract version has changed from %s to %s", version, MYVERSION)
-        // keep going, this is an update of version -- later this will
-        // be handled by pulling state from the superseded contract version
-    }
-    state.Version = MYVERSION
-    state.Nickname = nickname
-    return PUTContractStateToLedger(stub, state)
+func initializeContractState(stub shim.ChaincodeStubInterface, version string, nickname string, insecureCaller bool) error {
+	var state ContractState
+	var err error
+	if version != MYVERSION {
+		err = fmt.Errorf("Contract version: %s does not match version argument: %s", MYVERSION, version)
+		log.Critical(err)
+		return err
+	}
+	state, err = GETContractStateFromLedger(stub)
+	if err != nil {
+		return err
+	}
+	err = runMigrations(stub, &state)
+	if err != nil {
+		log.Critical(err)
+		return err
+	}
+	state.Version = MYVERSION
+	state.Nickname = nickname
+	state.InsecureCaller = insecureCaller
+	return PUTContractStateToLedger(stub, state)
 }
 
 func getLedgerContractVersion(stub shim.ChaincodeStubInterface) (string, error) {
@@ -1587,277 +1556,35 @@ func getLedgerContractVersion(stub shim.ChaincodeStubInterface) (string, error)
     return state.Version, nil   
 }
 
-func assetIsActive(stub shim.ChaincodeStubInterface, sAssetKey string) (bool) {
-    var state ContractState
-    var err error
-    state, err = GETContractStateFromLedger(stub)
-    if err != nil { return false}
-    found, _ := state.ActiveAssets[sAssetKey]
-    return found
+func assetIsActive(stub shim.ChaincodeStubInterface, sAssetKey string) bool {
+	return inIndex(stub, ASSETINDEX, sAssetKey)
 }                      
-//********************************* Recent ***************************************************
-// GETRecentStatesFromLedger returns the unmarshaled recent states
-func GETRecentStatesFromLedger(stub shim.ChaincodeStubInterface) (RecentStates, error) {
-    var state = RecentStates{make([]string, 0, MaxRecentStates)}
-    var err error
-	recentStatesBytes, err := stub.GetState(RECENTSTATESKEY)
-	if err == nil { 
-		err = json.Unmarshal(recentStatesBytes, &state.RecentStates)
-		if err != nil {
-            log.Noticef("Unmarshal failed for recent states: %s", err)
-		}
-	}
-    // this MUST be here
-    if state.RecentStates == nil || len(state.RecentStates) == 0 {
-        state.RecentStates = make([]string, 0, MaxRecentStates)
-    }
-    log.Debugf("GETRecentStates returns: %#v", state)
-    return state, nil 
-}
-
-// PUTRecentStatesToLedger marshals and writes the recent states
-func PUTRecentStatesToLedger(stub shim.ChaincodeStubInterface, state RecentStates) (error) {
-    var recentStatesJSON []byte
-    var err error
-    recentStatesJSON, err = json.Marshal(state.RecentStates)
-    if err != nil {
-        log.Criticalf("Failed to marshal recent states: %s", err)
-        return err
-    }
-    err = stub.PutState(RECENTSTATESKEY, recentStatesJSON)
-    if err != nil {
-        log.Criticalf("Failed to PUTSTATE recent states: %s", err)
-        return err
-    } 
-    log.Debugf("PUTRecentStates: %#v", state)
-    return nil 
-}
-
-func clearRecentStates(stub shim.ChaincodeStubInterface) (error) {
-    var rstates RecentStates
-    rstates.RecentStates = make([]string, 0, MaxRecentStates)
-    return PUTRecentStatesToLedger(stub, rstates)
-}
-
-func pushRecentState (stub shim.ChaincodeStubInterface, state string,isAsset string) (error) {
-    var rstate RecentStates
-    var err error
-    var assetID string
-    
-    assetID, err = getAssetIDFromState(state,isAsset)
-    if err != nil {
-        return err
-    }
-    rstate, err = GETRecentStatesFromLedger(stub)
-    if err != nil {
-        return err
-    }
-    
-    // shift slice to the right
-    assetPosn, err := findAssetInRecent(assetID, rstate) 
-    if err != nil {
-        return err
-    } else if assetPosn == -1 {
-        // grow if not at capacity, since this one is new
-        if len(rstate.RecentStates) < MaxRecentStates {
-            rstate.RecentStates = rstate.RecentStates[0 : len(rstate.RecentStates)+1]
-        }
-        // shift it all since not found
-        copy(rstate.RecentStates[1:], rstate.RecentStates[0:])
-    } else {
-        if len(rstate.RecentStates) > 1 {
-            // shift over top of the same asset, can appear only once
-            copy(rstate.RecentStates[1:], rstate.RecentStates[0:assetPosn])
-        }
-    }
-    rstate.RecentStates[0] = state
-    return PUTRecentStatesToLedger(stub, rstate)
-}
-
-// typically called when an asset is deleted
-func removeAssetFromRecentState (stub shim.ChaincodeStubInterface, assetID string) (error) {
-    var rstate RecentStates
-    var err error
-    rstate, err = GETRecentStatesFromLedger(stub)
-    if err != nil {
-        return err
-    }
-    assetPosn, err := findAssetInRecent(assetID, rstate)
-    if err != nil {
-        return err
-    } else if assetPosn == -1 {
-        // nothing to do
-        return nil
-    } else {
-        if len(rstate.RecentStates) > 0 {
-            // shift slice to the left to close the hole left by the asset
-            copy(rstate.RecentStates[assetPosn:], rstate.RecentStates[assetPosn+1:])
-        }
-        if len(rstate.RecentStates) > 0 {
-            rstate.RecentStates = rstate.RecentStates[0 : len(rstate.RecentStates)-1]
-        }
-    }
-    return PUTRecentStatesToLedger(stub, rstate)
-}
-
-func getAssetIDFromState(state string,isAsset string) (string, error) {
-
-	var err error
-	if isAsset == "0" {
-	     	var substate AssetIDT
-    err = json.Unmarshal([]byte(state), &substate)
-    if err != nil {
-        log.Errorf("getAssetIDFromState state unmarshal to AssetID failed: %s", err)
-        return "", err
-    }
-    if len(substate.ID) == 0 {
-        err = errors.New("getAssetIDFromState substate.common.assetID is blank")
-        log.Error(err)
-        return "", err
-    }
-    	return substate.ID, nil 
-	}else if isAsset == "1"	{ 
-      	var substate AccountIDT
-	
-	err = json.Unmarshal([]byte(state), &substate)
-    if err != nil {
-        log.Errorf("getAssetIDFromState state unmarshal to AssetID failed: %s", err)
-        return "", err
-    }
-    if len(substate.ID) == 0 {
-        err = errors.New("getAssetIDFromState substate.common.assetID is blank")
-        log.Error(err)
-        return "", err
-    }
-   	return substate.ID, nil 
-	}else if isAsset == "2"	{ 
-      	var substate IssueIDT
-	
-	err = json.Unmarshal([]byte(state), &substate)
-    if err != nil {
-        log.Errorf("getAssetIDFromState state unmarshal to AssetID failed: %s", err)
-        return "", err
-    }
-    if len(substate.ID) == 0 {
-        err = errors.New("IssueID is blank")
-        log.Error(err)
-        return "", err
-    }
-   	return substate.ID, nil 
-	}else if isAsset == "3"	{ 
-      	var substate TransferIDT
-	
-	err = json.Unmarshal([]byte(state), &substate)
-    if err != nil {
-        log.Errorf("getAssetIDFromState state unmarshal to AssetID failed: %s", err)
-        return "", err
-    }
-    if len(substate.ID) == 0 {
-        err = errors.New("TransferID is blank")
-        log.Error(err)
-        return "", err
-    }
-   	return substate.ID, nil 
-	}
-   	return "blank", nil 
-}
-
-func findAssetInRecent (assetID string, rstate RecentStates) (int, error) {
-    // returns -1 to signify not found (or error)
-    var err error
-    var substate AssetIDT
-    for i := 0; i < len(rstate.RecentStates); i++ {
-        err = json.Unmarshal([]byte(rstate.RecentStates[i]), &substate)
-        if err != nil {
-            log.Errorf("findAssetInRecent JSON unmarshal of entry %d failed [%#v]", i, rstate.RecentStates[i])
-            return -1, err
-        }
-        if substate.ID == assetID {
-        	log.Debugf("findAssetInRecent found assetID %s at position %d in recent states", assetID, i)
-            return i, nil
-        }
-    }
-    // not found
-    log.Debugf("findAssetInRecent Did not find assetID %s in recent states", assetID)
-    return -1, nil
-}
-
-func readRecentStates(stub shim.ChaincodeStubInterface) ([]byte, error) {
-	var err error
-    var rstate RecentStates
-    var rstateOut = make([]interface{}, 0, MaxRecentStates) 
-
-	// Get the recent states from the ledger
-    rstate, err = GETRecentStatesFromLedger(stub)
-    if err != nil {
-        return nil, err
-    }
-    for i := 0; i < len(rstate.RecentStates); i++ {
-        var obj interface{}
-        err = json.Unmarshal([]byte(rstate.RecentStates[i]), &obj)
-        if err != nil {
-            log.Errorf("findAssetInRecent JSON unmarshal of entry %d failed [%#v]", i, rstate.RecentStates[i])
-            return nil, err
-        }
-        rstateOut = append(rstateOut, obj)
-    }
-    rsBytes, err := json.Marshal(rstateOut)
-    if err != nil {
-        log.Errorf("readRecentStates JSON marshal of result failed [%#v]", rstate.RecentStates)
-        return nil, err
-    }
-	return rsBytes, nil
-}
 //***************************************************Map**********************************
 
-// finds an object by its qualified name, which looks like "location.latitude"
-// as one example. Returns as map[string]interface{} 
-func getObject (objIn interface{}, qname string) (interface{}, bool) {
-    // return a copy of the selected object
-    // handles full qualified name, starting at object's root
-    obj, found := objIn.(map[string]interface{})
-    if !found {
-        obj, found = objIn.(ArgsMap)
-        if !found {
-            log.Errorf("getObject passed a non-map / non-ArgsMap: %#v", objIn)
-            return nil, false
-        }
-    }
-    obj = map[string]interface{}(obj)
-    var returnObj interface{} = obj
-    s := strings.Split(qname, ".")
-    // crawl the levels, skipping the # root
-    for i, v := range s {
-        //fmt.Printf("Prop %d is: %s\n", i, v)
-        if i+1 == len(s) {
-            // last level, has to be here
-            return findObjectByKey(returnObj, v)
-        }
-        returnObj, found = (returnObj.(map[string]interface{})[v]).(map[string]interface{})
-        if !found {
-            log.Debugf("getObject cannot find level: %s", v)
-            return nil, false
-        }
-    }
-    return nil, false
-}
-
-// this small function isolates the getting of the object in case
-// sensitive or case insensitive modes because they are quite different
-// we must not modify the destination key 
-
-func findObjectByKey (objIn interface{}, key string) (interface{}, bool) {
-    objMap, found := objIn.(map[string]interface{})
-    if found {
-        dstKey, found := findMatchingKey(objMap, key)
-        if found {
-            objOut, found := objMap[dstKey]
-            if found { 
-                return objOut, found 
-            }
-        }
-    }
-    return nil, false
+// finds an object by its path, a qualified name which looks like "location.latitude"
+// or "sensors[3].name", or a JSON Pointer such as "/location/latitude". A path with
+// a * wildcard returns the objects it selects as an array.
+func getObject(objIn interface{}, qname string) (interface{}, bool) {
+	switch objIn.(type) {
+	case map[string]interface{}, ArgsMap:
+	default:
+		log.Errorf("getObject passed a non-map / non-ArgsMap: %#v", objIn)
+		return nil, false
+	}
+	path, err := parsePath(qname)
+	if err != nil {
+		log.Errorf("getObject %s", err)
+		return nil, false
+	}
+	objs := path.values(objIn)
+	if path.hasWildcard() {
+		return objs, len(objs) > 0
+	}
+	if len(objs) == 0 {
+		log.Debugf("getObject cannot find %s", qname)
+		return nil, false
+	}
+	return objs[0], true
 }
 
 // finds a key that matches the incoming key, very useful to remove the 
@@ -1937,69 +1664,41 @@ func contains(arr interface{}, val interface{}) bool {
     return false
 }
 
-// deep merge src into dst and return dst
-func deepMerge(srcIn interface{}, dstIn interface{}) (map[string]interface{}){
-    src, found := srcIn.(map[string]interface{})
-    if !found {
-        log.Criticalf("Deep Merge passed source map of type: %s", reflect.TypeOf(srcIn)) 
-        return nil 
-    }
-    dst, found := dstIn.(map[string]interface{})
-    if !found {
-        log.Criticalf("Deep Merge passed dest map of type: %s", reflect.TypeOf(dstIn)) 
-        return nil 
-    }
-    for k, v := range src {
-	
-        switch v.(type) {
-            case map[string]interface{}:
-                // don't try hoisting dstKey calculation
-                dstKey, found := findMatchingKey(dst, k)
-                if found {
-                    dstChild, found := dst[dstKey].(map[string]interface{})
-					
-                    if found {
-                        // recursive deepMerge into existing key
-                        dst[dstKey] = deepMerge(v.(map[string]interface{}), dstChild)
-                    } 
-                } else {
-                    // copy entire map to incoming key
-					
-                    dst[k] = v
-                }
-            case []interface{}:
-                dstKey, found := findMatchingKey(dst, k)
-                if found {
-                    dstChild, found := dst[dstKey].([]interface{})
-				
-                    if found {
-                        // union
-                        for elem := range v.([]interface{}) {
-                            if !contains(dstChild, elem) {
-                                dstChild = append(dstChild, elem)
-                            }
-                        } 
-                    }
-                } else {
-                    // copy
-                    dst[k] = v
-					
-                }
-            default:
-                // copy discrete types 
-                dstKey, found := findMatchingKey(dst, k)
-                if found {
-					
-                    dst[dstKey] = v
-					
-                } else {
-                    dst[k] = v
-				
-                }
-        }
-		
-    }
-    return dst
+// deep merge src into dst and return dst, a map replaces a value that is not
+// a map and arrays are combined as arrayMode says
+func deepMerge(srcIn interface{}, dstIn interface{}, arrayMode string) map[string]interface{} {
+	src, found := srcIn.(map[string]interface{})
+	if !found {
+		log.Criticalf("Deep Merge passed source map of type: %s", reflect.TypeOf(srcIn))
+		return nil
+	}
+	dst, found := dstIn.(map[string]interface{})
+	if !found {
+		log.Criticalf("Deep Merge passed dest map of type: %s", reflect.TypeOf(dstIn))
+		return nil
+	}
+	for k, v := range src {
+		// an existing key keeps its spelling, incoming keys may differ in case
+		dstKey, found := findMatchingKey(dst, k)
+		if !found {
+			dstKey = k
+		}
+		switch v.(type) {
+		case map[string]interface{}:
+			dstChild, found := dst[dstKey].(map[string]interface{})
+			if found {
+				// recursive deepMerge into existing key
+				dst[dstKey] = deepMerge(v, dstChild, arrayMode)
+			} else {
+				// copy entire map over whatever was there
+				dst[dstKey] = v
+			}
+		default:
+			// arrays are combined, discrete types are copied
+			dst[dstKey] = mergeArrays(dst[dstKey], v, arrayMode)
+		}
+	}
+	return dst
 }
 
 // returns a string that is nicely indented
@@ -2149,252 +1848,144 @@ func logMessage(ll LogLevel, msg string) {
  */
 }
 
-///**********************************************************assetHistroy
-const STATEHISTORYKEY string = ".StateHistory"
-
-type AssetStateHistory struct {
-	AssetHistory []string `json:"assetHistory"`
-}
-
-// Create a new history entry in the ledger for an asset.,\
-func createStateHistory(stub shim.ChaincodeStubInterface, assetID string, stateJSON string) error {
-
-	var ledgerKey = assetID + STATEHISTORYKEY
-	var assetStateHistory = AssetStateHistory{make([]string, 1)}
-	assetStateHistory.AssetHistory[0] = stateJSON
-
-	assetState, err := json.Marshal(&assetStateHistory)
-	if err != nil {
-		return err
-	}
-
-	return stub.PutState(ledgerKey, []byte(assetState))
-
-}
-
-// Update the ledger with new state history for an asset. States are stored in the ledger in descending order by timestamp.
-func updateStateHistory(stub shim.ChaincodeStubInterface, assetID string, stateJSON string) error {
-
-	var ledgerKey = assetID + STATEHISTORYKEY
-	var historyBytes []byte
-	var assetStateHistory AssetStateHistory
-	
-	historyBytes, err := stub.GetState(ledgerKey)
-	if err != nil {
-		return err
-	}
-
-	err = json.Unmarshal(historyBytes, &assetStateHistory)
-	if err != nil {
-		return err
-	}
-
-	var newSlice []string = make([]string, 0)
-	newSlice = append(newSlice, stateJSON)
-	newSlice = append(newSlice, assetStateHistory.AssetHistory...)
-	assetStateHistory.AssetHistory = newSlice
-
-	assetState, err := json.Marshal(&assetStateHistory)
-	if err != nil {
-		return err
-	}
-
-	return stub.PutState(ledgerKey, []byte(assetState))
-
-}
-
-// Delete an state history from the ledger.
-func deleteStateHistory(stub shim.ChaincodeStubInterface, assetID string) error {
-
-	var ledgerKey = assetID + STATEHISTORYKEY
-	return stub.DelState(ledgerKey)
-
-}
-
-// Get the state history for an asset.
-func readStateHistory(stub shim.ChaincodeStubInterface, assetID string) (AssetStateHistory, error) {
-
-	var ledgerKey = assetID + STATEHISTORYKEY
-	var assetStateHistory AssetStateHistory
-	var historyBytes []byte
-
-	historyBytes, err := stub.GetState(ledgerKey)
-	if err != nil {
-		return assetStateHistory, err
-	}
-
-	err = json.Unmarshal(historyBytes, &assetStateHistory)
-	if err != nil {
-		return assetStateHistory, err
-	}
-
-	return assetStateHistory, nil
-
-}
 //*************************************Alert ***************
-// Alerts exists so that strict type checking can be applied
-type Alerts int32
-
-const (
-    // AlertsOVERTEMP the over temperature alert 
-   // AlertsINVALIDCRTIME     Alerts = 0
-    //AlertsINVALIDMDTIME     Alerts = 1
-    AlertsTIMEERROR         Alerts = 0
-    AlertsRPMERROR          Alerts = 1
-
-
+// Alerts are named by the rules that raise them, see rules.go
 
-    // AlertsSIZE is to be maintained always as 1 greater than the last alert, giving a size  
-	AlertsSIZE        Alerts = 2
-)
-
-// AlertsName is a map of ID to name
-var AlertsName = map[int]string{
-	//0: "INVALID_CREATE_TIME",
-   // 1: "INVALID_MODIFY_TIME",
-    0: "CREATE_TIME_GREATER_THAN_MODIFY_TIME",
-    1: "RPM_LESS_THAN_20PERCENT",
-}
-
-// AlertsValue is a map of name to ID
-var AlertsValue = map[string]int32{
-	//"INVALID_CREATE_TIME": 0,
-    //"INVALID_MODIFY_TIME": 1,
-    "CREATE_TIME_GREATER_THAN_MODIFY_TIME":0,
-    "RPM_LESS_THAN_20PERCENT":1,
-}
-
-func (x Alerts) String() string {
-	return AlertsName[int(x)]
-}
-
-// AlertArrayInternal is used to store the list of active, raised or cleared alerts
+// AlertSet is used to store the set of active, raised or cleared alerts
 // for internal processing
-type AlertArrayInternal [AlertsSIZE]bool
+type AlertSet map[string]bool
 // AlertNameArray is used for external alerts in JSON
 type AlertNameArray []string
 
-// NOALERTSACTIVEINTERNAL is the zero value of an internal alerts array (bools)
-var NOALERTSACTIVEINTERNAL = AlertArrayInternal{}
-// NOALERTSACTIVE is the zero value of an external alerts array (string names)
-var NOALERTSACTIVE = AlertNameArray{}
-
 // AlertStatusInternal contains the three possible statuses for alerts
 type AlertStatusInternal struct {
-    Active  AlertArrayInternal  
-    Raised  AlertArrayInternal  
-    Cleared AlertArrayInternal  
+	Active  AlertSet
+	Raised  AlertSet
+	Cleared AlertSet
+	Records AlertRecords
 }
 
+// AlertStatus is the alerts section of an asset's state, Records holds the
+// latest incident of each alert, see alertrecords.go
 type AlertStatus struct {
-    Active  AlertNameArray  `json:"active"`
-    Raised  AlertNameArray  `json:"raised"`
-    Cleared AlertNameArray  `json:"cleared"`
+	Active  AlertNameArray `json:"active"`
+	Raised  AlertNameArray `json:"raised"`
+	Cleared AlertNameArray `json:"cleared"`
+	Records AlertRecords   `json:"records,omitempty"`
 }
 
 // convert from external representation with slice of names
-// to full length array of bools 
-func (a *AlertStatus) asAlertStatusInternal() (AlertStatusInternal) {
-    var aOut = AlertStatusInternal{}
-    for i := range a.Active {
-        aOut.Active[AlertsValue[a.Active[i]]] = true
-    }
-    for i := range a.Raised {
-        aOut.Raised[AlertsValue[a.Raised[i]]] = true
-    }
-    for i := range a.Cleared {
-        aOut.Cleared[AlertsValue[a.Cleared[i]]] = true
-    }
-    return aOut
+// to sets of names
+func (a *AlertStatus) asAlertStatusInternal() AlertStatusInternal {
+	var aOut = AlertStatusInternal{make(AlertSet), make(AlertSet), make(AlertSet), a.Records}
+	if aOut.Records == nil {
+		aOut.Records = make(AlertRecords)
+	}
+	for _, name := range a.Active {
+		aOut.Active[name] = true
+	}
+	for _, name := range a.Raised {
+		aOut.Raised[name] = true
+	}
+	for _, name := range a.Cleared {
+		aOut.Cleared[name] = true
+	}
+	return aOut
 }
 
-// convert from internal representation with full length array of bools  
-// to slice of names
-func (a *AlertStatusInternal) asAlertStatus() (AlertStatus) {
-    var aOut = newAlertStatus()
-    for i := range a.Active {
-        if a.Active[i] {
-            aOut.Active = append(aOut.Active, AlertsName[i])
-        }
-    }
-    for i := range a.Raised {
-        if a.Raised[i] {
-            aOut.Raised = append(aOut.Raised, AlertsName[i])
-        }
-    }
-    for i := range a.Cleared {
-        if a.Cleared[i] {
-            aOut.Cleared = append(aOut.Cleared, AlertsName[i])
-        }
-    }
-    return aOut
-}
-
-func (a *AlertStatusInternal) raiseAlert (alert Alerts) {
-    if a.Active[alert] {
-        // already raised
-        // this is tricky, should not say this event raised an
-        // active alarm, as it makes it much more difficult to track
-        // the exact moments of transition
-        a.Active[alert] = true
-        a.Raised[alert] = false
-        a.Cleared[alert] = false
-    } else {
-        // raising it
-        a.Active[alert] = true
-        a.Raised[alert] = true
-        a.Cleared[alert] = false
-    }
+// convert from internal representation with sets of names
+// to sorted slices of names
+func (a *AlertStatusInternal) asAlertStatus() AlertStatus {
+	var aOut = newAlertStatus()
+	aOut.Active = a.Active.names()
+	aOut.Raised = a.Raised.names()
+	aOut.Cleared = a.Cleared.names()
+	if len(a.Records) > 0 {
+		aOut.Records = a.Records
+	}
+	return aOut
 }
 
-func (a *AlertStatusInternal) clearAlert (alert Alerts) {
-    if a.Active[alert] {
-        // clearing alert
-        a.Active[alert] = false
-        a.Raised[alert] = false
-        a.Cleared[alert] = true
-    } else {
-        // was not active
-        a.Active[alert] = false
-        a.Raised[alert] = false
-        // this is tricky, should not say this event cleared an
-        // inactive alarm, as it makes it much more difficult to track
-        //  the exact moments of transition
-        a.Cleared[alert] = false
-    }
+func (s AlertSet) names() AlertNameArray {
+	names := make(AlertNameArray, 0, len(s))
+	for name, on := range s {
+		if on {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (a *AlertStatusInternal) raiseAlert(alert string) {
+	if a.Active[alert] {
+		// already raised
+		// this is tricky, should not say this event raised an
+		// active alarm, as it makes it much more difficult to track
+		// the exact moments of transition
+		a.Active[alert] = true
+		delete(a.Raised, alert)
+		delete(a.Cleared, alert)
+	} else {
+		// raising it
+		a.Active[alert] = true
+		a.Raised[alert] = true
+		delete(a.Cleared, alert)
+	}
+}
+
+func (a *AlertStatusInternal) clearAlert(alert string) {
+	if a.Active[alert] {
+		// clearing alert
+		delete(a.Active, alert)
+		delete(a.Raised, alert)
+		a.Cleared[alert] = true
+	} else {
+		// was not active
+		delete(a.Active, alert)
+		delete(a.Raised, alert)
+		// this is tricky, should not say this event cleared an
+		// inactive alarm, as it makes it much more difficult to track
+		//  the exact moments of transition
+		delete(a.Cleared, alert)
+	}
 }
 
-func newAlertStatus() (AlertStatus) {
-    var a AlertStatus
-    a.Active = make([]string, 0, AlertsSIZE)
-    a.Raised = make([]string, 0, AlertsSIZE)
-    a.Cleared = make([]string, 0, AlertsSIZE)
-    return a
+func newAlertStatus() AlertStatus {
+	var a AlertStatus
+	a.Active = make([]string, 0)
+	a.Raised = make([]string, 0)
+	a.Cleared = make([]string, 0)
+	return a
 }
 
-func (a *AlertStatus) alertStatusFromMap (aMap map[string]interface{}) () {
-    a.Active.copyFrom(aMap["active"].([]interface{}))
-    a.Raised.copyFrom(aMap["raised"].([]interface{}))
-    a.Cleared.copyFrom(aMap["cleared"].([]interface{}))
+func (a *AlertStatus) alertStatusFromMap(aMap map[string]interface{}) {
+	a.Active.copyFrom(aMap["active"])
+	a.Raised.copyFrom(aMap["raised"])
+	a.Cleared.copyFrom(aMap["cleared"])
+	a.Records = alertRecordsFromMap(aMap["records"])
 } 
 
-func (arr *AlertNameArray) copyFrom (s []interface{}) {
-    // a conversion like this must assert type at every level
-    for i := 0; i < len(s); i++ {
-        *arr = append(*arr, s[i].(string))
-    }
+func (arr *AlertNameArray) copyFrom(v interface{}) {
+	// a conversion like this must assert type at every level
+	s, _ := v.([]interface{})
+	for i := 0; i < len(s); i++ {
+		if name, ok := s[i].(string); ok {
+			*arr = append(*arr, name)
+		}
+	}
 }
 
 // NoAlertsActive returns true when no alerts are active in the asset's status at this time
-func (arr *AlertStatusInternal) NoAlertsActive() (bool) {
-    return (arr.Active == NOALERTSACTIVEINTERNAL)
+func (arr *AlertStatusInternal) NoAlertsActive() bool {
+	return len(arr.Active) == 0
 }
 
 // AllClear returns true when no alerts are active, raised or cleared in the asset's status at this time
-func (arr *AlertStatusInternal) AllClear() (bool) {
-    return  (arr.Active == NOALERTSACTIVEINTERNAL) &&
-            (arr.Raised == NOALERTSACTIVEINTERNAL) &&
-            (arr.Cleared == NOALERTSACTIVEINTERNAL) 
+func (arr *AlertStatusInternal) AllClear() bool {
+	return len(arr.Active) == 0 &&
+		len(arr.Raised) == 0 &&
+		len(arr.Cleared) == 0
 }
 
 // NoAlertsActive returns true when no alerts are active in the asset's status at this time
@@ -2403,109 +1994,103 @@ func (a *AlertStatus) NoAlertsActive() (bool) {
 }
 
 // AllClear returns true when no alerts are active, raised or cleared in the asset's status at this time
-func (a *AlertStatus) AllClear() (bool) {
-    return  len(a.Active) == 0 &&
-            len(a.Raised) == 0 &&
-            len(a.Cleared) == 0 
+// and there are no incident records to keep
+func (a *AlertStatus) AllClear() bool {
+	return len(a.Active) == 0 &&
+		len(a.Raised) == 0 &&
+		len(a.Cleared) == 0 &&
+		len(a.Records) == 0
 }
 
 //// Executing
 
-func (a *ArgsMap) executeRules(alerts *AlertStatus) (bool) {
-    log.Debugf("Executing rules input: %v", *alerts)
-    var internal = (*alerts).asAlertStatusInternal()
+// executeRules evaluates the ledger rules that apply to the asset type against
+// the entire state, raises or clears their alerts and weighs the active alerts
+func (a *ArgsMap) executeRules(stub shim.ChaincodeStubInterface, at AssetType, alerts *AlertStatus) (Compliance, error) {
+	log.Debugf("Executing rules for type %s input: %v", at.Name, *alerts)
+	var internal = (*alerts).asAlertStatusInternal()
 
-    // rule 1 -- Create and mod time check
-    internal.timeCheck(a)
-    // rule 2 --RPM check : if motor is running at 20% or below, it will likely overheat
-    internal.rpmCheck(a)
-    // rule 3 -- HVAC Check. If the HVAC is not running, that is an alert scenario
-    //internal.hvacCheck(a)
-    // now transform internal back to external in order to give the contract the
-    // appropriate JSON to send externally
-    *alerts = internal.asAlertStatus()
-    log.Debugf("Executing rules output: %v", *alerts)
+	rules, err := GETRulesFromLedger(stub)
+	if err != nil {
+		return Compliance{}, err
+	}
+	assetID, _ := getObject(*a, ASSETID)
+	sAssetID, _ := assetID.(string)
+	thresholds, err := effectiveThresholds(stub, rules, at.Name, sAssetID)
+	if err != nil {
+		return Compliance{}, err
+	}
+	ctx := ruleContext{map[string]interface{}(*a), thresholds}
+	severities := make(map[string]string)
+	closeIncident := func(alert string) {
+		var before interface{}
+		if record, found := internal.Records[alert]; found {
+			prior := *record
+			before = &prior
+		}
+		internal.Records.close(stub, alert)
+		emitEvent(stub, EVENTALERTCLEARED, sAssetID+"_"+at.Name, before, internal.Records[alert])
+	}
+	for _, rule := range rules.Rules {
+		if !rule.appliesTo(at) {
+			continue
+		}
+		alert := rule.alertName()
+		severities[alert] = rule.Severity
+		switch {
+		case rule.Disabled:
+			// a disabled rule takes its alert down with it
+			internal.clearAlert(alert)
+		case internal.Active[alert] && rule.ClearCondition != nil:
+			// inside the hysteresis band an active alert stays active
+			if rule.ClearCondition.evaluate(&ctx) {
+				internal.clearAlert(alert)
+			} else {
+				internal.raiseAlert(alert)
+			}
+		case rule.Condition.evaluate(&ctx):
+			internal.raiseAlert(alert)
+		default:
+			internal.clearAlert(alert)
+		}
+		// keep the incident record in step with the transition
+		if internal.Raised[alert] {
+			internal.Records.open(stub, &rule, &ctx)
+			emitEvent(stub, EVENTALERTRAISED, sAssetID+"_"+at.Name, nil, internal.Records[alert])
+		} else if internal.Cleared[alert] {
+			closeIncident(alert)
+		}
+	}
+	// an alert that no applicable rule produced, because its rule was deleted or
+	// no longer applies to the type, is cleared rather than left active for good
+	stale := make([]string, 0)
+	for alert := range internal.Active {
+		if _, found := severities[alert]; !found {
+			stale = append(stale, alert)
+		}
+	}
+	sort.Strings(stale)
+	for _, alert := range stale {
+		internal.clearAlert(alert)
+		closeIncident(alert)
+	}
+	// now transform internal back to external in order to give the contract the
+	// appropriate JSON to send externally
+	*alerts = internal.asAlertStatus()
+	log.Debugf("Executing rules output: %v", *alerts)
 
-    // set compliance true means out of compliance
-    compliant := internal.calculateContractCompliance(a)
-    // returns true if anything at all is active (i.e. NOT compliant)
-    return !compliant
+	return internal.calculateContractCompliance(severities), nil
 }
 
 //***********************************
-//**           RULES               **
+//**         COMPLIANCE            **
 //***********************************
 
-func (alerts *AlertStatusInternal) timeCheck (a *ArgsMap) {
-//var createTime time.Time
-//var modTime time.Time
-    /*
-    now := time.Now()
-    unixNano := now.UnixNano()                                                                      
-    umillisec := unixNano / 1000000  */
-    crTime, found := getObject(*a, "create_date")
-    mdTime, found2 := getObject(*a, "last_mod_date")
-    if found && found2 {
-        //modTime= time.Unix(0, msInt*int64(time.Millisecond))
-        if crTime.(float64) > mdTime.(float64) {
-            alerts.raiseAlert(AlertsTIMEERROR)
-        return
-        }
-        alerts.clearAlert(AlertsTIMEERROR)
-    }
-}
-// Need to modify so that for motor, this ic called first 
-func (alerts *AlertStatusInternal) rpmCheck (a *ArgsMap) {
-//Reference : http://www.vfds.in/be-aware-of-vfd-running-in-low-speed-frequency-655982.html
-    maxRPM, found := getObject(*a, "max_rpm")
-    if found {
-        curRPM, found2 := getObject(*a, "rpm")
-        if found2 {
-            percRPM := (curRPM.(float64)/maxRPM.(float64))*100
-            if percRPM <=30 {
-                alerts.raiseAlert(AlertsRPMERROR)
-                return
-            }
-        }
-    }
-    alerts.clearAlert(AlertsRPMERROR)
-}
-/*
-func (alerts *AlertStatusInternal) hvacCheck (a *ArgsMap) {
-    hvacMode, found := getObject(*a, "hvac_mode")
-    if found {
-        tgtTemp, found2 := getObject(*a, "target_temperature_c")
-        if found2 {
-            ambTemp, found3 := getObject(*a, "ambient_temperature_c")
-            if found3 {
-                if (ambTemp.(float64) >tgtTemp.(float64) && hvacMode =="heat") {
-                    alerts.raiseAlert(AlertsHVACOVERHEAT)
-                    return
-                }
-                alerts.clearAlert(AlertsHVACOVERHEAT)
-                if (ambTemp.(float64) <tgtTemp.(float64) && hvacMode =="cool") {
-                    alerts.raiseAlert(AlertsHVACOVERCOOL)
-                    return
-                }
-                alerts.clearAlert(AlertsHVACOVERCOOL)
-            }
-        }
-    }
-    alerts.clearAlert(AlertsHVACOVERHEAT)
-    alerts.clearAlert(AlertsHVACOVERCOOL)
-}
-*/
-//***********************************
-//**         COMPLIANCE            **
-//***********************************
-
-func (alerts *AlertStatusInternal) calculateContractCompliance (a *ArgsMap) (bool) {
-    // a simplistic calculation for this particular contract, but has access
-    // to the entire state object and can thus have at it
-    // compliant is no alerts active
-    return alerts.NoAlertsActive()
-    // NOTE: There could still a "cleared" alert, so don't go
-    //       deleting the alerts from the ledger just on this status.
+func (alerts *AlertStatusInternal) calculateContractCompliance(severities map[string]string) Compliance {
+	// active alerts are weighed by the severity of their rules, see compliance.go
+	return complianceFor(alerts.Active, alerts.Records, severities)
+	// NOTE: There could still a "cleared" alert, so don't go
+	//       deleting the alerts from the ledger just on this status.
 }
 //****************************************Create Accout**************************************************
 
@@ -2513,16 +2098,10 @@ func (alerts *AlertStatusInternal) calculateContractCompliance (a *ArgsMap) (boo
 // createAccount
 // ************************************
 func (t *SimpleChaincode) createAccount(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
-	var accountID string
-	var accountType string
-	var accountName string
 	var argsMap ArgsMap
-	var event interface{}
-	var found bool
 	var err error
-	//var timeIn time.Time
 
-	log.Info("Entering createAsset")
+	log.Info("Entering createAccount")
 
 	// allowing 2 args because updateAsset is allowed to redirect when
 	// asset does not exist
@@ -2531,133 +2110,77 @@ func (t *SimpleChaincode) createAccount(stub shim.ChaincodeStubInterface, args [
 		log.Error(err)
 		return nil, err
 	}
-
-	accountID = ""
-	
-	accountName = ""
-	eventBytes := []byte(args[0])
 	log.Debugf("createAccount arg: %s", args[0])
-	fmt.Println("args[0]",args[0])
-	err = json.Unmarshal(eventBytes, &event)
+
+	err = json.Unmarshal([]byte(args[0]), &argsMap)
 	if err != nil {
-		log.Errorf("createAccount failed to unmarshal arg: %s", err)
+		err = fmt.Errorf("createAccount failed to unmarshal arg: %s", err)
+		log.Error(err)
 		return nil, err
 	}
-
-	if event == nil {
-		err = errors.New("createAccount unmarshal arg created nil event")
+	if argsMap == nil {
+		err = errors.New("createAccount arg is not a map shape")
 		log.Error(err)
 		return nil, err
 	}
 
-	argsMap, found = event.(map[string]interface{})
-	if !found {
-		err := errors.New("createAccount arg is not a map shape")
+	account := accountFromMap(argsMap)
+	if account.ID == "" {
+		err = errors.New("createAccount arg does not include accountID")
 		log.Error(err)
 		return nil, err
 	}
-
-	// is accountID present or blank?
-	assetIDBytes, found := getObject(argsMap, ACCOUNTID)
-	fmt.Println("assetIDBytes",assetIDBytes)
-	
-	if found {
-		accountID, found = assetIDBytes.(string)
-		if !found || accountID == "" {
-			err := errors.New("createAccount arg does not include accountID ")
-			log.Error(err)
-			return nil, err
-		}
-	}
-	// Is asset name present?
-	assetTypeBytes, found := getObject(argsMap, ACCOUNTNAME)
-	if found {
-		accountName, found = assetTypeBytes.(string)
-		if !found || accountName == "" {
-			err := errors.New("createAccout arg does not include accountName ")
-			log.Error(err)
-			return nil, err
-		}
+	if account.Name == "" {
+		err = errors.New("createAccount arg does not include acname")
+		log.Error(err)
+		return nil, err
 	}
-
-
-	sAccountKey := accountID + "_" + accountType
-	fmt.Println("sAccountKey",sAccountKey)
-	found = accountIsActive(stub, sAccountKey)
-	if found {
-		err := fmt.Errorf("createAccout arg asset %s already exists", accountID)
+	err = checkID(ACCOUNTID, account.ID)
+	if err != nil {
+		err = fmt.Errorf("createAccount %s", err)
 		log.Error(err)
 		return nil, err
 	}
-
-	// For now, timestamp is being sent in from the invocation to the contract
-	// Once the BlueMix instance supports GetTxnTimestamp, we will incorporate the
-	// changes to the contract
-
-	// run the rules and raise or clear alerts
-	alerts := newAlertStatus()
-	if argsMap.executeRules(&alerts) {
-		// NOT compliant!
-		log.Noticef("createAccout accountID %s is noncompliant", accountID)
-		argsMap["alerts"] = alerts
-		delete(argsMap, "incompliance")
-	} else {
-		if alerts.AllClear() {
-			// all false, no need to appear
-			delete(argsMap, "alerts")
-		} else {
-			argsMap["alerts"] = alerts
-		}
-		argsMap["incompliance"] = true
+	if accountExists(stub, account.ID) {
+		err = fmt.Errorf("createAccount account %s already exists", account.ID)
+		log.Error(err)
+		return nil, err
 	}
 
-	// copy incoming event to outgoing state
-	// this contract respects the fact that createAsset can accept a partial state
-	// as the moral equivalent of one or more discrete events
-	// further: this contract understands that its schema has two discrete objects
-	// that are meant to be used to send events: common, and custom
-	stateOut := argsMap
+	// a new account is always active, whatever the caller sent
+	account.Status = ACCOUNTACTIVE
+	account.StatusReason = ""
+	account.StatusChangedAt = ""
+	account.StatusChangedBy = ""
+	account.CreatedAt = txTimestamp(stub).Format(time.RFC3339Nano)
 
 	// save the original event
-	stateOut["lastEvent"] = make(map[string]interface{})
-	stateOut["lastEvent"].(map[string]interface{})["function"] = "createAccount"
-	stateOut["lastEvent"].(map[string]interface{})["args"] = args[0]
+	account.LastEvent = map[string]interface{}{"function": "createAccount", "args": args[0]}
 	if len(args) == 2 {
 		// in-band protocol for redirect
-		stateOut["lastEvent"].(map[string]interface{})["redirectedFromFunction"] = args[1]
+		account.LastEvent["redirectedFromFunction"] = args[1]
 	}
 
-	// marshal to JSON and write
-	stateJSON, err := json.Marshal(&stateOut)
-	if err != nil {
-		err := fmt.Errorf("createAccount state for accountID %s failed to marshal", accountID)
-		log.Error(err)
-		return nil, err
-	}
-
-	// finally, put the new state
-	log.Infof("Putting new account state %s to ledger", string(stateJSON))
-	// The key i 'assetid'_'type'
-
-	err = stub.PutState(sAccountKey, []byte(stateJSON))
+	sAccountKey := accountKey(account.ID)
+	stateJSON, err := putAccountJSON(stub, account)
 	if err != nil {
-		err = fmt.Errorf("createAccount accountID %s PUTSTATE failed: %s", accountID, err)
+		err = fmt.Errorf("createAccount %s", err)
 		log.Error(err)
 		return nil, err
 	}
-	log.Infof("createAccount accountID  state %s successfully written to ledger: %s", accountID,  string(stateJSON))
+	log.Infof("createAccount account %s successfully written to ledger: %s", account.ID, string(stateJSON))
 
-	// add asset to contract state
-	err = addAccountToContractState(stub, sAccountKey,"account")
+	// add account to contract state
+	err = addAccountToContractState(stub, sAccountKey, "account")
 	if err != nil {
-		err := fmt.Errorf("createAccount asset %s  failed to write asset state: %s", accountID,  err)
+		err = fmt.Errorf("createAccount account %s failed to write contract state: %s", account.ID, err)
 		log.Critical(err)
 		return nil, err
 	}
-fmt.Println("stateJSON",stateJSON)
-	err = pushRecentState(stub, string(stateJSON),"1")
+
+	err = pushRecentState(stub, RECENTACCOUNTS, string(stateJSON))
 	if err != nil {
-		err = fmt.Errorf("createAccount accountID %s  push to recentstates failed: %s", accountID,  err)
+		err = fmt.Errorf("createAccount account %s push to recentstates failed: %s", account.ID, err)
 		log.Error(err)
 		return nil, err
 	}
@@ -2665,44 +2188,34 @@ fmt.Println("stateJSON",stateJSON)
 	// save state history
 	err = createStateHistory(stub, sAccountKey, string(stateJSON))
 	if err != nil {
-		err := fmt.Errorf("createAccount asset %s of type %s state history save failed: %s", accountID, sAccountKey, err)
+		err = fmt.Errorf("createAccount account %s state history save failed: %s", account.ID, err)
 		log.Critical(err)
 		return nil, err
 	}
+	emitEvent(stub, EVENTACCOUNTCREATED, sAccountKey, nil, account)
 	return nil, nil
 }
 
-func accountIsActive(stub shim.ChaincodeStubInterface, sAssetKey string) (bool) {
-    var state ContractState
-    var err error
-    state, err = GETContractStateFromLedger(stub)
-    if err != nil { return false}
-    found, _ := state.ActiveAccounts[sAssetKey]
-    return found
+func accountIsActive(stub shim.ChaincodeStubInterface, sAssetKey string) bool {
+	return inIndex(stub, ACCOUNTINDEX, sAssetKey)
 }
 
-func addAccountToContractState(stub shim.ChaincodeStubInterface, sAssetKey string,transType string) (error) {
-    var state ContractState
-    var err error
-    state, err = GETContractStateFromLedger(stub)  
-    if err != nil {
-        return err
-    }
-    log.Debugf("Adding asset %s to contract", sAssetKey)
-	if transType == "account"{
-    state.ActiveAccounts[sAssetKey] = true
-	}else if(transType== "issue"){
-		 state.IssueAccounts[sAssetKey] = true
-	}
-    return PUTContractStateToLedger(stub, state)
-}// ************************************
-// readAllAssets
+func addAccountToContractState(stub shim.ChaincodeStubInterface, sAssetKey string, transType string) error {
+	log.Debugf("Adding asset %s to contract", sAssetKey)
+	if transType == "account" {
+		return addToIndex(stub, ACCOUNTINDEX, sAssetKey)
+	} else if transType == "issue" {
+		return addToIndex(stub, HOLDINGINDEX, sAssetKey)
+	}
+	return nil
+}
+
+// ************************************
+// readAllAccounts
 // ************************************
 func (t *SimpleChaincode) readAllAccounts(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
-	var sAssetKey string
 	var err error
-	var results []interface{}
-	var state interface{}
+	var results []Account
 
 	if len(args) > 0 {
 		err = errors.New("readAllAccounts expects no arguments")
@@ -2711,30 +2224,21 @@ func (t *SimpleChaincode) readAllAccounts(stub shim.ChaincodeStubInterface, args
 	}
 
 	aa, err := getActiveAccounts(stub)
-	fmt.Println("aa",aa)
 	if err != nil {
-		err = fmt.Errorf("readAllAccounts failed to get the active assets: %s", err)
+		err = fmt.Errorf("readAllAccounts failed to get the active accounts: %s", err)
 		log.Error(err)
 		return nil, err
 	}
-	results = make([]interface{}, 0, len(aa))
+	results = make([]Account, 0, len(aa))
 	for i := range aa {
-		sAssetKey = aa[i]
-		// Get the state from the ledger
-		assetBytes, err := stub.GetState(sAssetKey)
-		if err != nil {
+		accountID := accountIDFromKey(aa[i])
+		account, found, err := getAccount(stub, accountID, false)
+		if err != nil || !found {
 			// best efforts, return what we can
-			log.Errorf("readAllAccounts assetID %s failed GETSTATE", sAssetKey)
+			log.Errorf("readAllAccounts account %s failed to read: %v", accountID, err)
 			continue
-		} else {
-			err = json.Unmarshal(assetBytes, &state)
-			if err != nil {
-				// best efforts, return what we can
-				log.Errorf("readAllAccounts assetID %s failed to unmarshal", sAssetKey)
-				continue
-			}
-			results = append(results, state)
 		}
+		results = append(results, account)
 	}
 
 	resultsStr, err := json.Marshal(results)
@@ -2748,243 +2252,390 @@ func (t *SimpleChaincode) readAllAccounts(stub shim.ChaincodeStubInterface, args
 }
 
 func getActiveAccounts(stub shim.ChaincodeStubInterface) ([]string, error) {
-    var state ContractState
-    var err error
-    state, err = GETContractStateFromLedger(stub)  
-    if err != nil {
-        return []string{}, err
-    }
-    var a = make([]string, len(state.ActiveAccounts))
-    i := 0
-    for id := range state.ActiveAccounts {
-        a[i] = id
-        i++ 
-    }
-    sort.Strings(a)
-    return a, nil
+	return indexMembers(stub, ACCOUNTINDEX)
 }
 
 //******************************************************************************Issue************************************
 
+// ************************************
+// issueAsset
+// ************************************
 func (t *SimpleChaincode) issueAsset(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
 	var accountID string
 	var assetID string
-//	var amount int
 	var argsMap ArgsMap
 	var event interface{}
-	var ledgerMap ArgsMap
-	var ledgerBytes interface{}
+	var holdingMap ArgsMap
+	var holdingPrior []byte
 	var found bool
 	var err error
-	//var timeIn time.Time
 
-	log.Info("Entering createAsset")
+	log.Info("Entering issueAsset")
 
-	// allowing 2 args because updateAsset is allowed to redirect when
-	// asset does not exist
-	if len(args) < 1 || len(args) > 2 {
-		err = errors.New("Expecting one JSON event object")
+	if len(args) != 1 {
+		err = errors.New("issueAsset expects one JSON object with accountID, assetID and amount")
 		log.Error(err)
 		return nil, err
 	}
 
-	accountID = ""
-//	amount = 0
 	eventBytes := []byte(args[0])
-	log.Debugf("createAccount arg: %s", args[0])
-	fmt.Println("args[0]",args[0])
-	err = json.Unmarshal(eventBytes, &event)
+	log.Debugf("issueAsset arg: %s", args[0])
+	// numbers are kept as written so that the amount is never rounded through a float
+	decoder := json.NewDecoder(bytes.NewReader(eventBytes))
+	decoder.UseNumber()
+	err = decoder.Decode(&event)
 	if err != nil {
-		log.Errorf("createAccount failed to unmarshal arg: %s", err)
+		log.Errorf("issueAsset failed to unmarshal arg: %s", err)
 		return nil, err
 	}
 
-	if event == nil {
-		err = errors.New("createAccount unmarshal arg created nil event")
+	argsMap, found = event.(map[string]interface{})
+	if !found {
+		err := errors.New("issueAsset arg is not a map shape")
 		log.Error(err)
 		return nil, err
 	}
 
-	argsMap, found = event.(map[string]interface{})
-	fmt.Println("argsMap",argsMap)
+	// are accountID and assetID present?
+	accountIDBytes, found := getObject(argsMap, ACCOUNTID)
+	if found {
+		accountID, _ = accountIDBytes.(string)
+	}
+	assetIDBytes, found := getObject(argsMap, ASSETID)
+	if found {
+		assetID, _ = assetIDBytes.(string)
+	}
+	if accountID == "" || assetID == "" {
+		err = errors.New("issueAsset arg must include accountID and assetID")
+		log.Error(err)
+		return nil, err
+	}
+
+	// units can only be issued to a registered account in good standing
+	err = checkParty(stub, ACCOUNTID, accountID)
+	if err != nil {
+		log.Errorf("issueAsset %s", err)
+		return nil, err
+	}
+
+	// only the issuer of a defined asset can mint it
+	def, found, err := GETAssetDefinitionFromLedger(stub, assetID)
+	if err != nil {
+		err = fmt.Errorf("issueAsset %s", err)
+		log.Error(err)
+		return nil, err
+	}
 	if !found {
-		err := errors.New("createAccount arg is not a map shape")
+		err = fmt.Errorf("issueAsset asset %s is not defined, use defineAsset first", assetID)
+		log.Error(err)
+		return nil, err
+	}
+	caller, err := getCaller(stub, argsMap)
+	if err != nil {
+		err = fmt.Errorf("issueAsset %s", err)
+		log.Error(err)
+		return nil, err
+	}
+	if caller != def.Issuer {
+		err = fmt.Errorf("issueAsset caller %s is not the issuer of asset %s", caller, assetID)
 		log.Error(err)
 		return nil, err
 	}
 
-	// is accountID present or blank?
-	assetIDBytes, found := getObject(argsMap, ACCOUNTID)
-	fmt.Println("assetIDBytes",assetIDBytes)
-	
-	if found {
-		accountID, found = assetIDBytes.(string)
-		if !found || accountID == "" {
-			err := errors.New("createAccount arg does not include accountID ")
+	// the amount must be exact at the scale of the asset
+	amount, err := parseAmountArg(argsMap[AMOUNT], def.Decimals)
+	if err != nil {
+		err = fmt.Errorf("issueAsset asset %s: %s", assetID, err)
+		log.Error(err)
+		return nil, err
+	}
+	if amount.Sign() <= 0 {
+		err = fmt.Errorf("issueAsset amount must be positive, got %s", amount)
+		log.Error(err)
+		return nil, err
+	}
+	supply, err := def.circulatingSupply()
+	if err != nil {
+		err = fmt.Errorf("issueAsset %s", err)
+		log.Error(err)
+		return nil, err
+	}
+	supply = supply.Add(amount)
+	maxSupply, capped, err := def.maxSupply()
+	if err != nil {
+		err = fmt.Errorf("issueAsset %s", err)
+		log.Error(err)
+		return nil, err
+	}
+	if capped && supply.Cmp(maxSupply) > 0 {
+		err = fmt.Errorf("issueAsset of %s would take asset %s to a supply of %s, over its cap of %s", amount, assetID, supply, maxSupply)
+		log.Error(err)
+		return nil, err
+	}
+	def.CirculatingSupply = supply.String()
+
+	// an existing holding is credited, never overwritten
+	sAccountKey := accountID + "_" + assetID
+	newHolding := !issueAccountIsActive(stub, sAccountKey)
+	balance := ZeroAmount(def.Decimals)
+	if newHolding {
+		err = checkNewHolding(stub, sAccountKey)
+		if err != nil {
+			err = fmt.Errorf("issueAsset %s", err)
 			log.Error(err)
 			return nil, err
 		}
-	}
-	// Is asset name present?
-	assetTypeBytes, found := getObject(argsMap, ASSETID)
-	if found {
-		assetID, found = assetTypeBytes.(string)
-		if !found || assetID == "" {
-			err := errors.New("createAsset arg does not include accountName ")
+		holdingMap = ArgsMap{
+			ACCOUNTID: accountID,
+			ASSETID:   assetID,
+		}
+	} else {
+		holdingMap, holdingPrior, err = getHoldingFromLedger(stub, sAccountKey)
+		if err != nil {
+			err = fmt.Errorf("issueAsset %s", err)
+			log.Error(err)
+			return nil, err
+		}
+		balance, err = holdingAmount(holdingMap, def.Decimals)
+		if err != nil {
+			err = fmt.Errorf("issueAsset holding %s: %s", sAccountKey, err)
 			log.Error(err)
 			return nil, err
 		}
 	}
+	holdingBefore := ledgerValue(stub, sAccountKey)
+	holdingMap[AMOUNT] = balance.Add(amount).String()
 
+	// save the original event
+	holdingMap["lastEvent"] = make(map[string]interface{})
+	holdingMap["lastEvent"].(map[string]interface{})["function"] = "issueAsset"
+	holdingMap["lastEvent"].(map[string]interface{})["args"] = args[0]
 
-	sAccountKey := accountID + "_" + assetID
-	fmt.Println("sAccountKey",sAccountKey)
-	found = issueAccountIsActive(stub, sAccountKey)
-	if found {
-	/*	err := fmt.Errorf("createAsset arg asset %s already exists", accountID)
+	stateJSON, err := json.Marshal(holdingMap)
+	if err != nil {
+		err = fmt.Errorf("issueAsset holding %s marshal failed: %s", sAccountKey, err)
 		log.Error(err)
-		return nil, err*/
-		assetBytes, err := stub.GetState(sAccountKey)
+		return nil, err
+	}
+	defJSON, err := json.Marshal(def)
 	if err != nil {
-		log.Errorf("updateAsset assetID %s of type %s GETSTATE failed: %s", assetID, accountID, err)
+		err = fmt.Errorf("issueAsset asset definition %s marshal failed: %s", assetID, err)
+		log.Error(err)
 		return nil, err
 	}
 
-	// unmarshal the existing state from the ledger to theinterface
-	err = json.Unmarshal(assetBytes, &ledgerBytes)
+	// the holding and the supply go to the ledger as a pair
+	err = putStatePair(stub, sAccountKey, stateJSON, holdingPrior, ASSETDEFINITIONKEYPREFIX+assetID, defJSON)
 	if err != nil {
-		log.Errorf("updateAsset assetID %s of type %s unmarshal failed: %s", assetID, accountID, err)
+		err = fmt.Errorf("issueAsset %s", err)
+		log.Critical(err)
 		return nil, err
 	}
+	log.Infof("issueAsset issued %s of asset %s to account %s, supply is now %s", amount, assetID, accountID, supply)
 
-	// assert the existing state as a map
-	ledgerMap, found = ledgerBytes.(map[string]interface{})
-	if !found {
-		log.Errorf("updateAsset assetID %s of type %s LEDGER state is not a map shape", assetID, accountID)
+	if newHolding {
+		err = addHolding(stub, accountID, assetID)
+		if err != nil {
+			err = fmt.Errorf("issueAsset holding %s failed to write contract state: %s", sAccountKey, err)
+			log.Critical(err)
+			return nil, err
+		}
+	}
+
+	err = pushRecentState(stub, RECENTISSUANCE, string(stateJSON))
+	if err != nil {
+		err = fmt.Errorf("issueAsset holding %s push to recentstates failed: %s", sAccountKey, err)
+		log.Error(err)
 		return nil, err
 	}
 
+	// save state history
+	if newHolding {
+		err = createStateHistory(stub, sAccountKey, string(stateJSON))
+	} else {
+		err = updateStateHistory(stub, sAccountKey, string(stateJSON))
+	}
+	if err != nil {
+		err = fmt.Errorf("issueAsset holding %s state history save failed: %s", sAccountKey, err)
+		log.Critical(err)
+		return nil, err
+	}
+	emitEvent(stub, EVENTASSETISSUED, sAccountKey, holdingBefore, holdingMap)
+	return nil, nil
+}
 
-	stateOut := deepMerge(map[string]interface{}(argsMap),
-		map[string]interface{}(ledgerMap))
-	log.Debugf("updateAsset assetID %s merged state: %s of type %s", assetID, accountID, stateOut)
+//******************************************************************************Redeem************************************
 
-	// save the original event
-	stateOut["lastEvent"] = make(map[string]interface{})
-	
+// ************************************
+// redeemAsset
+// ************************************
+func (t *SimpleChaincode) redeemAsset(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var accountID string
+	var assetID string
+	var argsMap ArgsMap
+	var event interface{}
+	var found bool
+	var err error
 
-	// Write the new state to the ledger
-	stateJSON, err := json.Marshal(ledgerMap)
-	if err != nil {
-		err = fmt.Errorf("updateAsset AssetID %s of type %s marshal failed: %s", assetID, accountID, err)
+	log.Info("Entering redeemAsset")
+
+	if len(args) != 1 {
+		err = errors.New("redeemAsset expects one JSON object with accountID, assetID and amount")
 		log.Error(err)
 		return nil, err
 	}
 
-	// finally, put the new state
-	err = stub.PutState(sAccountKey, []byte(stateJSON))
+	log.Debugf("redeemAsset arg: %s", args[0])
+	decoder := json.NewDecoder(bytes.NewReader([]byte(args[0])))
+	decoder.UseNumber()
+	err = decoder.Decode(&event)
 	if err != nil {
-		err = fmt.Errorf("updateAsset AssetID %s of type %s PUTSTATE failed: %s", assetID, accountID, err)
-		log.Error(err)
+		log.Errorf("redeemAsset failed to unmarshal arg: %s", err)
 		return nil, err
 	}
 
-	err = pushRecentState(stub, string(stateJSON),"2")
-	if err != nil {
-		err = fmt.Errorf("updateAsset AssetID %s push to recentstates failed: %s", assetID, err)
+	argsMap, found = event.(map[string]interface{})
+	if !found {
+		err := errors.New("redeemAsset arg is not a map shape")
 		log.Error(err)
 		return nil, err
 	}
 
-	// add history state
-	err = updateStateHistory(stub, sAccountKey, string(stateJSON))
-	if err != nil {
-		err = fmt.Errorf("updateAsset AssetID %s of type %s push to history failed: %s", assetID, accountID, err)
+	accountIDBytes, found := getObject(argsMap, ACCOUNTID)
+	if found {
+		accountID, _ = accountIDBytes.(string)
+	}
+	assetIDBytes, found := getObject(argsMap, ASSETID)
+	if found {
+		assetID, _ = assetIDBytes.(string)
+	}
+	if accountID == "" || assetID == "" {
+		err = errors.New("redeemAsset arg must include accountID and assetID")
 		log.Error(err)
 		return nil, err
 	}
 
-	return nil, nil
-
+	// units are handed back by their holder, or retired by an admin, and a
+	// frozen account cannot move its units this way either
+	caller, err := getCaller(stub, argsMap)
+	admin := callerHasRole(stub, ADMINROLE)
+	if err != nil && !admin {
+		err = fmt.Errorf("redeemAsset %s", err)
+		log.Error(err)
+		return nil, err
+	}
+	if caller != accountID && !admin {
+		err = fmt.Errorf("redeemAsset caller %s is neither the holder %s nor an %s", caller, accountID, ADMINROLE)
+		log.Error(err)
+		return nil, err
+	}
+	err = checkParty(stub, ACCOUNTID, accountID)
+	if err != nil {
+		log.Errorf("redeemAsset %s", err)
+		return nil, err
 	}
 
-	// For now, timestamp is being sent in from the invocation to the contract
-	// Once the BlueMix instance supports GetTxnTimestamp, we will incorporate the
-	// changes to the contract
-
-	/*// run the rules and raise or clear alerts
-	alerts := newAlertStatus()
-	if argsMap.executeRules(&alerts) {
-		// NOT compliant!
-		log.Noticef("createAsset accountID %s is noncompliant", accountID)
-		argsMap["alerts"] = alerts
-		delete(argsMap, "incompliance")
-	} else {
-		if alerts.AllClear() {
-			// all false, no need to appear
-			delete(argsMap, "alerts")
-		} else {
-			argsMap["alerts"] = alerts
-		}
-		argsMap["incompliance"] = true
-	}*/
-
-	// copy incoming event to outgoing state
-	// this contract respects the fact that createAsset can accept a partial state
-	// as the moral equivalent of one or more discrete events
-	// further: this contract understands that its schema has two discrete objects
-	// that are meant to be used to send events: common, and custom
-	stateOut := argsMap
+	def, found, err := GETAssetDefinitionFromLedger(stub, assetID)
+	if err != nil {
+		err = fmt.Errorf("redeemAsset %s", err)
+		log.Error(err)
+		return nil, err
+	}
+	if !found {
+		err = fmt.Errorf("redeemAsset asset %s is not defined", assetID)
+		log.Error(err)
+		return nil, err
+	}
 
-	// save the original event
-	stateOut["lastEvent"] = make(map[string]interface{})
-	//stateOut["lastEvent"].(map[string]interface{})["function"] = "issueAsset"
-	//stateOut["lastEvent"].(map[string]interface{})["args"] = args[0]
-	if len(args) == 2 {
-		// in-band protocol for redirect
-		stateOut["lastEvent"].(map[string]interface{})["redirectedFromFunction"] = args[1]
+	amount, err := parseAmountArg(argsMap[AMOUNT], def.Decimals)
+	if err != nil {
+		err = fmt.Errorf("redeemAsset asset %s: %s", assetID, err)
+		log.Error(err)
+		return nil, err
+	}
+	if amount.Sign() <= 0 {
+		err = fmt.Errorf("redeemAsset amount must be positive, got %s", amount)
+		log.Error(err)
+		return nil, err
 	}
 
-	// marshal to JSON and write
-	stateJSON, err := json.Marshal(&stateOut)
+	sAccountKey := accountID + "_" + assetID
+	if !issueAccountIsActive(stub, sAccountKey) {
+		err = fmt.Errorf("redeemAsset account %s holds no asset %s", accountID, assetID)
+		log.Error(err)
+		return nil, err
+	}
+	holdingMap, holdingPrior, err := getHoldingFromLedger(stub, sAccountKey)
+	if err != nil {
+		err = fmt.Errorf("redeemAsset %s", err)
+		log.Error(err)
+		return nil, err
+	}
+	balance, err := holdingAmount(holdingMap, def.Decimals)
+	if err != nil {
+		err = fmt.Errorf("redeemAsset holding %s: %s", sAccountKey, err)
+		log.Error(err)
+		return nil, err
+	}
+	if balance.Cmp(amount) < 0 {
+		err = fmt.Errorf("redeemAsset account %s has insufficient balance of asset %s: %s < %s", accountID, assetID, balance, amount)
+		log.Error(err)
+		return nil, err
+	}
+	supply, err := def.circulatingSupply()
 	if err != nil {
-		err := fmt.Errorf("createAccount state for accountID %s failed to marshal", accountID)
+		err = fmt.Errorf("redeemAsset %s", err)
 		log.Error(err)
 		return nil, err
 	}
+	if supply.Cmp(amount) < 0 {
+		err = fmt.Errorf("redeemAsset asset %s supply of %s is less than %s", assetID, supply, amount)
+		log.Critical(err)
+		return nil, err
+	}
+	supply = supply.Sub(amount)
+	def.CirculatingSupply = supply.String()
+	holdingMap[AMOUNT] = balance.Sub(amount).String()
 
-	// finally, put the new state
-	log.Infof("Putting new account state %s to ledger", string(stateJSON))
-	// The key i 'assetid'_'type'
+	// save the original event
+	holdingMap["lastEvent"] = make(map[string]interface{})
+	holdingMap["lastEvent"].(map[string]interface{})["function"] = "redeemAsset"
+	holdingMap["lastEvent"].(map[string]interface{})["args"] = args[0]
 
-	err = stub.PutState(sAccountKey, []byte(stateJSON))
+	stateJSON, err := json.Marshal(holdingMap)
+	if err != nil {
+		err = fmt.Errorf("redeemAsset holding %s marshal failed: %s", sAccountKey, err)
+		log.Error(err)
+		return nil, err
+	}
+	defJSON, err := json.Marshal(def)
 	if err != nil {
-		err = fmt.Errorf("createAccount accountID %s PUTSTATE failed: %s", accountID, err)
+		err = fmt.Errorf("redeemAsset asset definition %s marshal failed: %s", assetID, err)
 		log.Error(err)
 		return nil, err
 	}
-	log.Infof("createAccount accountID  state %s successfully written to ledger: %s", accountID,  string(stateJSON))
 
-	// add asset to contract state
-	err = addAccountToContractState(stub, sAccountKey,"issue")
+	// the holding and the supply go to the ledger as a pair
+	err = putStatePair(stub, sAccountKey, stateJSON, holdingPrior, ASSETDEFINITIONKEYPREFIX+assetID, defJSON)
 	if err != nil {
-		err := fmt.Errorf("createAccount asset %s  failed to write asset state: %s", accountID,  err)
+		err = fmt.Errorf("redeemAsset %s", err)
 		log.Critical(err)
 		return nil, err
 	}
-     fmt.Println("stateJSON",stateJSON)
-	err = pushRecentState(stub, string(stateJSON),"2")
+	log.Infof("redeemAsset redeemed %s of asset %s from account %s, supply is now %s", amount, assetID, accountID, supply)
+
+	err = pushRecentState(stub, RECENTISSUANCE, string(stateJSON))
 	if err != nil {
-		err = fmt.Errorf("createAccount accountID %s  push to recentstates failed: %s", accountID,  err)
+		err = fmt.Errorf("redeemAsset holding %s push to recentstates failed: %s", sAccountKey, err)
 		log.Error(err)
 		return nil, err
 	}
 
-	// save state history
-	err = createStateHistory(stub, sAccountKey, string(stateJSON))
+	// add history state
+	err = updateStateHistory(stub, sAccountKey, string(stateJSON))
 	if err != nil {
-		err := fmt.Errorf("createAccount asset %s of type %s state history save failed: %s", accountID, sAccountKey, err)
-		log.Critical(err)
+		err = fmt.Errorf("redeemAsset holding %s push to history failed: %s", sAccountKey, err)
+		log.Error(err)
 		return nil, err
 	}
 	return nil, nil
@@ -3004,7 +2655,6 @@ func (t *SimpleChaincode) readAllIssue(stub shim.ChaincodeStubInterface, args []
 	}
 
 	aa, err := getissueActiveAccounts(stub)
-	fmt.Println("aa",aa)
 	if err != nil {
 		err = fmt.Errorf("readAllAccounts failed to get the active assets: %s", err)
 		log.Error(err)
@@ -3040,350 +2690,271 @@ func (t *SimpleChaincode) readAllIssue(stub shim.ChaincodeStubInterface, args []
 	return []byte(resultsStr), nil
 }
 
-func issueAccountIsActive(stub shim.ChaincodeStubInterface, sAssetKey string) (bool) {
-    var state ContractState
-    var err error
-    state, err = GETContractStateFromLedger(stub)
-    if err != nil { return false}
-    found, _ := state.IssueAccounts[sAssetKey]
-    return found
+func issueAccountIsActive(stub shim.ChaincodeStubInterface, sAssetKey string) bool {
+	return inIndex(stub, HOLDINGINDEX, sAssetKey)
 }
 
 func getissueActiveAccounts(stub shim.ChaincodeStubInterface) ([]string, error) {
-    var state ContractState
-    var err error
-    state, err = GETContractStateFromLedger(stub)  
-    if err != nil {
-        return []string{}, err
-    }
-    var a = make([]string, len(state.IssueAccounts))
-    i := 0
-    for id := range state.IssueAccounts {
-        a[i] = id
-        i++ 
-    }
-    sort.Strings(a)
-	fmt.Println("a:",a)
-    return a, nil
+	return indexMembers(stub, HOLDINGINDEX)
 }
 
+
 //*****************************************************************Transfer******************************************
 
+// Transfer is the argument to transferAsset, it moves amount units of assetID
+// from the accountID holding to the accountIDTo holding. The amount may be sent
+// as a JSON string or number and is checked against the scale of the asset.
+type Transfer struct {
+	AccountID   string      `json:"accountID"`
+	AccountIDTo string      `json:"accountIDTo"`
+	AssetID     string      `json:"assetID"`
+	Amount      json.Number `json:"amount"`
+}
+
+// ************************************
+// transferAsset
+// ************************************
 func (t *SimpleChaincode) transferAsset(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
-    //var state ContractState
-	var accountIDTo string
-	var assetID string
-    var accountID string
-	var argsMap ArgsMap
-	//var argsMapTo ArgsMap
-	var event interface{}
-	//var eventTo interface{}
-	var ledgerMap ArgsMap
-	var ledgerBytes interface{}
-	var found bool
+	var transfer Transfer
 	var err error
-	
-	//var timeIn time.Time
 
-	log.Info("Entering createAsset")
+	log.Info("Entering transferAsset")
 
-	// allowing 2 args because updateAsset is allowed to redirect when
-	// asset does not exist
-	if len(args) < 1 || len(args) > 2 {
-		err = errors.New("Expecting one JSON event object")
+	if len(args) != 1 {
+		err = errors.New("transferAsset expects one JSON transfer object with accountID, accountIDTo, assetID and amount")
 		log.Error(err)
 		return nil, err
 	}
+	log.Debugf("transferAsset arg: %s", args[0])
 
-	accountID=""
-	fmt.Println("args[0]=",args[0])
-	//state.TransferAccounts[]
-	jsonData:=args[0]
-	result := strings.Split(jsonData, ",")
-	fmt.Println("result of Split=" , result)
-	
-	fromAcc := make([]string, 3)
-	fromAcc[0] = result[1]
-	fromAcc[1] = result[2]
-	fromAcc[2] = result[3]
-	fmt.Println("fromAcc array=",fromAcc)
-	//strs := []string{"/id", "name", "amt"}
-    buf := &bytes.Buffer{}
-    gob.NewEncoder(buf).Encode(fromAcc)
-    bs := buf.Bytes()
-    fmt.Printf("%q", bs)
-	/*stringByte := strings.Join(fromAcc, "\x20\x00") // x20 = space and x00 = null
-  	fmt.Println([]byte(stringByte))  
-  	fmt.Println(string([]byte(stringByte)))*/
-	/*toAcc := make([]string, 3)
-	toAcc[0] = result[1]
-	toAcc[1] = result[2]
-	toAcc[2] = result[3]
-	fmt.Println("toAcc array=",toAcc)
-	/*var pro AccountFrom	
-    err = json.NewDecoder(strings.NewReader(jsonData)).Decode(&pro)*/
-
-    //fmt.Println(pro.AssetID)
-	eventBytes := []byte(bs)
-	log.Debugf("createAccount arg: %s", args[0])
-	fmt.Println("eventBytes=",eventBytes)
-	err = json.Unmarshal(eventBytes, &event)
+	err = json.Unmarshal([]byte(args[0]), &transfer)
 	if err != nil {
-		log.Errorf("createAccount failed to unmarshal arg: %s", err)
+		err = fmt.Errorf("transferAsset failed to unmarshal arg: %s", err)
+		log.Error(err)
 		return nil, err
 	}
-
-	if event == nil {
-		err = errors.New("createAccount unmarshal arg created nil event")
+	if transfer.AccountID == "" || transfer.AccountIDTo == "" || transfer.AssetID == "" {
+		err = errors.New("transferAsset arg must include accountID, accountIDTo and assetID")
 		log.Error(err)
 		return nil, err
 	}
-
-	argsMap, found = event.(map[string]interface{})
-
-// From Account
-	fmt.Println("argsMap",argsMap)
-	if !found {
-		err := errors.New("createAccount arg is not a map shape")
+	if transfer.AccountID == transfer.AccountIDTo {
+		err = fmt.Errorf("transferAsset cannot transfer from account %s to itself", transfer.AccountID)
 		log.Error(err)
 		return nil, err
 	}
-
-	// is accountID present or blank?
-	assetIDBytes, found := getObject(argsMap, ACCOUNTID)
-	fmt.Println("assetIDBytes1==",assetIDBytes)
-	
-	if found {
-		accountID, found = assetIDBytes.(string)
-		if !found || accountID == "" {
-			err := errors.New("createAccount arg does not include accountID ")
-			log.Error(err)
-			return nil, err
-		}
-	}
-	// Is asset name present?
-	assetTypeBytes, found := getObject(argsMap, ASSETID)
-	if found {
-		assetID, found = assetTypeBytes.(string)
-		if !found || assetID == "" {
-			err := errors.New("createAsset arg does not include accountName ")
-			log.Error(err)
-			return nil, err
-		}
-	}
-
-assetIDBytes1, found := getObject(argsMap, ACCOUNTIDTO)
-	fmt.Println("assetIDBytes1==",assetIDBytes)
-	
-	if found {
-		accountIDTo, found = assetIDBytes1.(string)
-		if !found || accountID == "" {
-			err := errors.New("createAccount arg does not include accountID ")
-			log.Error(err)
-			return nil, err
-		}
+	// both parties must be registered accounts in good standing
+	err = checkParty(stub, ACCOUNTID, transfer.AccountID)
+	if err == nil {
+		err = checkParty(stub, ACCOUNTIDTO, transfer.AccountIDTo)
 	}
-	sAccountKeyFrom := accountID + "_" + assetID
-
-      assetBytes, err := stub.GetState(sAccountKeyFrom)
-		fmt.Println("assetBytes",assetBytes)
 	if err != nil {
-		log.Errorf("updateAsset assetID %s of type %s GETSTATE failed: %s", assetID, accountID, err)
+		log.Errorf("transferAsset %s", err)
 		return nil, err
 	}
 
-	// unmarshal the existing state from the ledger to theinterface
-	err = json.Unmarshal(assetBytes, &ledgerBytes)
+	scale, err := getAssetScale(stub, transfer.AssetID)
 	if err != nil {
-		log.Errorf("updateAsset assetID %s of type %s unmarshal failed: %s", assetID, accountID, err)
-		return nil, err
-	}
-
-	// assert the existing state as a map
-
-	ledgerMap, found = ledgerBytes.(map[string]interface{})
-	if !found {
-		log.Errorf("updateAsset assetID %s of type %s LEDGER state is not a map shape", assetID, accountID)
+		err = fmt.Errorf("transferAsset %s", err)
+		log.Error(err)
 		return nil, err
 	}
-	
-	   ledgerMap["amount"] =ledgerMap["amount"].(float64) - argsMap["amount"].( float64)
-fmt.Println("ledgerMapFrom :",ledgerMap["amount"])
-  
-	 fmt.Println("ledgerMap1== " ,ledgerMap)
-	stateOut := map[string]interface{}(ledgerMap)
-	log.Debugf("updateAsset assetID %s merged state: %s of type %s", assetID, accountID, stateOut)
-
-	// save the original event
-	stateOut["lastEvent"] = make(map[string]interface{})
-	
-
-	// Write the new state to the ledger
-	stateJSON, err := json.Marshal(ledgerMap)
+	amount, err := parseAmountArg(transfer.Amount, scale)
 	if err != nil {
-		err = fmt.Errorf("updateAsset AssetID %s of type %s marshal failed: %s", assetID, accountID, err)
+		err = fmt.Errorf("transferAsset asset %s: %s", transfer.AssetID, err)
 		log.Error(err)
 		return nil, err
 	}
-
-	// finally, put the new state
-	err = stub.PutState(sAccountKeyFrom, []byte(stateJSON))
-	if err != nil {
-		err = fmt.Errorf("updateAsset AssetID %s of type %s PUTSTATE failed: %s", assetID, accountID, err)
+	if amount.Sign() <= 0 {
+		err = fmt.Errorf("transferAsset amount must be positive, got %s", amount)
 		log.Error(err)
 		return nil, err
 	}
 
-	err = pushRecentState(stub, string(stateJSON),"2")
-	if err != nil {
-		err = fmt.Errorf("updateAsset AssetID %s push to recentstates failed: %s", assetID, err)
+	sAccountKeyFrom := transfer.AccountID + "_" + transfer.AssetID
+	sAccountKeyTo := transfer.AccountIDTo + "_" + transfer.AssetID
+	if !issueAccountIsActive(stub, sAccountKeyFrom) {
+		err = fmt.Errorf("transferAsset account %s holds no asset %s", transfer.AccountID, transfer.AssetID)
 		log.Error(err)
 		return nil, err
 	}
+	// a first time recipient gets a new holding with a zero opening balance
+	newHolding := !issueAccountIsActive(stub, sAccountKeyTo)
 
-	// add history state
-	err = updateStateHistory(stub, sAccountKeyFrom, string(stateJSON))
+	fromMap, fromBytes, err := getHoldingFromLedger(stub, sAccountKeyFrom)
 	if err != nil {
-		err = fmt.Errorf("updateAsset AssetID %s of type %s push to history failed: %s", assetID, accountID, err)
+		err = fmt.Errorf("transferAsset %s", err)
 		log.Error(err)
 		return nil, err
 	}
-
-
-	
-
-	sAccountKeyTo := accountIDTo + "_" + assetID 
-	found = issueAccountIsActive(stub, sAccountKeyTo)
-	if found {
-	
-		
-		assetBytes, err = stub.GetState(sAccountKeyTo)
-		fmt.Println("assetBytes",assetBytes)
-	if err != nil {
-		log.Errorf("updateAsset assetID %s of type %s GETSTATE failed: %s", assetID, accountID, err)
-		return nil, err
+	var toMap ArgsMap
+	if newHolding {
+		err = checkNewHolding(stub, sAccountKeyTo)
+		if err != nil {
+			err = fmt.Errorf("transferAsset %s", err)
+			log.Error(err)
+			return nil, err
+		}
+		log.Noticef("transferAsset opening holding %s for account %s", sAccountKeyTo, transfer.AccountIDTo)
+		toMap = ArgsMap{
+			ACCOUNTID: transfer.AccountIDTo,
+			ASSETID:   transfer.AssetID,
+			AMOUNT:    ZeroAmount(scale).String(),
+		}
+	} else {
+		toMap, _, err = getHoldingFromLedger(stub, sAccountKeyTo)
+		if err != nil {
+			err = fmt.Errorf("transferAsset %s", err)
+			log.Error(err)
+			return nil, err
+		}
 	}
 
-	// unmarshal the existing state from the ledger to theinterface
-	err = json.Unmarshal(assetBytes, &ledgerBytes)
+	fromAmount, err := holdingAmount(fromMap, scale)
 	if err != nil {
-		log.Errorf("updateAsset assetID %s of type %s unmarshal failed: %s", assetID, accountID, err)
+		err = fmt.Errorf("transferAsset holding %s: %s", sAccountKeyFrom, err)
+		log.Error(err)
 		return nil, err
 	}
-
-	// assert the existing state as a map
-
-	ledgerMap, found = ledgerBytes.(map[string]interface{})
-	if !found {
-		log.Errorf("updateAsset assetID %s of type %s LEDGER state is not a map shape", assetID, accountID)
+	toAmount, err := holdingAmount(toMap, scale)
+	if err != nil {
+		err = fmt.Errorf("transferAsset holding %s: %s", sAccountKeyTo, err)
+		log.Error(err)
 		return nil, err
 	}
-	
-	  ledgerMap["amount"] =ledgerMap["amount"].(float64) + argsMap["amount"].( float64)
-     fmt.Println("ledgerMapTo :",ledgerMap["amount"])
-	 fmt.Println("ledgerMap2== " ,ledgerMap)
-	stateOut := map[string]interface{}(ledgerMap)
-	log.Debugf("updateAsset assetID %s merged state: %s of type %s", assetID, accountID, stateOut)
-
-	// save the original event
-	stateOut["lastEvent"] = make(map[string]interface{})
-	
-
-	// Write the new state to the ledger
-	stateJSON, err := json.Marshal(ledgerMap)
-	if err != nil {
-		err = fmt.Errorf("updateAsset AssetID %s of type %s marshal failed: %s", assetID, accountID, err)
+	if fromAmount.Cmp(amount) < 0 {
+		err = fmt.Errorf("transferAsset account %s has insufficient balance of asset %s: %s < %s",
+			transfer.AccountID, transfer.AssetID, fromAmount, amount)
 		log.Error(err)
 		return nil, err
 	}
 
-	// finally, put the new state
-	err = stub.PutState(sAccountKeyTo, []byte(stateJSON))
+	// both sides are known good, calculate and marshal them before touching the ledger
+	fromBefore := ledgerValue(stub, sAccountKeyFrom)
+	toBefore := ledgerValue(stub, sAccountKeyTo)
+	fromMap[AMOUNT] = fromAmount.Sub(amount).String()
+	toMap[AMOUNT] = toAmount.Add(amount).String()
+	for _, m := range []ArgsMap{fromMap, toMap} {
+		m["lastEvent"] = make(map[string]interface{})
+		m["lastEvent"].(map[string]interface{})["function"] = "transferAsset"
+		m["lastEvent"].(map[string]interface{})["args"] = args[0]
+	}
+	fromJSON, err := json.Marshal(fromMap)
 	if err != nil {
-		err = fmt.Errorf("updateAsset AssetID %s of type %s PUTSTATE failed: %s", assetID, accountID, err)
+		err = fmt.Errorf("transferAsset holding %s marshal failed: %s", sAccountKeyFrom, err)
 		log.Error(err)
 		return nil, err
 	}
-
-	err = pushRecentState(stub, string(stateJSON),"2")
+	toJSON, err := json.Marshal(toMap)
 	if err != nil {
-		err = fmt.Errorf("updateAsset AssetID %s push to recentstates failed: %s", assetID, err)
+		err = fmt.Errorf("transferAsset holding %s marshal failed: %s", sAccountKeyTo, err)
 		log.Error(err)
 		return nil, err
 	}
 
-	// add history state
-	err = updateStateHistory(stub, sAccountKeyTo, string(stateJSON))
+	// debit and credit go to the ledger as a pair
+	err = putStatePair(stub, sAccountKeyFrom, fromJSON, fromBytes, sAccountKeyTo, toJSON)
 	if err != nil {
-		err = fmt.Errorf("updateAsset AssetID %s of type %s push to history failed: %s", assetID, accountID, err)
-		log.Error(err)
+		err = fmt.Errorf("transferAsset %s", err)
+		log.Critical(err)
 		return nil, err
 	}
+	log.Infof("transferAsset moved %s of asset %s from account %s to account %s",
+		amount, transfer.AssetID, transfer.AccountID, transfer.AccountIDTo)
 
-	return nil, nil
-
-	} 
-//****************************************
-/*var argsTo []string
- argsTo = append(argsTo ,[]string{{"accountID": argsMap["accountIDTo"].(string),"assetID":argsMap["assetID"].(string),"amount":argsMap["amount"].(string)}})
-  //  argsTo := []string{ "{'accountID':"+argsMap["accountIDTo"]+",'assetID':"+argsMap["assetID"]+",'amount':"+argsMap["amount"]+"}"}
-	eventBytesTo := []byte(argsTo[0])
-	fmt.Println("to==",argsTo[0])
-	err = json.Unmarshal(eventBytesTo, &eventTo)
-	argsMapTo, found = eventTo.(map[string]interface{})
-	fmt.Println("argsMapTo==", argsMapTo)8*/
-	stateOut = argsMap 
+	if newHolding {
+		err = addHolding(stub, transfer.AccountIDTo, transfer.AssetID)
+		if err != nil {
+			err = fmt.Errorf("transferAsset holding %s failed to write contract state: %s", sAccountKeyTo, err)
+			log.Critical(err)
+			return nil, err
+		}
+	}
 
-	// save the original event
-	stateOut["lastEvent"] = make(map[string]interface{})
-	stateOut["lastEvent"].(map[string]interface{})["function"] = "transferAsset"
-	//stateOut["lastEvent"].(map[string]interface{})["args"] = args[0]
-	if len(args) == 2 {
-		// in-band protocol for redirect
-		stateOut["lastEvent"].(map[string]interface{})["redirectedFromFunction"] = args[1]
+	for _, h := range []struct {
+		key    string
+		state  []byte
+		isNew  bool
+		before interface{}
+		after  ArgsMap
+	}{{sAccountKeyFrom, fromJSON, false, fromBefore, fromMap}, {sAccountKeyTo, toJSON, newHolding, toBefore, toMap}} {
+		if h.isNew {
+			err = createStateHistory(stub, h.key, string(h.state))
+		} else {
+			err = updateStateHistory(stub, h.key, string(h.state))
+		}
+		if err != nil {
+			err = fmt.Errorf("transferAsset holding %s push to history failed: %s", h.key, err)
+			log.Error(err)
+			return nil, err
+		}
+		emitEvent(stub, EVENTASSETTRANSFERRED, h.key, h.before, h.after)
 	}
 
-	// marshal to JSON and write
-	stateJSON, err = json.Marshal(&stateOut)
+	transferJSON, err := json.Marshal(map[string]interface{}{
+		ACCOUNTID:   transfer.AccountID,
+		ACCOUNTIDTO: transfer.AccountIDTo,
+		ASSETID:     transfer.AssetID,
+		AMOUNT:      amount.String(),
+		"txID":      stub.GetTxID(),
+		"timestamp": txTimestamp(stub).Format(time.RFC3339Nano),
+	})
+	if err == nil {
+		err = pushRecentState(stub, RECENTTRANSFERS, string(transferJSON))
+	}
 	if err != nil {
-		err := fmt.Errorf("createAccount state for accountID %s failed to marshal", accountID)
+		err = fmt.Errorf("transferAsset push to recentstates failed: %s", err)
 		log.Error(err)
 		return nil, err
 	}
 
-	// finally, put the new state
-	log.Infof("Putting new account state %s to ledger", string(stateJSON))
-	// The key i 'assetid'_'type'
+	return nil, nil
+}
 
-	err = stub.PutState(sAccountKeyTo, []byte(stateJSON))
-	if err != nil {
-		err = fmt.Errorf("createAccount accountID %s PUTSTATE failed: %s", accountID, err)
-		log.Error(err)
-		return nil, err
-	}
-	log.Infof("createAccount accountID  state %s successfully written to ledger: %s", accountID,  string(stateJSON))
+// getHoldingFromLedger reads an accountID_assetID holding, returning both the map
+// and the raw bytes so that the caller can restore it
+func getHoldingFromLedger(stub shim.ChaincodeStubInterface, sAccountKey string) (ArgsMap, []byte, error) {
+	var ledgerBytes interface{}
 
-	// add asset to contract state
-	err = addAccountToContractState(stub, sAccountKeyTo,"Transfer")
+	holdingBytes, err := stub.GetState(sAccountKey)
 	if err != nil {
-		err := fmt.Errorf("createAccount asset %s  failed to write asset state: %s", accountID,  err)
-		log.Critical(err)
-		return nil, err
+		return nil, nil, fmt.Errorf("holding %s GETSTATE failed: %s", sAccountKey, err)
+	}
+	if len(holdingBytes) == 0 {
+		return nil, nil, fmt.Errorf("holding %s not found in ledger", sAccountKey)
 	}
-     fmt.Println("stateJSON== ",stateJSON)
-	err = pushRecentState(stub, string(stateJSON),"2")
+	err = json.Unmarshal(holdingBytes, &ledgerBytes)
 	if err != nil {
-		err = fmt.Errorf("createAccount accountID %s  push to recentstates failed: %s", accountID,  err)
-		log.Error(err)
-		return nil, err
+		return nil, nil, fmt.Errorf("holding %s unmarshal failed: %s", sAccountKey, err)
+	}
+	ledgerMap, found := ledgerBytes.(map[string]interface{})
+	if !found {
+		return nil, nil, fmt.Errorf("holding %s LEDGER state is not a map shape", sAccountKey)
 	}
+	return ArgsMap(ledgerMap), holdingBytes, nil
+}
 
-	// save state history
-	err = createStateHistory(stub, sAccountKeyTo, string(stateJSON))
+// putStatePair writes two states that must change together, if the second
+// write fails then the first key is put back to its prior state, or removed
+// when it had none
+func putStatePair(stub shim.ChaincodeStubInterface, firstKey string, firstJSON []byte, firstPrior []byte,
+	secondKey string, secondJSON []byte) error {
+	err := stub.PutState(firstKey, firstJSON)
 	if err != nil {
-		err := fmt.Errorf("createAccount asset %s of type %s state history save failed: %s", accountID, sAccountKeyTo, err)
-		log.Critical(err)
-		return nil, err
+		return fmt.Errorf("%s PUTSTATE failed: %s", firstKey, err)
 	}
-	return nil, nil
+	err = stub.PutState(secondKey, secondJSON)
+	if err != nil {
+		var rerr error
+		if len(firstPrior) == 0 {
+			rerr = stub.DelState(firstKey)
+		} else {
+			rerr = stub.PutState(firstKey, firstPrior)
+		}
+		if rerr != nil {
+			return fmt.Errorf("%s PUTSTATE failed: %s, and restoring %s failed: %s", secondKey, err, firstKey, rerr)
+		}
+		return fmt.Errorf("%s PUTSTATE failed, %s restored: %s", secondKey, firstKey, err)
+	}
+	return nil
 }
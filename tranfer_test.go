@@ -0,0 +1,182 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestTransferAsset(t *testing.T) {
+	s := withHoldings(t)
+	s.mustInvoke("transferAsset", `{"accountID":"alice","accountIDTo":"bob","assetID":"USD","amount":"30.25"}`)
+	if got := s.holding("alice", "USD"); got != "69.75" {
+		t.Errorf("alice holds %s, want 69.75", got)
+	}
+	if got := s.holding("bob", "USD"); got != "30.25" {
+		t.Errorf("bob holds %s, want 30.25", got)
+	}
+	// a transfer moves units, it does not change the supply
+	def := s.stateMap(ASSETDEFINITIONKEYPREFIX + "USD")
+	if def["circulatingSupply"] != "100.00" {
+		t.Errorf("circulating supply is %v after a transfer, want 100.00", def["circulatingSupply"])
+	}
+	if n := len(s.events); n == 0 || s.events[n-1] != EVENTASSETTRANSFERRED {
+		t.Errorf("events %q, want %s last", s.events, EVENTASSETTRANSFERRED)
+	}
+}
+
+// TestTransferAssetFails checks that a refused transfer leaves every state as
+// it was
+func TestTransferAssetFails(t *testing.T) {
+	tests := []struct {
+		name string
+		arg  string
+	}{
+		{"insufficient balance", `{"accountID":"alice","accountIDTo":"bob","assetID":"USD","amount":"100.01"}`},
+		{"too many decimals", `{"accountID":"alice","accountIDTo":"bob","assetID":"USD","amount":"0.001"}`},
+		{"zero", `{"accountID":"alice","accountIDTo":"bob","assetID":"USD","amount":"0"}`},
+		{"negative", `{"accountID":"alice","accountIDTo":"bob","assetID":"USD","amount":"-5"}`},
+		{"to itself", `{"accountID":"alice","accountIDTo":"alice","assetID":"USD","amount":"5"}`},
+		{"no holding", `{"accountID":"bob","accountIDTo":"alice","assetID":"USD","amount":"5"}`},
+		{"unknown recipient", `{"accountID":"alice","accountIDTo":"carol","assetID":"USD","amount":"5"}`},
+	}
+	for _, tt := range tests {
+		s := withHoldings(t)
+		before := s.snapshot()
+		events := len(s.events)
+		err := s.invoke("transferAsset", tt.arg)
+		if err == nil {
+			t.Errorf("%s: transfer succeeded", tt.name)
+			continue
+		}
+		s.assertUnchanged(before, tt.name)
+		if len(s.events) != events {
+			t.Errorf("%s: a failed transfer set events %q", tt.name, s.events[events:])
+		}
+	}
+}
+
+// TestTransferAssetRollsBack fails the credit after the debit was written, the
+// transaction must fail so that the debit is never committed
+func TestTransferAssetRollsBack(t *testing.T) {
+	s := withHoldings(t)
+	s.mustInvoke("transferAsset", `{"accountID":"alice","accountIDTo":"bob","assetID":"USD","amount":"10"}`)
+	before := s.snapshot()
+	s.failPut = "bob_USD"
+	s.mustFailInvoke("transferAsset", `{"accountID":"alice","accountIDTo":"bob","assetID":"USD","amount":"10"}`)
+	s.assertUnchanged(before, "a failed credit")
+	s.failPut = ""
+	s.mustInvoke("transferAsset", `{"accountID":"alice","accountIDTo":"bob","assetID":"USD","amount":"10"}`)
+	if a, b := s.holding("alice", "USD"), s.holding("bob", "USD"); a != "80.00" || b != "20.00" {
+		t.Errorf("alice holds %s and bob %s, want 80.00 and 20.00", a, b)
+	}
+}
+
+// TestPutStatePair writes straight to the ledger, as a peer that commits the
+// first write of a failed pair would, and checks that it is put back
+func TestPutStatePair(t *testing.T) {
+	tests := []struct {
+		name    string
+		prior   string
+		failPut string
+		want    string
+		fails   bool
+	}{
+		{"both written", `{"amount":"1"}`, "", `{"amount":"2"}`, false},
+		{"prior restored", `{"amount":"1"}`, "second", `{"amount":"1"}`, true},
+		{"new state removed", "", "second", "", true},
+		{"first write fails", `{"amount":"1"}`, "first", `{"amount":"1"}`, true},
+	}
+	for _, tt := range tests {
+		s := newMemStub(t)
+		if tt.prior != "" {
+			s.state["first"] = []byte(tt.prior)
+		}
+		s.failPut = tt.failPut
+		err := putStatePair(s, "first", []byte(`{"amount":"2"}`), []byte(tt.prior), "second", []byte(`{"amount":"3"}`))
+		if tt.fails != (err != nil) {
+			t.Errorf("%s: putStatePair = %v, want failure %v", tt.name, err, tt.fails)
+		}
+		if got := string(s.state["first"]); got != tt.want {
+			t.Errorf("%s: first is %q, want %q", tt.name, got, tt.want)
+		}
+		if _, found := s.state["second"]; found == tt.fails {
+			t.Errorf("%s: second written %v, want %v", tt.name, found, !tt.fails)
+		}
+	}
+}
+
+// TestTransferToNewHolder sends to bob, who has never held USD, and checks that
+// his holding is opened everywhere a holding issued to him would be
+func TestTransferToNewHolder(t *testing.T) {
+	s := withHoldings(t)
+	if s.holding("bob", "USD") != "" {
+		t.Fatal("bob holds USD before the transfer")
+	}
+	s.mustInvoke("transferAsset", `{"accountID":"alice","accountIDTo":"bob","assetID":"USD","amount":"0.01"}`)
+	if got := s.holding("bob", "USD"); got != "0.01" {
+		t.Errorf("bob holds %q, want 0.01", got)
+	}
+	if !inIndex(s, HOLDINGINDEX, "bob_USD") || !inIndex(s, HOLDERINDEX, holderKey("USD", "bob")) {
+		t.Error("bob's new holding is missing from the holding indexes")
+	}
+	if s.stateMap("bob_USD"+STATEHISTORYKEY) == nil {
+		t.Error("bob's new holding has no history")
+	}
+	portfolio := s.mustRead("readPortfolio", `{"accountID":"bob"}`).(map[string]interface{})
+	holdings, _ := portfolio["holdings"].([]interface{})
+	if len(holdings) != 1 || holdings[0].(map[string]interface{})["amount"] != "0.01" {
+		t.Errorf("bob's portfolio is %v, want 0.01 USD", portfolio)
+	}
+
+	// the second transfer credits the holding the first one opened
+	s.mustInvoke("transferAsset", `{"accountID":"alice","accountIDTo":"bob","assetID":"USD","amount":"1"}`)
+	if got := s.holding("bob", "USD"); got != "1.01" {
+		t.Errorf("bob holds %q after a second transfer, want 1.01", got)
+	}
+}
+
+func TestRedeemAsset(t *testing.T) {
+	tests := []struct {
+		name   string
+		caller string
+		frozen bool
+		ok     bool
+	}{
+		{"by the holder", "alice", false, true},
+		{"by an admin", "admin", false, true},
+		{"by the issuer", "bank", false, false},
+		{"by another account", "bob", false, false},
+		{"from a frozen account", "alice", true, false},
+		{"from a frozen account by an admin", "admin", true, false},
+	}
+	for _, tt := range tests {
+		s := withHoldings(t)
+		if tt.frozen {
+			s.asAdmin().mustInvoke("freezeAccount", `{"accountID":"alice","reason":"audit"}`)
+		}
+		if tt.caller == "admin" {
+			s.asAdmin()
+		} else {
+			s.as(tt.caller)
+		}
+		err := s.invoke("redeemAsset", `{"accountID":"alice","assetID":"USD","amount":"40"}`)
+		if tt.ok != (err == nil) {
+			t.Errorf("%s: redeemAsset = %v, want success %v", tt.name, err, tt.ok)
+		}
+		want := "100.00"
+		if tt.ok {
+			want = "60.00"
+		}
+		// the holding and the supply fall together
+		supply := s.stateMap(ASSETDEFINITIONKEYPREFIX + "USD")["circulatingSupply"]
+		if got := s.holding("alice", "USD"); got != want || supply != want {
+			t.Errorf("%s: alice holds %s of a supply of %v, want %s of %s", tt.name, got, supply, want, want)
+		}
+	}
+
+	s := withHoldings(t)
+	s.as("alice").mustFailInvoke("redeemAsset", `{"accountID":"alice","assetID":"USD","amount":"100.01"}`)
+	s.mustInvoke("redeemAsset", `{"accountID":"alice","assetID":"USD","amount":"100"}`)
+	if got := s.holding("alice", "USD"); got != "0.00" {
+		t.Errorf("alice holds %s after redeeming everything, want 0.00", got)
+	}
+}
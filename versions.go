@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//***************************************************
+//* ASSET VERSIONS
+//***************************************************
+
+// versionArg is the argument of readAssetAsOf and diffAssetVersions. A version
+// is a sequence number or an RFC3339 time, which selects the version that was
+// current at that time.
+type versionArg struct {
+	AssetID  string      `json:"assetID"`
+	AsOf     interface{} `json:"asOf"`
+	Sequence interface{} `json:"sequence"`
+	From     interface{} `json:"from"`
+	To       interface{} `json:"to"`
+}
+
+// PatchOperation is one RFC 6902 JSON Patch operation, From is the source of
+// a move or a copy
+type PatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	From  string      `json:"from,omitempty"`
+	Value interface{} `json:"value"`
+}
+
+// MarshalJSON leaves the value out of a remove, an add or a replace keeps its
+// value even when it is null
+func (o PatchOperation) MarshalJSON() ([]byte, error) {
+	if o.Op == "remove" {
+		return json.Marshal(map[string]string{"op": o.Op, "path": o.Path})
+	}
+	type operation PatchOperation
+	return json.Marshal(operation(o))
+}
+
+// VersionDiff is the diffAssetVersions result, Patch turns the From state into
+// the To state
+type VersionDiff struct {
+	AssetID   string           `json:"assetID"`
+	AssetType string           `json:"assettype"`
+	From      HistoryEntry     `json:"from"`
+	To        HistoryEntry     `json:"to"`
+	Patch     []PatchOperation `json:"patch"`
+}
+
+// versionHistory parses a versions argument and reads the history of the asset
+// it names
+func versionHistory(stub shim.ChaincodeStubInterface, function string, args []string) (versionArg, *stateHistory, AssetType, error) {
+	var arg versionArg
+	var argsMap ArgsMap
+
+	err := json.Unmarshal([]byte(args[0]), &arg)
+	if err == nil {
+		err = json.Unmarshal([]byte(args[0]), &argsMap)
+	}
+	if err != nil {
+		return arg, nil, AssetType{}, fmt.Errorf("%s failed to unmarshal arg: %s", function, err)
+	}
+	if arg.AssetID == "" {
+		return arg, nil, AssetType{}, fmt.Errorf("%s arg does not include assetID", function)
+	}
+	at, err := resolveAssetType(stub, argsMap, arg.AssetID)
+	if err != nil {
+		return arg, nil, at, fmt.Errorf("%s %s", function, err)
+	}
+	h, err := getStateHistory(stub, arg.AssetID+"_"+at.Name)
+	if err != nil {
+		return arg, nil, at, fmt.Errorf("%s %s", function, err)
+	}
+	if h.latest == 0 {
+		return arg, nil, at, fmt.Errorf("%s asset %s of type %s has no history", function, arg.AssetID, at.Name)
+	}
+	return arg, h, at, nil
+}
+
+// version returns the version a sequence number or time selects
+func (h *stateHistory) version(v interface{}) (HistoryEntry, error) {
+	var sequence int
+	switch val := v.(type) {
+	case float64:
+		sequence = int(val)
+		if float64(sequence) != val || sequence < 1 || sequence > h.latest {
+			return HistoryEntry{}, fmt.Errorf("sequence %v is not between 1 and %d", val, h.latest)
+		}
+	case string:
+		t, err := time.Parse(time.RFC3339Nano, val)
+		if err != nil {
+			return HistoryEntry{}, fmt.Errorf("%s is neither a sequence number nor an RFC3339 time", val)
+		}
+		after, err := h.firstAfter(t, false)
+		if err != nil {
+			return HistoryEntry{}, err
+		}
+		sequence = after - 1
+		if sequence < 1 {
+			return HistoryEntry{}, fmt.Errorf("there is no version at or before %s", val)
+		}
+	default:
+		return HistoryEntry{}, errors.New("a version must be a sequence number or an RFC3339 time")
+	}
+	return h.entry(sequence)
+}
+
+// ************************************
+// readAssetAsOf
+// ************************************
+func (t *SimpleChaincode) readAssetAsOf(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	arg, h, _, err := versionHistory(stub, "readAssetAsOf", args)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	selector := arg.AsOf
+	if (arg.AsOf == nil) == (arg.Sequence == nil) {
+		err = errors.New("readAssetAsOf arg must include either asOf or sequence")
+		log.Error(err)
+		return nil, err
+	}
+	if arg.Sequence != nil {
+		selector = arg.Sequence
+	}
+	e, err := h.version(selector)
+	if err != nil {
+		err = fmt.Errorf("readAssetAsOf asset %s %s", arg.AssetID, err)
+		log.Error(err)
+		return nil, err
+	}
+	return json.Marshal(e)
+}
+
+// ************************************
+// diffAssetVersions
+// ************************************
+func (t *SimpleChaincode) diffAssetVersions(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	arg, h, at, err := versionHistory(stub, "diffAssetVersions", args)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	if arg.From == nil {
+		err = errors.New("diffAssetVersions arg does not include from")
+		log.Error(err)
+		return nil, err
+	}
+	if arg.To == nil {
+		// the current version
+		arg.To = float64(h.latest)
+	}
+	diff := VersionDiff{AssetID: arg.AssetID, AssetType: at.Name}
+	diff.From, err = h.version(arg.From)
+	if err == nil {
+		diff.To, err = h.version(arg.To)
+	}
+	if err != nil {
+		err = fmt.Errorf("diffAssetVersions asset %s %s", arg.AssetID, err)
+		log.Error(err)
+		return nil, err
+	}
+	var fromState, toState interface{}
+	fromState, err = decodeState(diff.From.State)
+	if err == nil {
+		toState, err = decodeState(diff.To.State)
+	}
+	if err != nil {
+		err = fmt.Errorf("diffAssetVersions asset %s %s", arg.AssetID, err)
+		log.Error(err)
+		return nil, err
+	}
+	diff.Patch = diffValues("", fromState, toState, []PatchOperation{})
+	return json.Marshal(diff)
+}
+
+// decodeState keeps numbers as written so that a patch never rounds them
+func decodeState(state json.RawMessage) (interface{}, error) {
+	var v interface{}
+	decoder := json.NewDecoder(bytes.NewReader(state))
+	decoder.UseNumber()
+	err := decoder.Decode(&v)
+	if err != nil {
+		return nil, fmt.Errorf("version state failed to unmarshal: %s", err)
+	}
+	return v, nil
+}
+
+// diffValues appends to patch the operations that turn a into b at path
+func diffValues(path string, a interface{}, b interface{}, patch []PatchOperation) []PatchOperation {
+	switch av := a.(type) {
+	case map[string]interface{}:
+		bv, found := b.(map[string]interface{})
+		if !found {
+			break
+		}
+		for _, k := range sortedKeys(av) {
+			if _, found := bv[k]; !found {
+				patch = append(patch, PatchOperation{Op: "remove", Path: path + "/" + escapePointer(k)})
+			}
+		}
+		for _, k := range sortedKeys(bv) {
+			if old, found := av[k]; found {
+				patch = diffValues(path+"/"+escapePointer(k), old, bv[k], patch)
+			} else {
+				patch = append(patch, PatchOperation{Op: "add", Path: path + "/" + escapePointer(k), Value: bv[k]})
+			}
+		}
+		return patch
+	case []interface{}:
+		bv, found := b.([]interface{})
+		if !found {
+			break
+		}
+		common := len(av)
+		if len(bv) < common {
+			common = len(bv)
+		}
+		for i := 0; i < common; i++ {
+			patch = diffValues(path+"/"+strconv.Itoa(i), av[i], bv[i], patch)
+		}
+		// removing from the end keeps the indexes of the rest valid
+		for i := len(av) - 1; i >= common; i-- {
+			patch = append(patch, PatchOperation{Op: "remove", Path: path + "/" + strconv.Itoa(i)})
+		}
+		for i := common; i < len(bv); i++ {
+			patch = append(patch, PatchOperation{Op: "add", Path: path + "/-", Value: bv[i]})
+		}
+		return patch
+	}
+	if !reflect.DeepEqual(a, b) {
+		patch = append(patch, PatchOperation{Op: "replace", Path: path, Value: b})
+	}
+	return patch
+}
+
+// escapePointer escapes a property name for a JSON Pointer, RFC 6901
+func escapePointer(name string) string {
+	return strings.Replace(strings.Replace(name, "~", "~0", -1), "/", "~1", -1)
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
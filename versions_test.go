@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestEscapePointer(t *testing.T) {
+	tests := []struct {
+		name, want string
+	}{
+		{"foo", "foo"},
+		{"a/b", "a~1b"},
+		{"m~n", "m~0n"},
+		// ~ first, or ~1 would come back as /
+		{"~1", "~01"},
+		{"/~", "~1~0"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		got := escapePointer(tt.name)
+		if got != tt.want {
+			t.Errorf("escapePointer(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+		tokens, err := parsePointer("/" + got)
+		if err != nil || len(tokens) != 1 || tokens[0] != tt.name {
+			t.Errorf("parsePointer(/%s) = %q, %v, want %q", got, tokens, err, tt.name)
+		}
+	}
+}
+
+// TestDiffValues checks the patch diffValues writes and that applying it to
+// the first state gives the second
+func TestDiffValues(t *testing.T) {
+	tests := []struct {
+		name  string
+		a, b  string
+		patch string
+	}{
+		{"equal", `{"a":1,"b":[1,{"c":2}]}`, `{"a":1,"b":[1,{"c":2}]}`, `[]`},
+		{"replace a member", `{"a":1}`, `{"a":2}`, `[{"op":"replace","path":"/a","value":2}]`},
+		{"add and remove members", `{"a":1,"b":2}`, `{"b":2,"c":3}`,
+			`[{"op":"remove","path":"/a"},{"op":"add","path":"/c","value":3}]`},
+		{"a null member is kept", `{"a":1}`, `{"a":null}`, `[{"op":"replace","path":"/a","value":null}]`},
+		{"add a null member", `{}`, `{"a":null}`, `[{"op":"add","path":"/a","value":null}]`},
+		{"nested member", `{"a":{"b":{"c":1}}}`, `{"a":{"b":{"c":2}}}`,
+			`[{"op":"replace","path":"/a/b/c","value":2}]`},
+		{"escaped names", `{"a/b":1,"m~n":1}`, `{"a/b":2}`,
+			`[{"op":"remove","path":"/m~0n"},{"op":"replace","path":"/a~1b","value":2}]`},
+		{"type change", `{"a":{"b":1}}`, `{"a":[1]}`, `[{"op":"replace","path":"/a","value":[1]}]`},
+		{"number to string", `{"a":1}`, `{"a":"1"}`, `[{"op":"replace","path":"/a","value":"1"}]`},
+		{"array element", `{"a":[1,2,3]}`, `{"a":[1,5,3]}`, `[{"op":"replace","path":"/a/1","value":5}]`},
+		{"shorter array", `{"a":[1,2,3]}`, `{"a":[1]}`,
+			`[{"op":"remove","path":"/a/2"},{"op":"remove","path":"/a/1"}]`},
+		{"longer array", `{"a":[1]}`, `{"a":[1,2,3]}`,
+			`[{"op":"add","path":"/a/-","value":2},{"op":"add","path":"/a/-","value":3}]`},
+		{"empty key", `{"":1}`, `{"":2}`, `[{"op":"replace","path":"/","value":2}]`},
+		{"whole document", `{"a":1}`, `[1]`, `[{"op":"replace","path":"","value":[1]}]`},
+	}
+	for _, tt := range tests {
+		a, b := decodeJSON(t, tt.a), decodeJSON(t, tt.b)
+		patch := diffValues("", a, b, []PatchOperation{})
+		got, err := json.Marshal(patch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tt.patch {
+			t.Errorf("%s: diffValues = %s, want %s", tt.name, got, tt.patch)
+		}
+		applied, err := applyPatch(a, patch)
+		if err != nil {
+			t.Errorf("%s: applying the diff failed: %s", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(applied, b) {
+			t.Errorf("%s: applying the diff gives %v, want %s", tt.name, applied, tt.b)
+		}
+	}
+}